@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Activity tells when the commits of a repository are made, in the given timezone. Heatmap counts the commits
+// by weekday, sunday first, and hour of the day. Weeks counts them over the last weeks, oldest first.
+type Activity struct {
+	RepositoryID  int           `json:"repository_id"`
+	Branch        string        `json:"branch"`
+	Timezone      string        `json:"timezone"`
+	Commits       int           `json:"commits"`
+	ActiveDays    int           `json:"active_days"`
+	Heatmap       [7][24]int    `json:"heatmap"`
+	Weeks         []WeekCount   `json:"weeks"`
+	LongestStreak *ActivitySpan `json:"longest_streak"`
+	LongestGap    *ActivitySpan `json:"longest_gap"`
+	CurrentStreak int           `json:"current_streak"`
+	DaysSinceLast int           `json:"days_since_last"`
+}
+
+// WeekCount is how many commits were made during the week starting on monday Week
+type WeekCount struct {
+	Week    time.Time `json:"week"`
+	Commits int       `json:"commits"`
+}
+
+// ActivitySpan is a run of days, From and To included. For a streak every one of them has commits,
+// for a gap none has.
+type ActivitySpan struct {
+	Days int       `json:"days"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GetActivity builds the activity of the repository from the dates of its stored commits, counting only
+// the commits reachable from branch if it's given
+func GetActivity(repo_id int, branch string, loc *time.Location, weeks int) (*Activity, error) {
+	commits, err := store.GetAuthoredCommits(repo_id, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	a := ActivityReport(commits, loc, weeks, time.Now())
+	a.RepositoryID = repo_id
+	a.Branch = branch
+	return a, nil
+}
+
+// loadTimezone returns the location of a timezone name like Europe/Paris, UTC if it's empty
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q : %v", name, err)
+	}
+	return loc, nil
+}
+
+// dayNumber numbers the calendar day of t in loc, so consecutive days differ by one whatever the daylight saving
+func dayNumber(t time.Time, loc *time.Location) int {
+	t = t.In(loc)
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// dayOf returns the midnight starting the day numbered n in loc
+func dayOf(n int, loc *time.Location) time.Time {
+	d := time.Unix(int64(n)*86400, 0).UTC()
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc)
+}
+
+// ActivityReport counts the commits by weekday and hour and over the last weeks ending now, and finds the longest
+// runs of days with and without commits, all in loc
+func ActivityReport(commits []AuthoredCommit, loc *time.Location, weeks int, now time.Time) *Activity {
+	a := &Activity{Timezone: loc.String(), Commits: len(commits), Weeks: []WeekCount{}}
+
+	days := map[int]bool{}
+	first, last := 0, 0
+	for _, c := range commits {
+		t := c.Date.In(loc)
+		a.Heatmap[t.Weekday()][t.Hour()]++
+
+		n := dayNumber(c.Date, loc)
+		if len(days) == 0 || n < first {
+			first = n
+		}
+		if len(days) == 0 || n > last {
+			last = n
+		}
+		days[n] = true
+	}
+	a.ActiveDays = len(days)
+
+	// weeks ending with the current one
+	current := weekOf(now.In(loc))
+	counts := map[int64]int{}
+	for _, c := range commits {
+		counts[weekOf(c.Date.In(loc)).Unix()]++
+	}
+	for i := weeks - 1; i >= 0; i-- {
+		w := current.AddDate(0, 0, -7*i)
+		a.Weeks = append(a.Weeks, WeekCount{Week: w, Commits: counts[w.Unix()]})
+	}
+
+	if len(days) == 0 {
+		return a
+	}
+
+	// walk the days from the first commit to the last one
+	streak, gap := 0, 0
+	for n := first; n <= last; n++ {
+		if days[n] {
+			streak++
+			gap = 0
+			if a.LongestStreak == nil || streak > a.LongestStreak.Days {
+				a.LongestStreak = &ActivitySpan{Days: streak, From: dayOf(n-streak+1, loc), To: dayOf(n, loc)}
+			}
+		} else {
+			gap++
+			streak = 0
+			if a.LongestGap == nil || gap > a.LongestGap.Days {
+				a.LongestGap = &ActivitySpan{Days: gap, From: dayOf(n-gap+1, loc), To: dayOf(n, loc)}
+			}
+		}
+	}
+
+	today := dayNumber(now, loc)
+	a.DaysSinceLast = today - last
+	if a.DaysSinceLast <= 1 {
+		// the streak still runs if there were commits today or yesterday
+		for n := last; days[n]; n-- {
+			a.CurrentStreak++
+		}
+	}
+
+	return a
+}
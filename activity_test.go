@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityReport(t *testing.T) {
+	at := func(day, hour, min int) AuthoredCommit {
+		return AuthoredCommit{Date: time.Date(2024, 3, day, hour, min, 0, 0, time.UTC)}
+	}
+	commits := []AuthoredCommit{
+		at(1, 10, 0), at(2, 10, 0), at(3, 10, 0),
+		// a sunday night in UTC, monday morning two hours east
+		at(10, 23, 30),
+		at(11, 10, 0), at(13, 9, 0), at(13, 15, 0),
+	}
+	now := time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		loc           *time.Location
+		activeDays    int
+		heatmap       [][3]int
+		weeks         []int
+		gap           int
+		gapFrom       int
+		currentStreak int
+	}{
+		{"utc", time.UTC, 6, [][3]int{{0, 23, 1}, {3, 9, 1}, {3, 15, 1}, {5, 10, 1}}, []int{3, 1, 3}, 6, 4, 1},
+		{"east", time.FixedZone("UTC+2", 2*60*60), 5, [][3]int{{1, 1, 1}, {3, 11, 1}, {3, 17, 1}, {5, 12, 1}}, []int{3, 0, 4}, 7, 4, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := ActivityReport(commits, tt.loc, 3, now)
+			if a.Commits != 7 || a.ActiveDays != tt.activeDays {
+				t.Errorf("commits = %d on %d days, want 7 on %d", a.Commits, a.ActiveDays, tt.activeDays)
+			}
+			for _, h := range tt.heatmap {
+				if a.Heatmap[h[0]][h[1]] != h[2] {
+					t.Errorf("heatmap on day %d at %d = %d, want %d", h[0], h[1], a.Heatmap[h[0]][h[1]], h[2])
+				}
+			}
+
+			if len(a.Weeks) != len(tt.weeks) {
+				t.Fatalf("weeks = %+v", a.Weeks)
+			}
+			for i, w := range a.Weeks {
+				monday := time.Date(2024, 2, 26+7*i, 0, 0, 0, 0, tt.loc)
+				if !w.Week.Equal(monday) || w.Commits != tt.weeks[i] {
+					t.Errorf("week %d = %+v, want %d commits on %v", i, w, tt.weeks[i], monday)
+				}
+			}
+
+			day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, tt.loc) }
+			if s := a.LongestStreak; s == nil || s.Days != 3 || !s.From.Equal(day(1)) || !s.To.Equal(day(3)) {
+				t.Errorf("longest streak = %+v, want the 3 days from march 1st", s)
+			}
+			if g := a.LongestGap; g == nil || g.Days != tt.gap || !g.From.Equal(day(tt.gapFrom)) {
+				t.Errorf("longest gap = %+v, want %d days from march %d", g, tt.gap, tt.gapFrom)
+			}
+			if a.CurrentStreak != tt.currentStreak || a.DaysSinceLast != 1 {
+				t.Errorf("current streak = %d and %d days since the last commit, want %d and 1", a.CurrentStreak, a.DaysSinceLast, tt.currentStreak)
+			}
+		})
+	}
+
+	// no commits, only the weeks
+	a := ActivityReport(nil, time.UTC, 2, now)
+	if a.Commits != 0 || len(a.Weeks) != 2 || a.LongestStreak != nil || a.LongestGap != nil {
+		t.Errorf("activity without commits = %+v", a)
+	}
+
+	// the streak is over once a whole day went by without commits
+	a = ActivityReport(commits, time.UTC, 1, now.AddDate(0, 0, 2))
+	if a.CurrentStreak != 0 || a.DaysSinceLast != 3 {
+		t.Errorf("current streak = %d and %d days since the last commit, want 0 and 3", a.CurrentStreak, a.DaysSinceLast)
+	}
+}
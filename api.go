@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// registerAPI adds the rest api endpoints to the mux
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+
+	mux.HandleFunc("GET /contributors", apiListContributors)
+	mux.HandleFunc("POST /contributors/merge", apiMergeContributors)
+	mux.HandleFunc("POST /contributors/mailmap", apiApplyMailmap)
+	mux.HandleFunc("GET /repos", apiListRepos)
+	mux.HandleFunc("POST /repos", apiAddRepo)
+	mux.HandleFunc("GET /repos/{id}", apiGetRepo)
+	mux.HandleFunc("DELETE /repos/{id}", apiDeleteRepo)
+	mux.HandleFunc("GET /repos/{id}/snapshots", apiListSnapshots)
+	mux.HandleFunc("GET /repos/{id}/trend", apiRepoTrend)
+	mux.HandleFunc("GET /repos/{id}/commits", apiListCommits)
+	mux.HandleFunc("GET /repos/{id}/commits/stats", apiCommitStats)
+	mux.HandleFunc("GET /repos/{id}/authors/top", apiTopAuthors)
+	mux.HandleFunc("POST /repos/{id}/pull", apiPullCommits)
+	mux.HandleFunc("GET /repos/{id}/branches", apiListBranches)
+	mux.HandleFunc("GET /repos/{id}/pulls", apiListPulls)
+	mux.HandleFunc("GET /repos/{id}/pulls/metrics", apiPullMetrics)
+	mux.HandleFunc("GET /repos/{id}/issues", apiListIssues)
+	mux.HandleFunc("GET /repos/{id}/issues/count", apiCountIssues)
+	mux.HandleFunc("GET /repos/{id}/issues/backlog", apiIssueBacklog)
+	mux.HandleFunc("GET /repos/{id}/releases", apiListReleases)
+	mux.HandleFunc("GET /repos/{id}/releases/{tag}/commits", apiReleaseCommits)
+	mux.HandleFunc("GET /repos/{id}/tags", apiListTags)
+	mux.HandleFunc("GET /repos/{id}/deployments", apiListDeployments)
+	mux.HandleFunc("GET /repos/{id}/dora", apiDORA)
+	mux.HandleFunc("GET /repos/{id}/ci/runs", apiListRuns)
+	mux.HandleFunc("GET /repos/{id}/ci/health", apiCIHealth)
+	mux.HandleFunc("GET /repos/{id}/ci/durations", apiRunDurations)
+	mux.HandleFunc("GET /repos/{id}/ownership", apiOwnership)
+	mux.HandleFunc("GET /repos/{id}/activity", apiActivity)
+	mux.HandleFunc("POST /repos/{id}/churn/fetch", apiFetchCommitFiles)
+	mux.HandleFunc("GET /repos/{id}/churn/dirs", apiChurnDirs)
+	mux.HandleFunc("GET /repos/{id}/churn/files", apiChurnFiles)
+	mux.HandleFunc("GET /repos/{id}/churn/authors", apiChurnAuthors)
+	mux.HandleFunc("GET /repos/{id}/churn/coupling", apiChurnCoupling)
+	mux.HandleFunc("PUT /repos/{id}/branches/{branch...}", apiUpdateBranch)
+}
+
+// writeJSON writes v as the json response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		LogError(fmt.Errorf("error writing api response : %v", err))
+	}
+}
+
+// writeError writes an error response, {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= 500 {
+		LogError(fmt.Errorf("api error : %v", err))
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// pathRepo loads the repository identified by the {id} path parameter, writing the error response if it can't
+func pathRepo(w http.ResponseWriter, r *http.Request) (*Repository, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid repository id %q", r.PathValue("id")))
+		return nil, false
+	}
+
+	repo, err := store.GetRepoByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("repository %d not found", id))
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return repo, true
+}
+
+// queryTime parses an optional RFC3339 or YYYY-MM-DD query parameter
+func queryTime(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid %s %q, use RFC3339 or YYYY-MM-DD", name, v)
+}
+
+// queryInt parses an optional integer query parameter, def if it's missing
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+
+	return n, nil
+}
+
+// queryOr returns an optional query parameter, def if it's missing
+func queryOr(r *http.Request, name, def string) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func apiListRepos(w http.ResponseWriter, r *http.Request) {
+	minStars, err := queryInt(r, "min_stars", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	repos, err := store.ListRepos(RepoFilter{Language: r.URL.Query().Get("language"), MinStars: minStars})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func apiGetRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, repo)
+}
+
+func apiListSnapshots(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	start, err := windowStart(queryOr(r, "window", WindowMonth), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	snaps, err := store.GetSnapshots(repo.ID, start)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snaps)
+}
+
+func apiRepoTrend(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	window := queryOr(r, "window", WindowWeek)
+	if _, err := windowStart(window, time.Now()); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	trend, err := store.GetTrend(repo.ID, window)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, trend)
+}
+
+func apiAddRepo(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		URL string `json:"url"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body : %v", err))
+		return
+	}
+
+	URL, err := SanitizeRepoURL(body.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	repo, err := FetchRepo(URL)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			writeError(w, http.StatusNotFound, fmt.Errorf("repository not found on github"))
+			return
+		}
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	// read it back to get the id assigned by the database
+	repo, err = store.GetRepoByURL(repo.URL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, repo)
+}
+
+func apiDeleteRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	err := store.DeleteRepo(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// encodeCursor turns the position of a commit into an opaque pagination cursor
+func encodeCursor(c Commit) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Date.UTC().Format(time.RFC3339Nano) + "|" + c.SHA))
+}
+
+func decodeCursor(cursor string) (*CommitCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	date, sha, found := strings.Cut(string(data), "|")
+	if !found {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &CommitCursor{Date: t, SHA: sha}, nil
+}
+
+func apiListCommits(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	f := CommitFilter{Author: r.URL.Query().Get("author"), Branch: r.URL.Query().Get("branch"), Merges: r.URL.Query().Get("merges"),
+		Message: r.URL.Query().Get("message")}
+	if f.Merges != "" && f.Merges != MergesOnly && f.Merges != MergesExclude {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("merges must be only or exclude"))
+		return
+	}
+	err := store.CheckRegexp(f.Message)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid message pattern : %v", err))
+		return
+	}
+
+	f.Since, err = queryTime(r, "since")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f.Until, err = queryTime(r, "until")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	f.Limit, err = queryInt(r, "limit", 100)
+	if err != nil || f.Limit == 0 || f.Limit > 1000 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and 1000"))
+		return
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		f.After, err = decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// fetch one extra commit to know if there's a next page
+	limit := f.Limit
+	f.Limit++
+	commits, err := store.ListCommits(repo.ID, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := struct {
+		Commits    []Commit `json:"commits"`
+		NextCursor string   `json:"next_cursor,omitempty"`
+	}{Commits: commits}
+	if len(commits) > limit {
+		response.Commits = commits[:limit]
+		response.NextCursor = encodeCursor(commits[limit-1])
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func apiCommitStats(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := store.GetCommitStats(repo.ID, r.URL.Query().Get("branch"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func apiTopAuthors(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := queryInt(r, "n", 10)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	authors, err := store.GetTopAuthors(repo.ID, n, r.URL.Query().Get("branch"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authors)
+}
+
+func apiPullCommits(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	since, err := queryTime(r, "since")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var result *SyncResult
+	switch {
+	case r.URL.Query().Get("full") == "true":
+		result, err = ResyncCommits(repo)
+	case since != nil:
+		result, err = PullCommitsSince(repo, *since)
+	default:
+		result, err = SyncCommits(repo)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func apiListBranches(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	branches, err := store.GetBranches(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// not fetched by a refresh yet
+	if len(branches) == 0 {
+		branches, err = FetchBranches(repo)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, branches)
+}
+
+func apiUpdateBranch(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	body := struct {
+		Tracked *bool `json:"tracked"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Tracked == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf(`invalid request body, expected {"tracked": true|false}`))
+		return
+	}
+
+	branch, err := findBranch(repo, r.PathValue("branch"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	err = store.TrackBranch(repo.ID, branch.Name, *body.Tracked)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	branch.Tracked = *body.Tracked
+
+	writeJSON(w, http.StatusOK, branch)
+}
+
+func apiListPulls(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	f := PullRequestFilter{State: r.URL.Query().Get("state"), Author: r.URL.Query().Get("author")}
+	switch f.State {
+	case "", PullOpen, PullMerged, PullClosed:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid state %q, use open, merged or closed", f.State))
+		return
+	}
+
+	var err error
+	f.Since, err = queryTime(r, "since")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f.Until, err = queryTime(r, "until")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	prs, err := store.ListPullRequests(repo.ID, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, prs)
+}
+
+func apiPullMetrics(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := GetPullMetrics(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+// queryIssueFilter reads the issue filter from the query parameters
+func queryIssueFilter(r *http.Request) IssueFilter {
+	q := r.URL.Query()
+	return IssueFilter{
+		State:     q.Get("state"),
+		Label:     q.Get("label"),
+		Assignee:  q.Get("assignee"),
+		Author:    q.Get("author"),
+		Milestone: q.Get("milestone"),
+	}
+}
+
+func apiListIssues(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	issues, err := store.ListIssues(repo.ID, queryIssueFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, issues)
+}
+
+func apiCountIssues(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := store.CountIssues(repo.ID, queryIssueFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"issues": n})
+}
+
+func apiIssueBacklog(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	weeks, err := queryInt(r, "weeks", 12)
+	if err != nil || weeks == 0 || weeks > 520 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("weeks must be between 1 and 520"))
+		return
+	}
+
+	// the backlog is about issues open at the time, whatever their state now
+	f := queryIssueFilter(r)
+	f.State = ""
+	issues, err := store.ListIssues(repo.ID, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IssueBacklog(issues, weeks, time.Now()))
+}
+
+func apiListReleases(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	releases, err := store.GetReleases(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, releases)
+}
+
+// apiReleaseCommits lists the commits of a release, tags holding a slash are given url encoded
+func apiReleaseCommits(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	commits, err := store.GetReleaseCommits(repo.ID, r.PathValue("tag"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, commits)
+}
+
+func apiListTags(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := store.GetTags(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func apiListDeployments(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	deployments, err := store.GetDeployments(repo.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+func apiDORA(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	period := queryOr(r, "period", "week")
+	_, err := periodStart(time.Now(), period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	n, err := queryInt(r, "n", 12)
+	if err != nil || n > 520 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("n must be between 0 and 520"))
+		return
+	}
+
+	d, err := GetDORA(repo, r.URL.Query().Get("environment"), period, n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func apiListRuns(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := queryInt(r, "limit", 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f := WorkflowRunFilter{Workflow: r.URL.Query().Get("workflow"), Branch: r.URL.Query().Get("branch"), Limit: limit}
+	f.Since, err = queryTime(r, "since")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	runs, err := store.GetWorkflowRuns(repo.ID, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// apiCIHealth reports the health of each workflow along with the flaky runs and the breakages of the default branch
+func apiCIHealth(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	days, err := queryInt(r, "days", 90)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h, err := GetCIHealth(repo, days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h)
+}
+
+func apiRunDurations(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	weeks, err := queryInt(r, "weeks", 12)
+	if err != nil || weeks < 1 || weeks > 520 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("weeks must be between 1 and 520"))
+		return
+	}
+
+	trends, err := GetRunDurations(repo.ID, r.URL.Query().Get("workflow"), weeks)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, trends)
+}
+
+func apiOwnership(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	days, err := queryInt(r, "inactive_days", 90)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	depth, err := queryInt(r, "depth", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	n, err := queryInt(r, "n", 3)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	o, err := GetOwnership(repo.ID, r.URL.Query().Get("branch"), days, depth, n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, o)
+}
+
+func apiActivity(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	loc, err := loadTimezone(r.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	weeks, err := queryInt(r, "weeks", 52)
+	if err != nil || weeks > 520 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("weeks must be between 0 and 520"))
+		return
+	}
+
+	a, err := GetActivity(repo.ID, r.URL.Query().Get("branch"), loc, weeks)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a)
+}
+
+func apiFetchCommitFiles(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fetched, err := SyncCommitFiles(repo, limit)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	pending, err := store.GetCommitsWithoutFiles(repo.ID, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"fetched": fetched, "pending": len(pending)})
+}
+
+// queryChurnSince returns the start of the churn reports from the weeks parameter, nil for all time
+func queryChurnSince(w http.ResponseWriter, r *http.Request) (*time.Time, bool) {
+	weeks, err := queryInt(r, "weeks", 12)
+	if err != nil || weeks > 520 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("weeks must be between 0 and 520"))
+		return nil, false
+	}
+	return churnSince(weeks, time.Now()), true
+}
+
+// queryFileChanges loads the file changes the churn reports are made of, answering the request on error
+func queryFileChanges(w http.ResponseWriter, r *http.Request, repo *Repository) ([]FileChange, bool) {
+	since, ok := queryChurnSince(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	changes, err := store.GetFileChanges(repo.ID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return changes, true
+}
+
+func apiChurnDirs(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	depth, err := queryInt(r, "depth", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes, ok := queryFileChanges(w, r, repo)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ChurnByDirectory(changes, depth))
+}
+
+func apiChurnFiles(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := queryInt(r, "n", 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes, ok := queryFileChanges(w, r, repo)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HottestFiles(changes, n))
+}
+
+func apiChurnAuthors(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	since, ok := queryChurnSince(w, r)
+	if !ok {
+		return
+	}
+
+	authors, err := store.GetAuthorChurn(repo.ID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authors)
+}
+
+func apiChurnCoupling(w http.ResponseWriter, r *http.Request) {
+	repo, ok := pathRepo(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := queryInt(r, "n", 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	min, err := queryInt(r, "min", 3)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes, ok := queryFileChanges(w, r, repo)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, FileCouplings(changes, min, n))
+}
+
+func apiListContributors(w http.ResponseWriter, r *http.Request) {
+	contributors, err := store.GetContributors()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, contributors)
+}
+
+func apiMergeContributors(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		From int `json:"from"`
+		Into int `json:"into"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.From == 0 || body.Into == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf(`invalid request body, expected {"from": id, "into": id}`))
+		return
+	}
+
+	err = store.MergeContributors(body.From, body.Into)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiApplyMailmap applies the .mailmap file given as the request body
+func apiApplyMailmap(w http.ResponseWriter, r *http.Request) {
+	entries, err := ParseMailmap(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	n, err := store.ApplyMailmap(entries)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"applied": n})
+}
+
+// cmdServe runs the rest api server until SIGINT or SIGTERM
+func cmdServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", envOr("API_ADDR", ":8080"), "address to serve the api on")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	github.OnWait = nil
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	server := &http.Server{Addr: *addr, Handler: mux}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	LogApp(fmt.Sprintf("serving api on %s", *addr))
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// envOr returns the environment variable, or def if it's empty
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialProvider supplies the token used to authenticate requests to the github api
+type CredentialProvider interface {
+	Token() (string, error)
+}
+
+// credentials holds the credential provider of each github host, keyed by host name
+var credentials = map[string]CredentialProvider{}
+var credentialsMu sync.RWMutex
+
+// SetCredentials replaces the credential provider consulted by NewRequest for the host
+func SetCredentials(host string, p CredentialProvider) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	if p == nil {
+		delete(credentials, host)
+		return
+	}
+	credentials[host] = p
+}
+
+// CredentialsFor returns the credential provider of the host, nil when it's accessed anonymously
+func CredentialsFor(host string) CredentialProvider {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	return credentials[host]
+}
+
+// LoadAllCredentials loads the credentials of every configured host
+func LoadAllCredentials() {
+	for _, h := range Hosts() {
+		p, err := LoadCredentials(h)
+		if err != nil {
+			LogError(fmt.Errorf("error loading credentials for %s : %v", h.Name, err))
+		}
+		SetCredentials(h.Name, p)
+	}
+}
+
+// LoadCredentials picks a credential provider for the host from the environment, in order of precedence:
+// a personal access token, a github app installation, then a token stored by the device flow login.
+// Enterprise hosts read the same variables suffixed with the host, e.g. GITHUB_TOKEN_GHE_CORP.
+// It returns nil if none is configured, in which case requests are made anonymously.
+func LoadCredentials(h Host) (CredentialProvider, error) {
+	if token := h.Getenv("GITHUB_TOKEN"); token != "" {
+		return StaticToken(token), nil
+	}
+
+	if h.Getenv("GITHUB_APP_ID") != "" {
+		return NewAppCredentials(h,
+			h.Getenv("GITHUB_APP_ID"),
+			h.Getenv("GITHUB_APP_INSTALLATION_ID"),
+			h.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"),
+		)
+	}
+
+	token, err := LoadStoredToken(h)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		return StaticToken(token), nil
+	}
+
+	return nil, nil
+}
+
+// StaticToken is a personal access token or an oauth token obtained through the device flow
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// AppCredentials authenticates as a github app installation.
+// Installation tokens are exchanged using a JWT signed with the app key and refreshed before they expire.
+type AppCredentials struct {
+	Host           Host
+	AppID          string
+	InstallationID string
+	Key            *rsa.PrivateKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewAppCredentials loads the app private key and returns the installation credentials
+func NewAppCredentials(h Host, appID, installationID, keyPath string) (*AppCredentials, error) {
+	if installationID == "" || keyPath == "" {
+		return nil, fmt.Errorf("GITHUB_APP_INSTALLATION_ID and GITHUB_APP_PRIVATE_KEY_PATH are required with GITHUB_APP_ID")
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading app private key : %v", err)
+	}
+
+	key, err := parseRSAPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppCredentials{
+		Host:           h,
+		AppID:          appID,
+		InstallationID: installationID,
+		Key:            key,
+	}, nil
+}
+
+// Token returns a cached installation token, exchanging a new one if it expires within a minute
+func (a *AppCredentials) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Until(a.expires) > time.Minute {
+		return a.token, nil
+	}
+
+	jwt, err := a.signJWT(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	URL := fmt.Sprintf("%s/app/installations/%s/access_tokens", a.Host.APIURL, a.InstallationID)
+	req, err := http.NewRequest("POST", URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting installation token : %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading installation token response : %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("error requesting installation token : %v, %s", resp.Status, body)
+	}
+
+	response := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return "", fmt.Errorf("error parsing installation token : %v", err)
+	}
+
+	a.token = response.Token
+	a.expires = response.ExpiresAt
+	LogApp(fmt.Sprintf("refreshed github app installation token for %s, expires at %v", a.Host.Name, a.expires))
+
+	return a.token, nil
+}
+
+// signJWT creates the RS256 signed JWT used to authenticate as the app itself
+func (a *AppCredentials) signJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// backdate the issue time to allow for clock drift, github rejects tokens valid for over 10 minutes
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.AppID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing app jwt : %v", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid app private key : no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app private key : %v", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid app private key : not an RSA key")
+	}
+
+	return key, nil
+}
+
+// DeviceCode is the response to a device flow authorization request
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// RequestDeviceCode starts the oauth device flow on the host for the given oauth app client id
+func RequestDeviceCode(h Host, clientID string) (*DeviceCode, error) {
+	form := url.Values{"client_id": {clientID}, "scope": {"repo"}}
+
+	body, err := postForm(h.WebURL()+"/login/device/code", form)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting device code : %v", err)
+	}
+
+	code := new(DeviceCode)
+	err = json.Unmarshal(body, code)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing device code : %v", err)
+	}
+	if code.DeviceCode == "" {
+		return nil, fmt.Errorf("error requesting device code : %s", body)
+	}
+
+	return code, nil
+}
+
+// PollDeviceToken polls github until the user authorizes the device code, then returns the access token
+func PollDeviceToken(h Host, clientID string, code *DeviceCode) (string, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	form := url.Values{
+		"client_id":   {clientID},
+		"device_code": {code.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		body, err := postForm(h.WebURL()+"/login/oauth/access_token", form)
+		if err != nil {
+			return "", fmt.Errorf("error polling for access token : %v", err)
+		}
+
+		response := struct {
+			AccessToken string `json:"access_token"`
+			Error       string `json:"error"`
+			Interval    int    `json:"interval"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return "", fmt.Errorf("error parsing access token response : %v", err)
+		}
+
+		switch response.Error {
+		case "":
+			return response.AccessToken, nil
+		case "authorization_pending":
+			// user hasn't entered the code yet
+		case "slow_down":
+			// the interval grows by 5 seconds, github may tell by how much more
+			interval = max(time.Duration(response.Interval)*time.Second, interval+5*time.Second)
+		default:
+			return "", fmt.Errorf("device login failed : %s", response.Error)
+		}
+	}
+
+	return "", fmt.Errorf("device login failed : code expired")
+}
+
+func postForm(URL string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequest("POST", URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// tokenFilePath returns where the device flow token of the host is stored, TOKEN_FILE overrides the default location.
+// Tokens for enterprise hosts are stored next to it, suffixed with the host name.
+func tokenFilePath(h Host) (string, error) {
+	path := os.Getenv("TOKEN_FILE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, "github-api", "token")
+	}
+
+	if h.Name != DefaultHost.Name {
+		path += "-" + h.Name
+	}
+
+	return path, nil
+}
+
+// LoadStoredToken reads the token saved by a previous device flow login on the host, if any
+func LoadStoredToken(h Host) (string, error) {
+	path, err := tokenFilePath(h)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading stored token : %v", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// StoreToken saves the token of the host so it's picked up on the next start
+func StoreToken(h Host, token string) error {
+	path, err := tokenFilePath(h)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token+"\n"), 0600)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Branch is a branch of a repository. The default branch is always tracked,
+// other branches have their commits synced once they're tracked.
+type Branch struct {
+	RepositoryID int       `json:"repository_id" db:"repository_id"`
+	Name         string    `json:"name" db:"name"`
+	HeadSHA      string    `json:"head_sha" db:"head_sha"`
+	Default      bool      `json:"default" db:"is_default"`
+	Tracked      bool      `json:"tracked" db:"tracked"`
+	Updated      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// FetchBranches fetches the branches of the repository from github, stores them and returns the stored branches.
+// Branches deleted on github are forgotten along with the record of which commits they reached.
+func FetchBranches(repo *Repository) ([]Branch, error) {
+	if repo.DefaultBranch == "" {
+		// stored before default branches were recorded, the metadata is needed to tell which one it is
+		fetched, err := FetchRepo(repo.URL)
+		if err != nil {
+			return nil, err
+		}
+		repo.DefaultBranch = fetched.DefaultBranch
+	}
+
+	branches := []Branch{}
+	URL := repo.URL + "/branches?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with branches request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			Name   string `json:"name"`
+			Commit struct {
+				SHA string `json:"sha"`
+			} `json:"commit"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing branches : %v", err))
+			return nil, err
+		}
+
+		for _, b := range response {
+			branches = append(branches, Branch{
+				RepositoryID: repo.ID,
+				Name:         b.Name,
+				HeadSHA:      b.Commit.SHA,
+				Default:      b.Name == repo.DefaultBranch,
+				Updated:      time.Now(),
+			})
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	err := store.SaveBranches(repo.ID, branches)
+	if err != nil {
+		LogError(fmt.Errorf("error saving branches : %v", err))
+		return nil, err
+	}
+
+	return store.GetBranches(repo.ID)
+}
+
+// SaveBranches replaces the stored branches of the repository with the given ones, keeping which are tracked.
+// The first time branches are saved, every stored commit is recorded as reachable from the default branch
+// since that's the only one synced until then.
+func (s *sqlStore) SaveBranches(repo_id int, branches []Branch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var known int
+	err = tx.QueryRow("SELECT count(*) FROM branches WHERE repository_id=$1", repo_id).Scan(&known)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, b := range branches {
+		names[b.Name] = true
+		_, err = tx.Exec(`INSERT INTO branches (repository_id, name, head_sha, is_default, tracked, updated_at)
+			VALUES ($1,$2,$3,$4,$4,$5)
+			ON CONFLICT (repository_id, name) DO UPDATE SET
+				head_sha=$3,
+				is_default=$4,
+				tracked=(branches.tracked OR $4),
+				updated_at=$5`,
+			repo_id, b.Name, b.HeadSHA, b.Default, b.Updated.UTC())
+		if err != nil {
+			return fmt.Errorf("error saving branch %s : %v", b.Name, err)
+		}
+
+		if b.Default && known == 0 {
+			_, err = tx.Exec(`INSERT INTO commit_branches (repository_id, branch, sha)
+				SELECT repository_id, $2, sha FROM commits WHERE repository_id=$1
+				ON CONFLICT DO NOTHING`, repo_id, b.Name)
+			if err != nil {
+				return fmt.Errorf("error recording the commits of the default branch : %v", err)
+			}
+		}
+	}
+
+	// forget the branches that no longer exist
+	stored, err := tx.Query("SELECT name FROM branches WHERE repository_id=$1", repo_id)
+	if err != nil {
+		return err
+	}
+	gone := []string{}
+	for stored.Next() {
+		var name string
+		err = stored.Scan(&name)
+		if err != nil {
+			stored.Close()
+			return err
+		}
+		if !names[name] {
+			gone = append(gone, name)
+		}
+	}
+	stored.Close()
+
+	for _, name := range gone {
+		for _, stmt := range []string{
+			"DELETE FROM commit_branches WHERE repository_id=$1 AND branch=$2",
+			"DELETE FROM sync_cursors WHERE repository_id=$1 AND branch=$2",
+			"DELETE FROM branches WHERE repository_id=$1 AND name=$2",
+		} {
+			_, err = tx.Exec(stmt, repo_id, name)
+			if err != nil {
+				return fmt.Errorf("error deleting branch %s : %v", name, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetBranches returns the stored branches of the repository, the default one first
+func (s *sqlStore) GetBranches(repo_id int) ([]Branch, error) {
+	rows, err := s.db.Query(`SELECT repository_id, name, head_sha, is_default, tracked, updated_at
+		FROM branches WHERE repository_id=$1 ORDER BY is_default DESC, name`, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting branches from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := []Branch{}
+	for rows.Next() {
+		b := Branch{}
+		err = rows.Scan(&b.RepositoryID, &b.Name, &b.HeadSHA, &b.Default, &b.Tracked, &b.Updated)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning branch result : %v", err))
+			return nil, err
+		}
+
+		branches = append(branches, b)
+	}
+
+	return branches, rows.Err()
+}
+
+// TrackBranch starts or stops following a branch. It returns sql.ErrNoRows if the branch isn't known,
+// and an error for the default branch which is always tracked.
+func (s *sqlStore) TrackBranch(repo_id int, name string, tracked bool) error {
+	var isDefault bool
+	err := s.db.QueryRow("SELECT is_default FROM branches WHERE repository_id=$1 AND name=$2", repo_id, name).Scan(&isDefault)
+	if err != nil {
+		return err
+	}
+	if isDefault && !tracked {
+		return fmt.Errorf("%s is the default branch, it's always tracked", name)
+	}
+
+	_, err = s.db.Exec("UPDATE branches SET tracked=$3 WHERE repository_id=$1 AND name=$2", repo_id, name, tracked)
+	if err != nil {
+		LogError(fmt.Errorf("error updating branch : %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// findBranch returns the named branch of the repository, fetching the branches from github if it isn't stored yet
+func findBranch(repo *Repository, name string) (*Branch, error) {
+	branches, err := store.GetBranches(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if b := branchNamed(branches, name); b != nil {
+		return b, nil
+	}
+
+	branches, err = FetchBranches(repo)
+	if err != nil {
+		return nil, err
+	}
+	if b := branchNamed(branches, name); b != nil {
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("branch %q not found in %s : %w", name, repo.Name, sql.ErrNoRows)
+}
+
+func branchNamed(branches []Branch, name string) *Branch {
+	for i := range branches {
+		if branches[i].Name == name {
+			return &branches[i]
+		}
+	}
+	return nil
+}
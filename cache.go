@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CacheEntry holds the validators github returned for a url, used to make conditional requests
+type CacheEntry struct {
+	URL          string    `json:"url" db:"url"`
+	ETag         string    `json:"etag" db:"etag"`
+	LastModified string    `json:"last_modified" db:"last_modified"`
+	Updated      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// SaveCacheEntry saves the cache entry to the http_cache table
+func (s *sqlStore) SaveCacheEntry(e *CacheEntry) error {
+	insert := `insert into http_cache (
+		url,
+		etag,
+		last_modified,
+		updated_at
+	) values ($1,$2,$3,$4)
+	ON CONFLICT (url) DO UPDATE SET
+		etag=$2,
+		last_modified=$3,
+		updated_at=$4
+	`
+
+	_, err := s.db.Exec(insert, e.URL, e.ETag, e.LastModified, e.Updated.UTC())
+
+	return err
+}
+
+// GetCacheEntry returns the cached validators for the url, nil if it was never fetched
+func (s *sqlStore) GetCacheEntry(URL string) (*CacheEntry, error) {
+	e := new(CacheEntry)
+	row := s.db.QueryRow("SELECT url, etag, last_modified, updated_at FROM http_cache WHERE url=$1", URL)
+	err := row.Scan(&e.URL, &e.ETag, &e.LastModified, &e.Updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		LogError(fmt.Errorf("error scanning cache entry : %v", err))
+		return nil, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommitFile is the change a commit made to a file. Status is added, removed, modified, renamed,
+// copied, changed or unchanged, PreviousFilename is set for renamed files.
+type CommitFile struct {
+	SHA              string `json:"sha" db:"sha"`
+	Filename         string `json:"filename" db:"filename"`
+	Status           string `json:"status" db:"status"`
+	Additions        int    `json:"additions" db:"additions"`
+	Deletions        int    `json:"deletions" db:"deletions"`
+	PreviousFilename string `json:"previous_filename" db:"previous_filename"`
+}
+
+// FileChange is a file changed by a commit along with when and by whom, the rows the churn reports are made of
+type FileChange struct {
+	CommitFile
+	Date          time.Time `json:"date"`
+	ContributorID int       `json:"contributor_id"`
+}
+
+// commitFilesBatch reads how many commits get their changed files fetched per repository and refresh
+// from COMMIT_FILES_BATCH, 100 by default. Each one costs at least a request.
+func commitFilesBatch() int {
+	batch := os.Getenv("COMMIT_FILES_BATCH")
+	i := 100
+	if batch != "" {
+		val, err := strconv.Atoi(batch)
+		if err != nil || val < 0 {
+			LogError(fmt.Errorf("error parsing COMMIT_FILES_BATCH env variable : %v", batch))
+			return i
+		}
+		i = val
+	}
+	return i
+}
+
+// commitFilesReserve is the part of the rate limit budget left to the other syncs, fetching
+// the changed files stops once the budget gets that low and resumes on the next refresh
+const commitFilesReserve = 500
+
+// SyncCommitFiles fetches the changed files of up to limit commits of the repository that don't have them yet,
+// newest first, all of them if limit is 0. Merge commits are skipped, their changes are already counted in the
+// commits they merge. It returns how many commits were fetched.
+func SyncCommitFiles(repo *Repository, limit int) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncCommitFiles(repo, limit)
+}
+
+// syncCommitFiles is SyncCommitFiles for callers already holding the repository lock
+func syncCommitFiles(repo *Repository, limit int) (int, error) {
+	pending, err := store.GetCommitsWithoutFiles(repo.ID, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	fetched := 0
+	for _, c := range pending {
+		if remaining, _ := github.RateLimit(); remaining >= 0 && remaining < commitFilesReserve {
+			LogApp(fmt.Sprintf("%d requests left, leaving the changed files of %d commits of %s for later",
+				remaining, len(pending)-fetched, repo.Name))
+			break
+		}
+
+		files := []CommitFile{}
+		if !c.Merge() {
+			files, err = fetchCommitFiles(repo, c.SHA)
+			if err != nil {
+				return fetched, err
+			}
+		}
+
+		err = store.SaveCommitFiles(repo.ID, c.SHA, files)
+		if err != nil {
+			LogError(fmt.Errorf("error saving the files of commit %s : %v", c.SHA, err))
+			return fetched, err
+		}
+		fetched++
+	}
+
+	return fetched, nil
+}
+
+// fetchCommitFiles fetches the files changed by a commit, large commits list them over several pages
+func fetchCommitFiles(repo *Repository, sha string) ([]CommitFile, error) {
+	files := []CommitFile{}
+	URL := repo.URL + "/commits/" + sha + "?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with commit request : %v", err))
+			return nil, err
+		}
+
+		response := struct {
+			Files []struct {
+				Filename         string `json:"filename"`
+				Status           string `json:"status"`
+				Additions        int    `json:"additions"`
+				Deletions        int    `json:"deletions"`
+				PreviousFilename string `json:"previous_filename"`
+			} `json:"files"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing commit : %v", err))
+			return nil, err
+		}
+
+		for _, f := range response.Files {
+			files = append(files, CommitFile{
+				SHA:              sha,
+				Filename:         f.Filename,
+				Status:           f.Status,
+				Additions:        f.Additions,
+				Deletions:        f.Deletions,
+				PreviousFilename: f.PreviousFilename,
+			})
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return files, nil
+}
+
+// GetCommitsWithoutFiles returns up to limit commits of the repository whose changed files haven't been fetched, newest first
+func (s *sqlStore) GetCommitsWithoutFiles(repo_id, limit int) ([]Commit, error) {
+	q := "SELECT " + commitColumns + " FROM commits WHERE repository_id=$1 AND NOT files_fetched ORDER BY date DESC, sha DESC"
+	if limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := s.db.Query(q, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting commits without files from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	commits := []Commit{}
+	for rows.Next() {
+		c, err := scanCommit(rows)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning commit result : %v", err))
+			return nil, err
+		}
+
+		commits = append(commits, *c)
+	}
+
+	return commits, rows.Err()
+}
+
+// SaveCommitFiles replaces the changed files of a commit and marks them fetched, in one transaction
+func (s *sqlStore) SaveCommitFiles(repo_id int, sha string, files []CommitFile) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM commit_files WHERE repository_id=$1 AND sha=$2", repo_id, sha)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		_, err = tx.Exec(`INSERT INTO commit_files (repository_id, sha, filename, status, additions, deletions, previous_filename)
+			VALUES ($1,$2,$3,$4,$5,$6,$7) ON CONFLICT DO NOTHING`,
+			repo_id, sha, f.Filename, f.Status, f.Additions, f.Deletions, f.PreviousFilename)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE commits SET files_fetched=$3 WHERE repository_id=$1 AND sha=$2", repo_id, sha, true)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFileChanges returns the files changed by the commits of the repository made since the given time, all of them if it's nil
+func (s *sqlStore) GetFileChanges(repo_id int, since *time.Time) ([]FileChange, error) {
+	q := newQuery(`SELECT f.sha, f.filename, f.status, f.additions, f.deletions, f.previous_filename,
+		c.date, COALESCE(c.contributor_id, 0)
+		FROM commit_files f JOIN commits c ON c.repository_id = f.repository_id AND c.sha = f.sha
+		WHERE f.repository_id=$1`, repo_id)
+	if since != nil {
+		q.where("c.date >= $%d", since.UTC())
+	}
+	q.sql += " ORDER BY c.date, f.sha, f.filename"
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error getting file changes from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []FileChange{}
+	for rows.Next() {
+		c := FileChange{}
+		err = rows.Scan(&c.SHA, &c.Filename, &c.Status, &c.Additions, &c.Deletions, &c.PreviousFilename,
+			&c.Date, &c.ContributorID)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning file change : %v", err))
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// AuthorChurn is how many lines a contributor added and removed
+type AuthorChurn struct {
+	ContributorID int    `json:"contributor_id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Login         string `json:"login"`
+	Commits       int    `json:"commits"`
+	Additions     int    `json:"additions"`
+	Deletions     int    `json:"deletions"`
+}
+
+// GetAuthorChurn returns the lines added and removed by each contributor of the repository since the given time,
+// all time if it's nil, most lines changed first
+func (s *sqlStore) GetAuthorChurn(repo_id int, since *time.Time) ([]AuthorChurn, error) {
+	q := newQuery(`SELECT p.id, p.name, p.email, p.login, count(DISTINCT c.sha),
+		COALESCE(sum(f.additions), 0) AS additions, COALESCE(sum(f.deletions), 0) AS deletions
+		FROM commit_files f JOIN commits c ON c.repository_id = f.repository_id AND c.sha = f.sha
+		JOIN contributors p ON p.id = c.contributor_id
+		WHERE f.repository_id=$1`, repo_id)
+	if since != nil {
+		q.where("c.date >= $%d", since.UTC())
+	}
+	q.sql += " GROUP BY p.id, p.name, p.email, p.login ORDER BY additions + deletions DESC, p.id"
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error getting author churn from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []AuthorChurn{}
+	for rows.Next() {
+		a := AuthorChurn{}
+		err = rows.Scan(&a.ContributorID, &a.Name, &a.Email, &a.Login, &a.Commits, &a.Additions, &a.Deletions)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning author churn : %v", err))
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+
+	return authors, rows.Err()
+}
+
+// churnSince returns the start of the week the given number of weeks back, counting the current one,
+// or nil for all time when weeks is 0
+func churnSince(weeks int, now time.Time) *time.Time {
+	if weeks <= 0 {
+		return nil
+	}
+	since := weekOf(now.UTC()).AddDate(0, 0, -7*(weeks-1))
+	return &since
+}
+
+// DirectoryChurn is how many lines were changed under a directory during a week
+type DirectoryChurn struct {
+	Directory string    `json:"directory"`
+	Week      time.Time `json:"week"`
+	Commits   int       `json:"commits"`
+	Additions int       `json:"additions"`
+	Deletions int       `json:"deletions"`
+}
+
+// directoryOf returns the first depth directories of a file path, "." for files at the root
+func directoryOf(filename string, depth int) string {
+	dir := path.Dir(filename)
+	if dir == "." || depth <= 0 {
+		return "."
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// weekOf returns the monday starting the week of t, in the location of t
+func weekOf(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// ChurnByDirectory sums the lines changed under each directory, cut at depth, week by week.
+// The result is ordered by week, then by most lines changed.
+func ChurnByDirectory(changes []FileChange, depth int) []DirectoryChurn {
+	type key struct {
+		dir  string
+		week time.Time
+	}
+	totals := map[key]*DirectoryChurn{}
+	commits := map[key]map[string]bool{}
+	for _, c := range changes {
+		k := key{directoryOf(c.Filename, depth), weekOf(c.Date.UTC())}
+		d, ok := totals[k]
+		if !ok {
+			d = &DirectoryChurn{Directory: k.dir, Week: k.week}
+			totals[k] = d
+			commits[k] = map[string]bool{}
+		}
+		d.Additions += c.Additions
+		d.Deletions += c.Deletions
+		commits[k][c.SHA] = true
+	}
+
+	result := []DirectoryChurn{}
+	for k, d := range totals {
+		d.Commits = len(commits[k])
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.Week.Equal(b.Week) {
+			return a.Week.Before(b.Week)
+		}
+		if a.Additions+a.Deletions != b.Additions+b.Deletions {
+			return a.Additions+a.Deletions > b.Additions+b.Deletions
+		}
+		return a.Directory < b.Directory
+	})
+	return result
+}
+
+// FileChurn is how often a file changed and by how many lines
+type FileChurn struct {
+	Filename  string `json:"filename"`
+	Commits   int    `json:"commits"`
+	Authors   int    `json:"authors"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+// HottestFiles returns the n files changed by the most commits, all of them if n is 0
+func HottestFiles(changes []FileChange, n int) []FileChurn {
+	files := map[string]*FileChurn{}
+	authors := map[string]map[int]bool{}
+	for _, c := range changes {
+		f, ok := files[c.Filename]
+		if !ok {
+			f = &FileChurn{Filename: c.Filename}
+			files[c.Filename] = f
+			authors[c.Filename] = map[int]bool{}
+		}
+		f.Commits++
+		f.Additions += c.Additions
+		f.Deletions += c.Deletions
+		authors[c.Filename][c.ContributorID] = true
+	}
+
+	result := []FileChurn{}
+	for name, f := range files {
+		f.Authors = len(authors[name])
+		result = append(result, *f)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		if a.Additions+a.Deletions != b.Additions+b.Deletions {
+			return a.Additions+a.Deletions > b.Additions+b.Deletions
+		}
+		return a.Filename < b.Filename
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// FileCoupling is a pair of files changed by the same commits. Degree is the share of the commits
+// changing the least changed of the two that also changed the other one.
+type FileCoupling struct {
+	FileA    string  `json:"file_a"`
+	FileB    string  `json:"file_b"`
+	Together int     `json:"together"`
+	Degree   float64 `json:"degree"`
+}
+
+// couplingMaxFiles leaves the commits changing more files out of the coupling report,
+// sweeping changes like reformats or renames would couple everything
+const couplingMaxFiles = 50
+
+// FileCouplings returns the n pairs of files changed together by the most commits, at least min of them
+func FileCouplings(changes []FileChange, min, n int) []FileCoupling {
+	byCommit := map[string][]string{}
+	for _, c := range changes {
+		byCommit[c.SHA] = append(byCommit[c.SHA], c.Filename)
+	}
+
+	type pair struct{ a, b string }
+	together := map[pair]int{}
+	commits := map[string]int{}
+	for _, files := range byCommit {
+		if len(files) > couplingMaxFiles {
+			continue
+		}
+		sort.Strings(files)
+		for i, a := range files {
+			commits[a]++
+			for _, b := range files[i+1:] {
+				together[pair{a, b}]++
+			}
+		}
+	}
+
+	result := []FileCoupling{}
+	for p, count := range together {
+		if count < min {
+			continue
+		}
+		least := commits[p.a]
+		if commits[p.b] < least {
+			least = commits[p.b]
+		}
+		result = append(result, FileCoupling{FileA: p.a, FileB: p.b, Together: count, Degree: float64(count) / float64(least)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Together != b.Together {
+			return a.Together > b.Together
+		}
+		if a.Degree != b.Degree {
+			return a.Degree > b.Degree
+		}
+		return a.FileA+a.FileB < b.FileA+b.FileB
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFileCouplings(t *testing.T) {
+	changes := []FileChange{}
+	commit := func(sha string, files ...string) {
+		for _, f := range files {
+			changes = append(changes, FileChange{CommitFile: CommitFile{SHA: sha, Filename: f}})
+		}
+	}
+	commit("1", "a.go", "a_test.go")
+	commit("2", "a.go", "a_test.go", "b.go")
+	commit("3", "a_test.go", "a.go")
+	commit("4", "b.go", "c.go")
+	commit("5", "a.go")
+	// a sweeping change couples nothing
+	sweep := []string{"a.go", "b.go", "c.go"}
+	for i := 0; i < couplingMaxFiles; i++ {
+		sweep = append(sweep, fmt.Sprintf("gen/%d.go", i))
+	}
+	commit("6", sweep...)
+
+	tests := []struct {
+		min, n int
+		want   []FileCoupling
+	}{
+		{1, 0, []FileCoupling{
+			{FileA: "a.go", FileB: "a_test.go", Together: 3, Degree: 1},
+			{FileA: "b.go", FileB: "c.go", Together: 1, Degree: 1},
+			{FileA: "a.go", FileB: "b.go", Together: 1, Degree: 0.5},
+			{FileA: "a_test.go", FileB: "b.go", Together: 1, Degree: 0.5},
+		}},
+		{2, 0, []FileCoupling{{FileA: "a.go", FileB: "a_test.go", Together: 3, Degree: 1}}},
+		{1, 2, []FileCoupling{
+			{FileA: "a.go", FileB: "a_test.go", Together: 3, Degree: 1},
+			{FileA: "b.go", FileB: "c.go", Together: 1, Degree: 1},
+		}},
+		{4, 0, []FileCoupling{}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("min %d n %d", tt.min, tt.n), func(t *testing.T) {
+			got := FileCouplings(changes, tt.min, tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("couplings = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectoryOf(t *testing.T) {
+	tests := []struct {
+		filename string
+		depth    int
+		want     string
+	}{
+		{"main.go", 1, "."},
+		{"src/main.go", 1, "src"},
+		{"src/api/handler.go", 1, "src"},
+		{"src/api/handler.go", 2, "src/api"},
+		{"src/api/handler.go", 5, "src/api"},
+		{"src/api/handler.go", 0, "."},
+	}
+	for _, tt := range tests {
+		if got := directoryOf(tt.filename, tt.depth); got != tt.want {
+			t.Errorf("directoryOf(%q, %d) = %q, want %q", tt.filename, tt.depth, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// RunDurations sums up how long the attempts of a workflow ran, in minutes
+type RunDurations struct {
+	Count      int     `json:"count"`
+	P50Minutes float64 `json:"p50_minutes"`
+	P95Minutes float64 `json:"p95_minutes"`
+}
+
+// WorkflowHealth is how the runs of a workflow went. A run counts once, by its last attempt, and its success
+// rate leaves out the cancelled and skipped ones. A run is flaky when an attempt failed and a later attempt,
+// or a later run of the same commit, succeeded.
+type WorkflowHealth struct {
+	Workflow    string       `json:"workflow"`
+	Runs        int          `json:"runs"`
+	Succeeded   int          `json:"succeeded"`
+	Failed      int          `json:"failed"`
+	Cancelled   int          `json:"cancelled"`
+	ReRun       int          `json:"re_run"`
+	Flaky       int          `json:"flaky"`
+	SuccessRate *float64     `json:"success_rate"`
+	Durations   RunDurations `json:"durations"`
+}
+
+// FlakyRun is a failure that passed when run again, either as another attempt of the same run or as another run
+// of the same commit
+type FlakyRun struct {
+	Workflow  string    `json:"workflow"`
+	RunID     int64     `json:"run_id"`
+	Attempt   int       `json:"attempt"`
+	Branch    string    `json:"branch"`
+	HeadSHA   string    `json:"head_sha"`
+	Failed    time.Time `json:"failed_at"`
+	PassedRun int64     `json:"passed_run_id"`
+	ReRun     bool      `json:"re_run"`
+}
+
+// Breakage is a commit that turned a workflow red on the default branch after it passed, until FixedSHA
+// turned it green again. Failures that passed again on the same commit are flaky runs rather than breakages.
+type Breakage struct {
+	Workflow string     `json:"workflow"`
+	HeadSHA  string     `json:"head_sha"`
+	Author   string     `json:"author"`
+	Message  string     `json:"message"`
+	RunID    int64      `json:"run_id"`
+	Broken   time.Time  `json:"broken_at"`
+	FixedSHA string     `json:"fixed_sha"`
+	Fixed    *time.Time `json:"fixed_at"`
+}
+
+// CIHealth is how the workflow runs of a repository created since Since went, all of them if it's nil
+type CIHealth struct {
+	RepositoryID int              `json:"repository_id"`
+	Since        *time.Time       `json:"since"`
+	Workflows    []WorkflowHealth `json:"workflows"`
+	Flaky        []FlakyRun       `json:"flaky"`
+	Breakages    []Breakage       `json:"breakages"`
+}
+
+// WeekDurations is how long the successful attempts of a workflow ran during the week starting on monday Week
+type WeekDurations struct {
+	Week time.Time `json:"week"`
+	RunDurations
+}
+
+// WorkflowDurations is how long the successful attempts of a workflow ran week by week, oldest first
+type WorkflowDurations struct {
+	Workflow string          `json:"workflow"`
+	Weeks    []WeekDurations `json:"weeks"`
+}
+
+// runDurations sums up the given durations
+func runDurations(durations []time.Duration) RunDurations {
+	d := RunDurations{Count: len(durations)}
+	if len(durations) == 0 {
+		return d
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	d.P50Minutes = percentile(durations, 0.5).Minutes()
+	d.P95Minutes = percentile(durations, 0.95).Minutes()
+	return d
+}
+
+// GetCIHealth reports how the workflow runs of the repository created over the last days went, all of them if
+// days is 0. Breakages are looked for on the default branch.
+func GetCIHealth(repo *Repository, days int) (*CIHealth, error) {
+	f := WorkflowRunFilter{}
+	if days > 0 {
+		since := time.Now().AddDate(0, 0, -days)
+		f.Since = &since
+	}
+	runs, err := store.GetWorkflowRuns(repo.ID, f)
+	if err != nil {
+		return nil, err
+	}
+
+	h := CIHealthReport(runs, repo.DefaultBranch)
+	h.RepositoryID = repo.ID
+	h.Since = f.Since
+	return h, nil
+}
+
+// CIHealthReport computes the health of each workflow from every attempt of its runs, finds the flaky runs and
+// the commits that broke the default branch
+func CIHealthReport(runs []WorkflowRun, defaultBranch string) *CIHealth {
+	h := &CIHealth{Workflows: []WorkflowHealth{}, Flaky: []FlakyRun{}, Breakages: []Breakage{}}
+
+	// the attempts of each run, first one first, and the runs oldest first
+	sorted := append([]WorkflowRun{}, runs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.Attempt < b.Attempt
+	})
+	attempts := map[int64][]WorkflowRun{}
+	order := []int64{}
+	for _, r := range sorted {
+		if attempts[r.ID] == nil {
+			order = append(order, r.ID)
+		}
+		attempts[r.ID] = append(attempts[r.ID], r)
+	}
+
+	health := map[string]*WorkflowHealth{}
+	durations := map[string][]time.Duration{}
+	// failures of each workflow and commit waiting for a later run to pass
+	pending := map[[2]string][]FlakyRun{}
+	for _, id := range order {
+		runAttempts := attempts[id]
+		last := runAttempts[len(runAttempts)-1]
+		w := health[last.Workflow]
+		if w == nil {
+			w = &WorkflowHealth{Workflow: last.Workflow}
+			health[last.Workflow] = w
+		}
+
+		w.Runs++
+		if last.Attempt > 1 {
+			w.ReRun++
+		}
+		switch {
+		case last.Conclusion == RunSuccess:
+			w.Succeeded++
+		case last.failed():
+			w.Failed++
+		case last.Conclusion == RunCancelled:
+			w.Cancelled++
+		}
+
+		flaky := false
+		key := [2]string{last.Workflow, last.HeadSHA}
+		for _, a := range runAttempts {
+			if a.Conclusion == RunSuccess && a.Started != nil && a.Completed != nil {
+				durations[a.Workflow] = append(durations[a.Workflow], a.Duration())
+			}
+			if a.failed() && last.Conclusion == RunSuccess && !flaky {
+				flaky = true
+				h.Flaky = append(h.Flaky, flakyRun(a, last.ID, true))
+			}
+		}
+		if last.Conclusion == RunSuccess {
+			for _, f := range pending[key] {
+				f.PassedRun = last.ID
+				h.Flaky = append(h.Flaky, f)
+				health[f.Workflow].Flaky++
+			}
+			delete(pending, key)
+		} else if last.failed() {
+			pending[key] = append(pending[key], flakyRun(last, 0, false))
+		}
+		if flaky {
+			w.Flaky++
+		}
+	}
+
+	for _, w := range health {
+		if rated := w.Succeeded + w.Failed; rated > 0 {
+			rate := float64(w.Succeeded) / float64(rated)
+			w.SuccessRate = &rate
+		}
+		w.Durations = runDurations(durations[w.Workflow])
+		h.Workflows = append(h.Workflows, *w)
+	}
+	sort.Slice(h.Workflows, func(i, j int) bool {
+		a, b := h.Workflows[i], h.Workflows[j]
+		if a.Runs != b.Runs {
+			return a.Runs > b.Runs
+		}
+		return a.Workflow < b.Workflow
+	})
+	sort.SliceStable(h.Flaky, func(i, j int) bool { return h.Flaky[i].Failed.After(h.Flaky[j].Failed) })
+
+	// walk the runs of each workflow on the default branch, a failure after a success breaks it
+	broken := map[string]*Breakage{}
+	passing := map[string]bool{}
+	for _, id := range order {
+		runAttempts := attempts[id]
+		last := runAttempts[len(runAttempts)-1]
+		if last.Branch != defaultBranch || last.Completed == nil {
+			continue
+		}
+
+		switch {
+		case last.failed():
+			if passing[last.Workflow] {
+				broken[last.Workflow] = &Breakage{
+					Workflow: last.Workflow,
+					HeadSHA:  last.HeadSHA,
+					Author:   last.CommitAuthor,
+					Message:  last.CommitMessage,
+					RunID:    last.ID,
+					Broken:   *last.Completed,
+				}
+			}
+			passing[last.Workflow] = false
+		case last.Conclusion == RunSuccess:
+			b := broken[last.Workflow]
+			if b != nil && b.HeadSHA != last.HeadSHA {
+				b.FixedSHA = last.HeadSHA
+				b.Fixed = last.Completed
+				h.Breakages = append(h.Breakages, *b)
+			}
+			delete(broken, last.Workflow)
+			passing[last.Workflow] = true
+		}
+	}
+	// the ones still broken
+	for _, b := range broken {
+		h.Breakages = append(h.Breakages, *b)
+	}
+	sort.SliceStable(h.Breakages, func(i, j int) bool { return h.Breakages[i].Broken.After(h.Breakages[j].Broken) })
+
+	return h
+}
+
+// flakyRun records a failed attempt, passed tells which run passed after it
+func flakyRun(failed WorkflowRun, passed int64, reRun bool) FlakyRun {
+	f := FlakyRun{
+		Workflow:  failed.Workflow,
+		RunID:     failed.ID,
+		Attempt:   failed.Attempt,
+		Branch:    failed.Branch,
+		HeadSHA:   failed.HeadSHA,
+		Failed:    failed.Created,
+		PassedRun: passed,
+		ReRun:     reRun,
+	}
+	if failed.Completed != nil {
+		f.Failed = *failed.Completed
+	}
+	return f
+}
+
+// GetRunDurations returns how long the successful attempts of the workflows of the repository ran over the last
+// weeks, of a single workflow if it's given
+func GetRunDurations(repo_id int, workflow string, weeks int) ([]WorkflowDurations, error) {
+	now := time.Now()
+	since := weekOf(now.UTC()).AddDate(0, 0, -7*(weeks-1))
+	runs, err := store.GetWorkflowRuns(repo_id, WorkflowRunFilter{Workflow: workflow, Since: &since})
+	if err != nil {
+		return nil, err
+	}
+
+	return RunDurationTrend(runs, weeks, now), nil
+}
+
+// RunDurationTrend sums up how long the successful attempts of each workflow ran week by week, over the weeks
+// ending with the one of now
+func RunDurationTrend(runs []WorkflowRun, weeks int, now time.Time) []WorkflowDurations {
+	current := weekOf(now.UTC())
+	byWeek := map[string]map[int64][]time.Duration{}
+	for _, r := range runs {
+		if r.Conclusion != RunSuccess || r.Started == nil || r.Completed == nil {
+			continue
+		}
+		if byWeek[r.Workflow] == nil {
+			byWeek[r.Workflow] = map[int64][]time.Duration{}
+		}
+		w := weekOf(r.Created.UTC()).Unix()
+		byWeek[r.Workflow][w] = append(byWeek[r.Workflow][w], r.Duration())
+	}
+
+	trends := []WorkflowDurations{}
+	for workflow, durations := range byWeek {
+		t := WorkflowDurations{Workflow: workflow, Weeks: []WeekDurations{}}
+		for i := weeks - 1; i >= 0; i-- {
+			w := current.AddDate(0, 0, -7*i)
+			t.Weeks = append(t.Weeks, WeekDurations{Week: w, RunDurations: runDurations(durations[w.Unix()])})
+		}
+		trends = append(trends, t)
+	}
+	sort.Slice(trends, func(i, j int) bool { return trends[i].Workflow < trends[j].Workflow })
+	return trends
+}
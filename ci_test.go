@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCIHealthReport(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	run := func(id int64, attempt int, workflow, branch, sha, conclusion string, hour, minutes int) WorkflowRun {
+		created := start.Add(time.Duration(hour) * time.Hour)
+		completed := created.Add(time.Duration(minutes) * time.Minute)
+		return WorkflowRun{ID: id, Attempt: attempt, Workflow: workflow, Branch: branch, HeadSHA: sha, Status: RunCompleted,
+			Conclusion: conclusion, Created: created, Started: &created, Completed: &completed}
+	}
+	runs := []WorkflowRun{
+		run(1, 1, "ci", "main", "a", RunSuccess, 0, 10),
+		// passed once run again
+		run(2, 2, "ci", "main", "b", RunSuccess, 2, 12),
+		run(2, 1, "ci", "main", "b", RunFailure, 1, 5),
+		// failed twice, then passed on the same commit
+		run(3, 1, "ci", "main", "c", RunFailure, 3, 4),
+		run(4, 1, "ci", "main", "c", RunTimedOut, 4, 4),
+		run(5, 1, "ci", "main", "c", RunSuccess, 5, 20),
+		// broken by d, fixed by e, broken again by f
+		run(6, 1, "ci", "main", "d", RunFailure, 6, 3),
+		run(7, 1, "ci", "main", "e", RunSuccess, 7, 8),
+		run(8, 1, "ci", "main", "f", RunFailure, 8, 3),
+		run(9, 1, "lint", "feature", "x", RunCancelled, 1, 1),
+		run(10, 1, "lint", "feature", "x", RunFailure, 2, 1),
+	}
+
+	h := CIHealthReport(runs, "main")
+
+	if len(h.Workflows) != 2 {
+		t.Fatalf("workflows = %+v", h.Workflows)
+	}
+	ci, lint := h.Workflows[0], h.Workflows[1]
+	if ci.Workflow != "ci" || ci.Runs != 8 || ci.Succeeded != 4 || ci.Failed != 4 || ci.ReRun != 1 || ci.Flaky != 3 ||
+		ci.SuccessRate == nil || *ci.SuccessRate != 0.5 {
+		t.Errorf("ci health = %+v", ci)
+	}
+	if ci.Durations != (RunDurations{Count: 4, P50Minutes: 10, P95Minutes: 20}) {
+		t.Errorf("ci durations = %+v", ci.Durations)
+	}
+	if lint.Workflow != "lint" || lint.Runs != 2 || lint.Cancelled != 1 || lint.Failed != 1 || lint.Flaky != 0 ||
+		lint.SuccessRate == nil || *lint.SuccessRate != 0 || lint.Durations.Count != 0 {
+		t.Errorf("lint health = %+v", lint)
+	}
+
+	flaky := []struct {
+		runID, passed int64
+		reRun         bool
+	}{
+		{4, 5, false},
+		{3, 5, false},
+		{2, 2, true},
+	}
+	if len(h.Flaky) != len(flaky) {
+		t.Fatalf("flaky runs = %+v", h.Flaky)
+	}
+	for i, f := range flaky {
+		if got := h.Flaky[i]; got.RunID != f.runID || got.PassedRun != f.passed || got.ReRun != f.reRun {
+			t.Errorf("flaky run %d = %+v, want run %d passed by %d", i, got, f.runID, f.passed)
+		}
+	}
+
+	if len(h.Breakages) != 2 {
+		t.Fatalf("breakages = %+v", h.Breakages)
+	}
+	if b := h.Breakages[0]; b.HeadSHA != "f" || b.RunID != 8 || b.Fixed != nil {
+		t.Errorf("latest breakage = %+v, want f still broken", b)
+	}
+	if b := h.Breakages[1]; b.HeadSHA != "d" || b.FixedSHA != "e" || b.Fixed == nil || !b.Fixed.Equal(*runs[7].Completed) {
+		t.Errorf("first breakage = %+v, want d fixed by e", b)
+	}
+}
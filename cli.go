@@ -0,0 +1,1892 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage : github-api [command]
+
+Without a command the interactive ui is started.
+
+commands :
+  repo add <url>                    fetch a repository and start tracking it
+  repo list [--trend week] [--language l] [--min-stars n]
+                                    list tracked repositories, with how much their stars and forks
+                                    changed over the last day, week or month with --trend
+  repo history <repo> [--window month]
+                                    list the counters recorded by every refresh over the window
+  repo rm <repo>                    stop tracking a repository and delete its commits
+  commits pull <repo> [--since d] [--full]
+                                    sync the commits of a repository, only merge those since YYYY-MM-DD
+                                    with --since, or fetch everything again and replace them with --full
+  commits list <repo> [--branch b] [--merges only|exclude] [--author a] [--since d] [--until d] [--message re]
+                                    list the stored commits of a repository, --message keeps those whose
+                                    message matches a regular expression
+  commits stats <repo> [--branch b] count the merge, rebased, signed and verified commits of a repository
+  authors top <repo> [-n 10] [--branch b]
+                                    list the top authors of a repository
+  authors list                      list the contributors of every repository with their logins, emails and names
+  authors merge <from-id> <into-id> attribute the commits of a contributor to another one
+  authors mailmap <file>            attribute commits as mapped by a file in the .mailmap format
+  pulls sync <repo>                 fetch the pull requests updated since the last sync and list them
+  pulls list <repo> [--state s] [--author login]
+                                    list the stored pull requests of a repository
+  pulls metrics <repo> [--by-author] [--window 90d]
+                                    time to first review, approval and merge, review rounds, sizes and the
+                                    share merged without review, over the last 30d, 90d, 365d and all
+                                    time, or per author over a window with --by-author
+  issues sync <repo>                fetch the issues updated since the last sync and list them
+  issues list|count <repo> [--state s] [--label l] [--assignee login] [--author login] [--milestone m]
+                                    list or count the stored issues of a repository
+  issues backlog <repo> [--weeks 12] [--label l] ...
+                                    count the open issues at the end of each week
+  releases sync <repo>              fetch the tags and releases of a repository and list the releases
+  releases list <repo>              list the stored releases of a repository
+  releases commits <repo> <tag>     list the commits shipped in a release since the previous one
+  tags list <repo>                  list the stored tags of a repository
+  deploys sync <repo>               fetch the deployments of a repository and their statuses and list them
+  deploys list <repo>               list the stored deployments of a repository
+  deploys dora <repo> [--period week] [-n 12] [--environment e]
+                                    deployment frequency, lead time for changes, change failure rate and
+                                    time to restore week by week or month by month, for production if
+                                    it's deployed to, from the releases or tags without deployments
+  ci sync <repo>                    fetch the workflows of a repository and their runs since the last sync
+  ci runs <repo> [--workflow w] [--branch b] [-n 50]
+                                    list the stored workflow runs, every attempt of them
+  ci workflows <repo> [--days 90]   success rate, re-runs, flaky runs and p50/p95 duration of each workflow
+  ci durations <repo> [--weeks 12] [--workflow w]
+                                    p50/p95 duration of the successful runs of each workflow week by week
+  ci flaky <repo> [--days 90]       list the failures that passed when run again
+  ci breakages <repo> [--days 90]   list the commits that broke a workflow on the default branch
+  churn fetch <repo> [--limit 0]    fetch the changed files of the commits that don't have them yet
+  churn dirs <repo> [--weeks 12] [--depth 1]
+                                    sum the lines changed under each directory week by week
+  churn files <repo> [--weeks 12] [-n 20]
+                                    list the files changed by the most commits
+  churn authors <repo> [--weeks 12] list the lines added and removed by each contributor
+  churn coupling <repo> [--weeks 12] [-n 20] [--min 3]
+                                    list the pairs of files most often changed by the same commits
+  ownership summary <repo> [--branch b]
+                                    count the fewest authors who made half and 80% of the commits over
+                                    the last 30, 90 and 365 days and all time
+  ownership shares <repo> [--branch b] [-n 10]
+                                    list the share of the commits of each author over those windows
+  ownership inactive <repo> [--branch b] [--days 90]
+                                    list the authors without commits over the last days
+  ownership dirs <repo> [--branch b] [--depth 1] [-n 3]
+                                    list the top authors of each directory, from the changed files
+  activity heatmap <repo> [--tz Europe/Paris] [--branch b]
+                                    count the commits by weekday and hour of the day
+  activity weeks <repo> [--weeks 52] [--tz z] [--branch b]
+                                    count the commits week by week
+  activity summary <repo> [--tz z] [--branch b]
+                                    show the longest streaks and gaps between commits
+  branches list <repo>              fetch and list the branches of a repository
+  branches track|untrack <repo> <branch>
+                                    start or stop syncing the commits of a branch
+  refresh [--workers 4]             refresh every tracked repository, several at once
+  daemon [--addr :8080] [--api]     run the refresh scheduler headless until SIGINT/SIGTERM
+  serve [--addr :8080]              serve the rest api, see /openapi.json
+  migrate up|down [n]|status        manage the database schema
+
+<repo> is a repository id, url or name. Every command except migrate, daemon and serve takes --output table|json|csv.
+`
+
+// runCommand runs the non interactive command given on the command line
+func runCommand(args []string) error {
+	// report rate limit waits on stderr so they don't end up in piped output
+	github.OnWait = func(reason string, until time.Time) {
+		fmt.Fprintf(os.Stderr, "%s. Waiting until %v (%v)...\n", reason, until.Format("15:04:05"), time.Until(until).Round(time.Second))
+	}
+
+	switch strings.Join(firstN(args, 2), " ") {
+	case "repo add":
+		return cmdRepoAdd(args[2:])
+	case "repo list":
+		return cmdRepoList(args[2:])
+	case "repo history":
+		return cmdRepoHistory(args[2:])
+	case "repo rm":
+		return cmdRepoRemove(args[2:])
+	case "commits pull":
+		return cmdCommitsPull(args[2:])
+	case "commits list":
+		return cmdCommitsList(args[2:])
+	case "commits stats":
+		return cmdCommitsStats(args[2:])
+	case "authors top":
+		return cmdAuthorsTop(args[2:])
+	case "authors list":
+		return cmdAuthorsList(args[2:])
+	case "authors merge":
+		return cmdAuthorsMerge(args[2:])
+	case "authors mailmap":
+		return cmdAuthorsMailmap(args[2:])
+	case "pulls list":
+		return cmdPullsList(args[2:])
+	case "pulls metrics":
+		return cmdPullsMetrics(args[2:])
+	case "pulls sync":
+		return cmdPullsSync(args[2:])
+	case "issues sync":
+		return cmdIssuesSync(args[2:])
+	case "issues list":
+		return cmdIssuesList(args[2:])
+	case "issues count":
+		return cmdIssuesCount(args[2:])
+	case "issues backlog":
+		return cmdIssuesBacklog(args[2:])
+	case "releases sync":
+		return cmdReleasesSync(args[2:])
+	case "releases list":
+		return cmdReleasesList(args[2:])
+	case "releases commits":
+		return cmdReleaseCommits(args[2:])
+	case "tags list":
+		return cmdTagsList(args[2:])
+	case "deploys sync":
+		return cmdDeploysSync(args[2:])
+	case "deploys list":
+		return cmdDeploysList(args[2:])
+	case "deploys dora":
+		return cmdDeploysDORA(args[2:])
+	case "ci sync":
+		return cmdCISync(args[2:])
+	case "ci runs":
+		return cmdCIRuns(args[2:])
+	case "ci workflows":
+		return cmdCIWorkflows(args[2:])
+	case "ci durations":
+		return cmdCIDurations(args[2:])
+	case "ci flaky":
+		return cmdCIFlaky(args[2:])
+	case "ci breakages":
+		return cmdCIBreakages(args[2:])
+	case "churn fetch":
+		return cmdChurnFetch(args[2:])
+	case "churn dirs":
+		return cmdChurnDirs(args[2:])
+	case "churn files":
+		return cmdChurnFiles(args[2:])
+	case "churn authors":
+		return cmdChurnAuthors(args[2:])
+	case "churn coupling":
+		return cmdChurnCoupling(args[2:])
+	case "ownership summary":
+		return cmdOwnershipSummary(args[2:])
+	case "ownership shares":
+		return cmdOwnershipShares(args[2:])
+	case "ownership inactive":
+		return cmdOwnershipInactive(args[2:])
+	case "ownership dirs":
+		return cmdOwnershipDirs(args[2:])
+	case "activity heatmap":
+		return cmdActivityHeatmap(args[2:])
+	case "activity weeks":
+		return cmdActivityWeeks(args[2:])
+	case "activity summary":
+		return cmdActivitySummary(args[2:])
+	case "branches list":
+		return cmdBranchesList(args[2:])
+	case "branches track":
+		return cmdBranchesTrack(args[2:], true)
+	case "branches untrack":
+		return cmdBranchesTrack(args[2:], false)
+	}
+
+	switch args[0] {
+	case "daemon":
+		return cmdDaemon(args[1:])
+	case "serve":
+		return cmdServe(args[1:])
+	case "refresh":
+		return cmdRefresh(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", strings.Join(args, " "), usage)
+}
+
+func firstN(args []string, n int) []string {
+	if len(args) < n {
+		return args
+	}
+	return args[:n]
+}
+
+// newFlagSet creates the flag set of a command with the common --output flag
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("output", "table", "output format, table, json or csv")
+	return fs, output
+}
+
+// parseArgs parses flags wherever they appear among the arguments and returns the positional ones
+func parseArgs(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	rest := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(rest) != positional {
+		return nil, fmt.Errorf("%s expects %d argument(s), got %d", fs.Name(), positional, len(rest))
+	}
+
+	return rest, nil
+}
+
+// resolveRepo finds a tracked repository by id, url or name
+func resolveRepo(ref string) (*Repository, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		r, err := store.GetRepoByID(id)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no tracked repository with id %d", id)
+		}
+		return r, err
+	}
+
+	if URL, err := SanitizeRepoURL(ref); err == nil {
+		if r, err := store.GetRepoByURL(URL); err == nil {
+			return r, nil
+		}
+	}
+
+	repos, err := store.GetRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []Repository{}
+	for _, r := range repos {
+		if strings.EqualFold(r.Name, ref) {
+			matches = append(matches, r)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no tracked repository matches %q", ref)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d repositories are named %q, use the id or url instead", len(matches), ref)
+	}
+}
+
+func reposOutput(repos []Repository) Output {
+	o := Output{
+		Headers: []string{"ID", "Name", "Language", "Forks", "Stars", "Issues", "Watchers", "Host", "URL"},
+		Data:    repos,
+	}
+	for _, r := range repos {
+		o.Rows = append(o.Rows, []string{
+			strconv.Itoa(r.ID), r.Name, r.Language,
+			strconv.Itoa(r.ForksCount), strconv.Itoa(r.StarsCount),
+			strconv.Itoa(r.OpenIssuesCount), strconv.Itoa(r.WatchersCount),
+			r.Host, r.URL,
+		})
+	}
+	return o
+}
+
+func commitsOutput(commits []Commit) Output {
+	o := Output{
+		Headers: []string{"SHA", "Date", "Author", "Email", "Flags", "Message"},
+		Data:    commits,
+	}
+	for _, c := range commits {
+		msg, _, _ := strings.Cut(c.Message, "\n")
+		o.Rows = append(o.Rows, []string{
+			c.SHA, c.Date.Format(time.RFC3339), c.AuthorName, c.AuthorEmail, commitFlags(c), msg,
+		})
+	}
+	return o
+}
+
+// commitFlags describes whether the commit is a merge, was rebased, and is signed
+func commitFlags(c Commit) string {
+	flags := []string{}
+	if c.Merge() {
+		flags = append(flags, "merge")
+	}
+	if !c.Committed.Equal(c.Date) {
+		flags = append(flags, "rebased")
+	}
+	switch {
+	case c.Verified:
+		flags = append(flags, "verified")
+	case c.Signed():
+		flags = append(flags, "signed")
+	}
+	return strings.Join(flags, ",")
+}
+
+func cmdRepoAdd(args []string) error {
+	fs, output := newFlagSet("repo add")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	URL, err := SanitizeRepoURL(pos[0])
+	if err != nil {
+		return err
+	}
+
+	repo, err := FetchRepo(URL)
+	if err != nil {
+		return err
+	}
+
+	// read it back to get the id assigned by the database
+	repo, err = store.GetRepoByURL(repo.URL)
+	if err != nil {
+		return err
+	}
+
+	return reposOutput([]Repository{*repo}).Write(os.Stdout, *output)
+}
+
+func cmdRepoList(args []string) error {
+	fs, output := newFlagSet("repo list")
+	window := fs.String("trend", "", "show the changes over the last day, week or month")
+	f := RepoFilter{}
+	fs.StringVar(&f.Language, "language", "", "only list the repositories in this language")
+	fs.IntVar(&f.MinStars, "min-stars", 0, "only list the repositories with at least this many stars")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	repos, err := store.ListRepos(f)
+	if err != nil {
+		return err
+	}
+	if *window == "" {
+		return reposOutput(repos).Write(os.Stdout, *output)
+	}
+
+	trends := []RepoTrend{}
+	for _, r := range repos {
+		t, err := store.GetTrend(r.ID, *window)
+		if err != nil {
+			return err
+		}
+		trends = append(trends, *t)
+	}
+
+	return trendsOutput(repos, trends).Write(os.Stdout, *output)
+}
+
+func cmdRepoHistory(args []string) error {
+	fs, output := newFlagSet("repo history")
+	window := fs.String("window", WindowMonth, "list the snapshots of the last day, week or month")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	start, err := windowStart(*window, time.Now())
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	snaps, err := store.GetSnapshots(repo.ID, start)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Taken", "Stars", "Forks", "Issues", "Watchers"},
+		Data:    snaps,
+	}
+	for _, s := range snaps {
+		o.Rows = append(o.Rows, []string{
+			s.Taken.Format(time.RFC3339), strconv.Itoa(s.StarsCount), strconv.Itoa(s.ForksCount),
+			strconv.Itoa(s.OpenIssuesCount), strconv.Itoa(s.WatchersCount),
+		})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+// trendsOutput lists the repositories with the change of each counter next to it
+func trendsOutput(repos []Repository, trends []RepoTrend) Output {
+	data := []struct {
+		Repository
+		Trend RepoTrend `json:"trend"`
+	}{}
+	o := Output{
+		Headers: []string{"ID", "Name", "Language", "Forks", "Stars", "Issues", "Watchers", "Host", "URL"},
+	}
+	for i, r := range repos {
+		t := trends[i]
+		data = append(data, struct {
+			Repository
+			Trend RepoTrend `json:"trend"`
+		}{r, t})
+		o.Rows = append(o.Rows, []string{
+			strconv.Itoa(r.ID), r.Name, r.Language,
+			strconv.Itoa(r.ForksCount) + trendMark(t.ForksCount), strconv.Itoa(r.StarsCount) + trendMark(t.StarsCount),
+			strconv.Itoa(r.OpenIssuesCount) + trendMark(t.OpenIssuesCount), strconv.Itoa(r.WatchersCount) + trendMark(t.WatchersCount),
+			r.Host, r.URL,
+		})
+	}
+	o.Data = data
+	return o
+}
+
+func cmdRepoRemove(args []string) error {
+	fs, output := newFlagSet("repo rm")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	err = store.DeleteRepo(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	return reposOutput([]Repository{*repo}).Write(os.Stdout, *output)
+}
+
+func cmdCommitsPull(args []string) error {
+	fs, output := newFlagSet("commits pull")
+	since := fs.String("since", "", "only pull commits since this date, YYYY-MM-DD")
+	full := fs.Bool("full", false, "fetch the whole history again and replace the stored commits")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	var result *SyncResult
+	switch {
+	case *full:
+		result, err = ResyncCommits(repo)
+	case *since != "":
+		t, perr := time.Parse("2006-01-02", *since)
+		if perr != nil {
+			return fmt.Errorf("invalid --since date : %v", perr)
+		}
+		result, err = PullCommitsSince(repo, t)
+	default:
+		result, err = SyncCommits(repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	return syncOutput(repo, result).Write(os.Stdout, *output)
+}
+
+// syncOutput formats the result of a sync
+func syncOutput(repo *Repository, r *SyncResult) Output {
+	return Output{
+		Headers: []string{"Repository", "Fetched", "Added", "Complete"},
+		Rows:    [][]string{{repo.Name, strconv.Itoa(r.Fetched), strconv.Itoa(r.Added), strconv.FormatBool(r.Complete)}},
+		Data:    r,
+	}
+}
+
+func cmdCommitsList(args []string) error {
+	fs, output := newFlagSet("commits list")
+	f := CommitFilter{}
+	fs.StringVar(&f.Branch, "branch", "", "only list the commits reachable from this branch")
+	fs.StringVar(&f.Merges, "merges", "", "only list the merge commits with only, leave them out with exclude")
+	fs.StringVar(&f.Author, "author", "", "only list the commits of this author, by name, email or login")
+	fs.StringVar(&f.Message, "message", "", "only list the commits whose message matches this regular expression")
+	since := fs.String("since", "", "only list the commits made since this day, YYYY-MM-DD")
+	until := fs.String("until", "", "only list the commits made until this day included, YYYY-MM-DD")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if f.Merges != "" && f.Merges != MergesOnly && f.Merges != MergesExclude {
+		return fmt.Errorf("invalid --merges %q, use only or exclude", f.Merges)
+	}
+	err = store.CheckRegexp(f.Message)
+	if err != nil {
+		return fmt.Errorf("invalid --message : %v", err)
+	}
+	f.Since, f.Until, err = parseDays(*since, *until)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	commits, err := store.ListCommits(repo.ID, f)
+	if err != nil {
+		return err
+	}
+
+	return commitsOutput(commits).Write(os.Stdout, *output)
+}
+
+// parseDays parses the days starting and ending a range, YYYY-MM-DD, until covers the whole day. Either can be empty.
+func parseDays(since, until string) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	if since != "" {
+		t, err := time.Parse("2006-01-02", since)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid since date : %v", err)
+		}
+		from = &t
+	}
+	if until != "" {
+		t, err := time.Parse("2006-01-02", until)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid until date : %v", err)
+		}
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		to = &t
+	}
+	return from, to, nil
+}
+
+func cmdCommitsStats(args []string) error {
+	fs, output := newFlagSet("commits stats")
+	branch := fs.String("branch", "", "only count the commits reachable from this branch")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	stats, err := store.GetCommitStats(repo.ID, *branch)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Commits", "Merges", "Rebased", "Signed", "Verified", "Signed Share", "Verified Share"},
+		Rows: [][]string{{
+			strconv.Itoa(stats.Commits), strconv.Itoa(stats.Merges), strconv.Itoa(stats.Rebased),
+			strconv.Itoa(stats.Signed), strconv.Itoa(stats.Verified), formatShare(stats.SignedShare), formatShare(stats.VerifiedShare),
+		}},
+		Data: stats,
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdAuthorsTop(args []string) error {
+	fs, output := newFlagSet("authors top")
+	n := fs.Int("n", 10, "number of authors to list, 0 for all")
+	branch := fs.String("branch", "", "only count the commits reachable from this branch")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	authors, err := store.GetTopAuthors(repo.ID, *n, *branch)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Commits", "Email", "Name", "Login", "Contributor"},
+		Data:    authors,
+	}
+	for _, a := range authors {
+		o.Rows = append(o.Rows, []string{strconv.Itoa(a.Commits), a.AuthorEmail, a.AuthorName, a.Login, strconv.Itoa(a.ContributorID)})
+	}
+
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdAuthorsList(args []string) error {
+	fs, output := newFlagSet("authors list")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	contributors, err := store.GetContributors()
+	if err != nil {
+		return err
+	}
+
+	return contributorsOutput(contributors).Write(os.Stdout, *output)
+}
+
+func cmdAuthorsMerge(args []string) error {
+	fs, output := newFlagSet("authors merge")
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	ids := []int{}
+	for _, p := range pos {
+		id, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("invalid contributor id %q", p)
+		}
+		ids = append(ids, id)
+	}
+
+	err = store.MergeContributors(ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+
+	contributors, err := store.GetContributors()
+	if err != nil {
+		return err
+	}
+	for _, c := range contributors {
+		if c.ID == ids[1] {
+			return contributorsOutput([]Contributor{c}).Write(os.Stdout, *output)
+		}
+	}
+	return nil
+}
+
+func cmdAuthorsMailmap(args []string) error {
+	fs, output := newFlagSet("authors mailmap")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(pos[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := ParseMailmap(f)
+	if err != nil {
+		return err
+	}
+
+	n, err := store.ApplyMailmap(entries)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Entries Applied"},
+		Rows:    [][]string{{strconv.Itoa(n)}},
+		Data:    map[string]int{"applied": n},
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func contributorsOutput(contributors []Contributor) Output {
+	o := Output{
+		Headers: []string{"ID", "Commits", "Name", "Email", "Login", "Aliases"},
+		Data:    contributors,
+	}
+	for _, c := range contributors {
+		o.Rows = append(o.Rows, []string{
+			strconv.Itoa(c.ID), strconv.Itoa(c.Commits), c.Name, c.Email, c.Login, strings.Join(c.Aliases, ","),
+		})
+	}
+	return o
+}
+
+func cmdBranchesList(args []string) error {
+	fs, output := newFlagSet("branches list")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	branches, err := FetchBranches(repo)
+	if err != nil {
+		return err
+	}
+
+	return branchesOutput(branches).Write(os.Stdout, *output)
+}
+
+// cmdBranchesTrack starts or stops following a branch of a repository
+func cmdBranchesTrack(args []string, tracked bool) error {
+	name := "branches untrack"
+	if tracked {
+		name = "branches track"
+	}
+	fs, output := newFlagSet(name)
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	branch, err := findBranch(repo, pos[1])
+	if err != nil {
+		return err
+	}
+
+	err = store.TrackBranch(repo.ID, branch.Name, tracked)
+	if err != nil {
+		return err
+	}
+	branch.Tracked = tracked
+
+	return branchesOutput([]Branch{*branch}).Write(os.Stdout, *output)
+}
+
+func branchesOutput(branches []Branch) Output {
+	o := Output{
+		Headers: []string{"Name", "Head", "Default", "Tracked"},
+		Data:    branches,
+	}
+	for _, b := range branches {
+		o.Rows = append(o.Rows, []string{b.Name, b.HeadSHA, strconv.FormatBool(b.Default), strconv.FormatBool(b.Tracked)})
+	}
+	return o
+}
+
+func cmdPullsList(args []string) error {
+	fs, output := newFlagSet("pulls list")
+	state := fs.String("state", "", "only list pull requests in this state, open, merged or closed")
+	author := fs.String("author", "", "only list pull requests opened by this login")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	prs, err := store.ListPullRequests(repo.ID, PullRequestFilter{State: *state, Author: *author})
+	if err != nil {
+		return err
+	}
+
+	return pullsOutput(prs).Write(os.Stdout, *output)
+}
+
+func cmdPullsSync(args []string) error {
+	fs, output := newFlagSet("pulls sync")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = SyncPullRequests(repo)
+	if err != nil {
+		return err
+	}
+
+	prs, err := store.ListPullRequests(repo.ID, PullRequestFilter{})
+	if err != nil {
+		return err
+	}
+
+	return pullsOutput(prs).Write(os.Stdout, *output)
+}
+
+func cmdPullsMetrics(args []string) error {
+	fs, output := newFlagSet("pulls metrics")
+	byAuthor := fs.Bool("by-author", false, "list the metrics of each author over the window")
+	window := fs.String("window", "90d", "window of the metrics per author, 30d, 90d, 365d or all")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	m, err := GetPullMetrics(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	headers := []string{"PRs", "Merged", "No Review", "First Review", "Approval", "Merge", "Rounds", "XS/S/M/L/XL"}
+	row := func(s ReviewStats) []string {
+		sizes := []string{}
+		for _, size := range pullSizes {
+			sizes = append(sizes, strconv.Itoa(s.Sizes[size.Name]))
+		}
+		return []string{strconv.Itoa(s.PullRequests), strconv.Itoa(s.Merged), formatShare(s.MergedWithoutReviewShare),
+			formatHours(s.TimeToFirstReview.MedianHours), formatHours(s.TimeToApproval.MedianHours),
+			formatHours(s.TimeToMerge.MedianHours), strconv.FormatFloat(s.ReviewRounds, 'f', 1, 64), strings.Join(sizes, "/")}
+	}
+
+	if !*byAuthor {
+		o := Output{Headers: append([]string{"Window"}, headers...), Data: m}
+		for _, w := range m.Windows {
+			o.Rows = append(o.Rows, append([]string{w.Window}, row(w.Stats)...))
+		}
+		return o.Write(os.Stdout, *output)
+	}
+
+	w := m.window(*window)
+	if w == nil {
+		return fmt.Errorf("invalid --window %q, use 30d, 90d, 365d or all", *window)
+	}
+	o := Output{Headers: append([]string{"Author"}, headers...), Data: w}
+	for _, a := range w.Authors {
+		o.Rows = append(o.Rows, append([]string{a.Author}, row(a.ReviewStats)...))
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func pullsOutput(prs []PullRequest) Output {
+	o := Output{
+		Headers: []string{"Number", "State", "Draft", "Author", "Base", "Head", "Created", "+/-", "Commits", "Title"},
+		Data:    prs,
+	}
+	for _, pr := range prs {
+		o.Rows = append(o.Rows, []string{
+			strconv.Itoa(pr.Number), pr.State, strconv.FormatBool(pr.Draft), pr.Author, pr.BaseRef, pr.HeadRef,
+			pr.Created.Format("2006-01-02"), fmt.Sprintf("+%d/-%d", pr.Additions, pr.Deletions),
+			strconv.Itoa(len(pr.Commits)), pr.Title,
+		})
+	}
+	return o
+}
+
+// issueFlags adds the issue filter flags to the flag set
+func issueFlags(fs *flag.FlagSet) *IssueFilter {
+	f := new(IssueFilter)
+	fs.StringVar(&f.State, "state", "", "only issues in this state, open or closed")
+	fs.StringVar(&f.Label, "label", "", "only issues with this label")
+	fs.StringVar(&f.Assignee, "assignee", "", "only issues assigned to this login")
+	fs.StringVar(&f.Author, "author", "", "only issues opened by this login")
+	fs.StringVar(&f.Milestone, "milestone", "", "only issues in this milestone")
+	return f
+}
+
+func cmdIssuesSync(args []string) error {
+	fs, output := newFlagSet("issues sync")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = SyncIssues(repo)
+	if err != nil {
+		return err
+	}
+
+	issues, err := store.ListIssues(repo.ID, IssueFilter{})
+	if err != nil {
+		return err
+	}
+
+	return issuesOutput(issues).Write(os.Stdout, *output)
+}
+
+func cmdIssuesList(args []string) error {
+	fs, output := newFlagSet("issues list")
+	f := issueFlags(fs)
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	issues, err := store.ListIssues(repo.ID, *f)
+	if err != nil {
+		return err
+	}
+
+	return issuesOutput(issues).Write(os.Stdout, *output)
+}
+
+func cmdIssuesCount(args []string) error {
+	fs, output := newFlagSet("issues count")
+	f := issueFlags(fs)
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := store.CountIssues(repo.ID, *f)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Issues"},
+		Rows:    [][]string{{strconv.Itoa(n)}},
+		Data:    map[string]int{"issues": n},
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdIssuesBacklog(args []string) error {
+	fs, output := newFlagSet("issues backlog")
+	f := issueFlags(fs)
+	weeks := fs.Int("weeks", 12, "number of weeks to go back")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	// the backlog is about issues open at the time, whatever their state now
+	f.State = ""
+	issues, err := store.ListIssues(repo.ID, *f)
+	if err != nil {
+		return err
+	}
+
+	points := IssueBacklog(issues, *weeks, time.Now())
+	o := Output{
+		Headers: []string{"Week Ending", "Open", "Opened", "Closed"},
+		Data:    points,
+	}
+	for _, p := range points {
+		o.Rows = append(o.Rows, []string{p.Date.Format("2006-01-02"), strconv.Itoa(p.Open), strconv.Itoa(p.Opened), strconv.Itoa(p.Closed)})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func issuesOutput(issues []Issue) Output {
+	o := Output{
+		Headers: []string{"Number", "State", "Author", "Created", "Comments", "Labels", "Assignees", "Milestone", "Title"},
+		Data:    issues,
+	}
+	for _, i := range issues {
+		o.Rows = append(o.Rows, []string{
+			strconv.Itoa(i.Number), i.State, i.Author, i.Created.Format("2006-01-02"), strconv.Itoa(i.Comments),
+			strings.Join(i.Labels, ","), strings.Join(i.Assignees, ","), i.Milestone, i.Title,
+		})
+	}
+	return o
+}
+
+func cmdReleasesSync(args []string) error {
+	fs, output := newFlagSet("releases sync")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = SyncReleases(repo)
+	if err != nil {
+		return err
+	}
+
+	releases, err := store.GetReleases(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	return releasesOutput(releases).Write(os.Stdout, *output)
+}
+
+func cmdReleasesList(args []string) error {
+	fs, output := newFlagSet("releases list")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	releases, err := store.GetReleases(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	return releasesOutput(releases).Write(os.Stdout, *output)
+}
+
+func cmdReleaseCommits(args []string) error {
+	fs, output := newFlagSet("releases commits")
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	commits, err := store.GetReleaseCommits(repo.ID, pos[1])
+	if err != nil {
+		return err
+	}
+
+	return commitsOutput(commits).Write(os.Stdout, *output)
+}
+
+func cmdDeploysSync(args []string) error {
+	fs, output := newFlagSet("deploys sync")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = SyncDeployments(repo)
+	if err != nil {
+		return err
+	}
+
+	deployments, err := store.GetDeployments(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	return deploymentsOutput(deployments).Write(os.Stdout, *output)
+}
+
+func cmdDeploysList(args []string) error {
+	fs, output := newFlagSet("deploys list")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	deployments, err := store.GetDeployments(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	return deploymentsOutput(deployments).Write(os.Stdout, *output)
+}
+
+func deploymentsOutput(deployments []Deployment) Output {
+	o := Output{
+		Headers: []string{"ID", "Environment", "State", "Ref", "SHA", "Creator", "Created", "Commits"},
+		Data:    deployments,
+	}
+	for _, d := range deployments {
+		o.Rows = append(o.Rows, []string{
+			strconv.FormatInt(d.ID, 10), d.Environment, d.State, d.Ref, d.SHA, d.Creator,
+			d.Created.Format("2006-01-02 15:04"), strconv.Itoa(d.Commits),
+		})
+	}
+	return o
+}
+
+func cmdDeploysDORA(args []string) error {
+	fs, output := newFlagSet("deploys dora")
+	period := fs.String("period", "week", "length of the periods, week or month")
+	n := fs.Int("n", 12, "number of periods to go back")
+	environment := fs.String("environment", "", "environment of the deployments, production or every one by default")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	d, err := GetDORA(repo, *environment, *period, *n)
+	if err != nil {
+		return err
+	}
+
+	return doraOutput(d).Write(os.Stdout, *output)
+}
+
+func doraOutput(d *DORA) Output {
+	o := Output{
+		Headers: []string{"Period", "Deployments", "Lead Time", "P90 Lead Time", "Failure Rate", "Time To Restore"},
+		Data:    d,
+	}
+	row := func(name string, p DORAPeriod) []string {
+		rate, restore := "-", "-"
+		if p.ChangeFailureRate != nil {
+			rate = formatShare(*p.ChangeFailureRate)
+		}
+		if p.TimeToRestore.Count > 0 {
+			restore = formatHours(p.TimeToRestore.MedianHours)
+		}
+		lead, p90 := "-", "-"
+		if p.LeadTime.Count > 0 {
+			lead, p90 = formatHours(p.LeadTime.MedianHours), formatHours(p.LeadTime.P90Hours)
+		}
+		return []string{name, strconv.Itoa(p.Deployments), lead, p90, rate, restore}
+	}
+	for _, p := range d.Periods {
+		o.Rows = append(o.Rows, row(p.Start.Format("2006-01-02"), p))
+	}
+	o.Rows = append(o.Rows, row("total", d.Total))
+	return o
+}
+
+func cmdCISync(args []string) error {
+	fs, output := newFlagSet("ci sync")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	updated, err := SyncWorkflowRuns(repo)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Repository", "Runs Updated"},
+		Rows:    [][]string{{repo.Name, strconv.Itoa(updated)}},
+		Data:    map[string]int{"updated": updated},
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdCIRuns(args []string) error {
+	fs, output := newFlagSet("ci runs")
+	f := WorkflowRunFilter{}
+	fs.StringVar(&f.Workflow, "workflow", "", "only runs of this workflow")
+	fs.StringVar(&f.Branch, "branch", "", "only runs on this branch")
+	fs.IntVar(&f.Limit, "n", 50, "number of attempts to list, 0 for all")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	runs, err := store.GetWorkflowRuns(repo.ID, f)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"ID", "Attempt", "Workflow", "Event", "Branch", "SHA", "Conclusion", "Created", "Duration", "Author"},
+		Data:    runs,
+	}
+	for _, r := range runs {
+		conclusion := r.Conclusion
+		if r.Status != RunCompleted {
+			conclusion = r.Status
+		}
+		o.Rows = append(o.Rows, []string{
+			strconv.FormatInt(r.ID, 10), strconv.Itoa(r.Attempt), r.Workflow, r.Event, r.Branch, r.HeadSHA, conclusion,
+			r.Created.Format("2006-01-02 15:04"), r.Duration().Round(time.Second).String(), r.CommitAuthor,
+		})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+// ciHealthArgs parses the arguments of the ci commands reporting on the runs of the last days
+func ciHealthArgs(fs *flag.FlagSet, args []string) (*CIHealth, error) {
+	days := fs.Int("days", 90, "number of days of runs to report on, 0 for all")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return GetCIHealth(repo, *days)
+}
+
+func cmdCIWorkflows(args []string) error {
+	fs, output := newFlagSet("ci workflows")
+	h, err := ciHealthArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return ciWorkflowsOutput(h).Write(os.Stdout, *output)
+}
+
+func ciWorkflowsOutput(h *CIHealth) Output {
+	o := Output{
+		Headers: []string{"Workflow", "Runs", "Succeeded", "Failed", "Cancelled", "Success Rate", "Re-run", "Flaky", "P50", "P95"},
+		Data:    h.Workflows,
+	}
+	for _, w := range h.Workflows {
+		rate := "-"
+		if w.SuccessRate != nil {
+			rate = formatShare(*w.SuccessRate)
+		}
+		o.Rows = append(o.Rows, []string{
+			w.Workflow, strconv.Itoa(w.Runs), strconv.Itoa(w.Succeeded), strconv.Itoa(w.Failed), strconv.Itoa(w.Cancelled),
+			rate, strconv.Itoa(w.ReRun), strconv.Itoa(w.Flaky),
+			formatMinutes(w.Durations.P50Minutes), formatMinutes(w.Durations.P95Minutes),
+		})
+	}
+	return o
+}
+
+// formatMinutes formats a duration given in minutes
+func formatMinutes(minutes float64) string {
+	return strconv.FormatFloat(minutes, 'f', 1, 64) + "m"
+}
+
+func cmdCIDurations(args []string) error {
+	fs, output := newFlagSet("ci durations")
+	weeks := fs.Int("weeks", 12, "number of weeks to go back")
+	workflow := fs.String("workflow", "", "only this workflow")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *weeks < 1 {
+		return fmt.Errorf("invalid --weeks %d", *weeks)
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	trends, err := GetRunDurations(repo.ID, *workflow, *weeks)
+	if err != nil {
+		return err
+	}
+
+	o := Output{Headers: []string{"Workflow", "Week", "Runs", "P50", "P95"}, Data: trends}
+	for _, t := range trends {
+		for _, w := range t.Weeks {
+			o.Rows = append(o.Rows, []string{t.Workflow, w.Week.Format("2006-01-02"), strconv.Itoa(w.Count),
+				formatMinutes(w.P50Minutes), formatMinutes(w.P95Minutes)})
+		}
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdCIFlaky(args []string) error {
+	fs, output := newFlagSet("ci flaky")
+	h, err := ciHealthArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o := Output{Headers: []string{"Workflow", "Run", "Attempt", "Branch", "SHA", "Failed", "Passed By"}, Data: h.Flaky}
+	for _, f := range h.Flaky {
+		passed := "re-run"
+		if !f.ReRun {
+			passed = "run " + strconv.FormatInt(f.PassedRun, 10)
+		}
+		o.Rows = append(o.Rows, []string{f.Workflow, strconv.FormatInt(f.RunID, 10), strconv.Itoa(f.Attempt), f.Branch,
+			f.HeadSHA, f.Failed.Format("2006-01-02 15:04"), passed})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdCIBreakages(args []string) error {
+	fs, output := newFlagSet("ci breakages")
+	h, err := ciHealthArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o := Output{Headers: []string{"Workflow", "SHA", "Author", "Broken", "Fixed By", "Broken For", "Message"}, Data: h.Breakages}
+	for _, b := range h.Breakages {
+		msg, _, _ := strings.Cut(b.Message, "\n")
+		fixed, brokenFor := "-", "-"
+		if b.Fixed != nil {
+			fixed = b.FixedSHA
+			brokenFor = formatHours(b.Fixed.Sub(b.Broken).Hours())
+		}
+		o.Rows = append(o.Rows, []string{b.Workflow, b.HeadSHA, b.Author, b.Broken.Format("2006-01-02 15:04"), fixed,
+			brokenFor, msg})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdTagsList(args []string) error {
+	fs, output := newFlagSet("tags list")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	tags, err := store.GetTags(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Name", "SHA"},
+		Data:    tags,
+	}
+	for _, t := range tags {
+		o.Rows = append(o.Rows, []string{t.Name, t.SHA})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func releasesOutput(releases []Release) Output {
+	o := Output{
+		Headers: []string{"Tag", "Name", "Published", "Since Previous", "Commits", "Downloads", "Author", "Flags", "Target"},
+		Data:    releases,
+	}
+	for _, r := range releases {
+		published, since := "", ""
+		if r.Published != nil {
+			published = r.Published.Format("2006-01-02")
+		}
+		if r.SincePrevious > 0 {
+			since = formatDays(r.SincePrevious)
+		}
+		o.Rows = append(o.Rows, []string{
+			r.Tag, r.Name, published, since, strconv.Itoa(r.Commits), strconv.Itoa(r.Downloads), r.Author,
+			releaseFlags(r), r.TargetSHA,
+		})
+	}
+	return o
+}
+
+// releaseFlags describes whether the release is a draft or a prerelease
+func releaseFlags(r Release) string {
+	flags := []string{}
+	if r.Draft {
+		flags = append(flags, "draft")
+	}
+	if r.Prerelease {
+		flags = append(flags, "prerelease")
+	}
+	return strings.Join(flags, ",")
+}
+
+// formatDays formats a duration in days, the scale releases are apart
+func formatDays(d time.Duration) string {
+	return strconv.FormatFloat(d.Hours()/24, 'f', 1, 64) + "d"
+}
+
+func cmdChurnFetch(args []string) error {
+	fs, output := newFlagSet("churn fetch")
+	limit := fs.Int("limit", 0, "number of commits to fetch, 0 for all")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	fetched, err := SyncCommitFiles(repo, *limit)
+	if err != nil {
+		return err
+	}
+
+	pending, err := store.GetCommitsWithoutFiles(repo.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Repository", "Fetched", "Pending"},
+		Rows:    [][]string{{repo.Name, strconv.Itoa(fetched), strconv.Itoa(len(pending))}},
+		Data:    map[string]int{"fetched": fetched, "pending": len(pending)},
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+// churnArgs parses the flags shared by the churn reports and returns the repository along with its file changes
+func churnArgs(fs *flag.FlagSet, args []string) (*Repository, []FileChange, error) {
+	weeks := fs.Int("weeks", 12, "number of weeks to go back, 0 for all time")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := store.GetFileChanges(repo.ID, churnSince(*weeks, time.Now()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, changes, nil
+}
+
+func cmdChurnDirs(args []string) error {
+	fs, output := newFlagSet("churn dirs")
+	depth := fs.Int("depth", 1, "number of directory levels to group by")
+	_, changes, err := churnArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return dirChurnOutput(ChurnByDirectory(changes, *depth)).Write(os.Stdout, *output)
+}
+
+func dirChurnOutput(dirs []DirectoryChurn) Output {
+	o := Output{
+		Headers: []string{"Week", "Directory", "Commits", "Added", "Removed"},
+		Data:    dirs,
+	}
+	for _, d := range dirs {
+		o.Rows = append(o.Rows, []string{d.Week.Format("2006-01-02"), d.Directory, strconv.Itoa(d.Commits),
+			strconv.Itoa(d.Additions), strconv.Itoa(d.Deletions)})
+	}
+	return o
+}
+
+func cmdChurnFiles(args []string) error {
+	fs, output := newFlagSet("churn files")
+	n := fs.Int("n", 20, "number of files to list, 0 for all")
+	_, changes, err := churnArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return fileChurnOutput(HottestFiles(changes, *n)).Write(os.Stdout, *output)
+}
+
+func fileChurnOutput(files []FileChurn) Output {
+	o := Output{
+		Headers: []string{"Commits", "Authors", "Added", "Removed", "File"},
+		Data:    files,
+	}
+	for _, f := range files {
+		o.Rows = append(o.Rows, []string{strconv.Itoa(f.Commits), strconv.Itoa(f.Authors),
+			strconv.Itoa(f.Additions), strconv.Itoa(f.Deletions), f.Filename})
+	}
+	return o
+}
+
+func cmdChurnAuthors(args []string) error {
+	fs, output := newFlagSet("churn authors")
+	weeks := fs.Int("weeks", 12, "number of weeks to go back, 0 for all time")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return err
+	}
+
+	authors, err := store.GetAuthorChurn(repo.ID, churnSince(*weeks, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return authorChurnOutput(authors).Write(os.Stdout, *output)
+}
+
+func authorChurnOutput(authors []AuthorChurn) Output {
+	o := Output{
+		Headers: []string{"Commits", "Added", "Removed", "Email", "Name", "Login", "Contributor"},
+		Data:    authors,
+	}
+	for _, a := range authors {
+		o.Rows = append(o.Rows, []string{strconv.Itoa(a.Commits), strconv.Itoa(a.Additions), strconv.Itoa(a.Deletions),
+			a.Email, a.Name, a.Login, strconv.Itoa(a.ContributorID)})
+	}
+	return o
+}
+
+func cmdChurnCoupling(args []string) error {
+	fs, output := newFlagSet("churn coupling")
+	n := fs.Int("n", 20, "number of pairs to list, 0 for all")
+	min := fs.Int("min", 3, "minimum number of commits changing both files")
+	_, changes, err := churnArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return couplingOutput(FileCouplings(changes, *min, *n)).Write(os.Stdout, *output)
+}
+
+func couplingOutput(pairs []FileCoupling) Output {
+	o := Output{
+		Headers: []string{"Together", "Degree", "File", "Changed With"},
+		Data:    pairs,
+	}
+	for _, p := range pairs {
+		o.Rows = append(o.Rows, []string{strconv.Itoa(p.Together), strconv.FormatFloat(p.Degree*100, 'f', 0, 64) + "%",
+			p.FileA, p.FileB})
+	}
+	return o
+}
+
+// ownershipArgs parses the flags shared by the ownership reports and returns the repository with the branch to count
+func ownershipArgs(fs *flag.FlagSet, args []string) (*Repository, string, error) {
+	branch := fs.String("branch", "", "only count the commits reachable from this branch")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return repo, *branch, nil
+}
+
+// formatShare formats a share as a percentage
+func formatShare(share float64) string {
+	return strconv.FormatFloat(share*100, 'f', 1, 64) + "%"
+}
+
+func cmdOwnershipSummary(args []string) error {
+	fs, output := newFlagSet("ownership summary")
+	repo, branch, err := ownershipArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o, err := GetOwnership(repo.ID, branch, 90, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	out := Output{
+		Headers: []string{"Window", "Commits", "Authors", "Bus Factor 50%", "Bus Factor 80%", "Top Author", "Top Share"},
+		Data:    o.Windows,
+	}
+	for _, w := range o.Windows {
+		top, share := "", ""
+		if len(w.Shares) > 0 {
+			top, share = w.Shares[0].Name, formatShare(w.Shares[0].Share)
+		}
+		out.Rows = append(out.Rows, []string{w.Window, strconv.Itoa(w.Commits), strconv.Itoa(w.Authors),
+			strconv.Itoa(w.BusFactor50), strconv.Itoa(w.BusFactor80), top, share})
+	}
+	return out.Write(os.Stdout, *output)
+}
+
+func cmdOwnershipShares(args []string) error {
+	fs, output := newFlagSet("ownership shares")
+	n := fs.Int("n", 10, "number of authors to list, 0 for all")
+	repo, branch, err := ownershipArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o, err := GetOwnership(repo.ID, branch, 90, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	return sharesOutput(o, *n).Write(os.Stdout, *output)
+}
+
+// sharesOutput lists the top n authors of all time with their share of the commits over each window
+func sharesOutput(o *Ownership, n int) Output {
+	out := Output{Headers: []string{"Contributor", "Name", "Email"}}
+	for _, w := range o.Windows {
+		out.Headers = append(out.Headers, w.Window)
+	}
+	out.Headers = append(out.Headers, "Last Commit")
+
+	all := o.Windows[len(o.Windows)-1].Shares
+	if n > 0 && len(all) > n {
+		all = all[:n]
+	}
+	out.Data = all
+	for _, a := range all {
+		row := []string{strconv.Itoa(a.ContributorID), a.Name, a.Email}
+		for _, w := range o.Windows {
+			share := "-"
+			for _, s := range w.Shares {
+				if s.ContributorID == a.ContributorID {
+					share = formatShare(s.Share)
+				}
+			}
+			row = append(row, share)
+		}
+		out.Rows = append(out.Rows, append(row, a.LastCommit.Format("2006-01-02")))
+	}
+	return out
+}
+
+func cmdOwnershipInactive(args []string) error {
+	fs, output := newFlagSet("ownership inactive")
+	days := fs.Int("days", 90, "number of days without commits")
+	repo, branch, err := ownershipArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o, err := GetOwnership(repo.ID, branch, *days, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	out := Output{
+		Headers: []string{"Contributor", "Name", "Email", "Commits", "Share", "Last Commit"},
+		Data:    o.Inactive,
+	}
+	for _, a := range o.Inactive {
+		out.Rows = append(out.Rows, []string{strconv.Itoa(a.ContributorID), a.Name, a.Email, strconv.Itoa(a.Commits),
+			formatShare(a.Share), a.LastCommit.Format("2006-01-02")})
+	}
+	return out.Write(os.Stdout, *output)
+}
+
+func cmdOwnershipDirs(args []string) error {
+	fs, output := newFlagSet("ownership dirs")
+	depth := fs.Int("depth", 1, "number of directory levels to group by")
+	n := fs.Int("n", 3, "number of owners to list per directory, 0 for all")
+	repo, branch, err := ownershipArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	o, err := GetOwnership(repo.ID, branch, 90, *depth, *n)
+	if err != nil {
+		return err
+	}
+
+	out := Output{
+		Headers: []string{"Directory", "Commits", "Bus Factor 50%", "Owners"},
+		Data:    o.Directories,
+	}
+	for _, d := range o.Directories {
+		out.Rows = append(out.Rows, []string{d.Directory, strconv.Itoa(d.Commits), strconv.Itoa(d.BusFactor50), formatOwners(d.Owners)})
+	}
+	return out.Write(os.Stdout, *output)
+}
+
+// formatOwners lists owners with their share, e.g. "ann 60.0%, bob 25.0%"
+func formatOwners(owners []AuthorShare) string {
+	parts := []string{}
+	for _, a := range owners {
+		parts = append(parts, a.Name+" "+formatShare(a.Share))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// activityArgs parses the flags shared by the activity reports and builds the activity of the repository
+// over the given number of weeks
+func activityArgs(fs *flag.FlagSet, args []string, weeks *int) (*Activity, error) {
+	tz := fs.String("tz", "", "timezone to count the days and hours in, UTC by default")
+	branch := fs.String("branch", "", "only count the commits reachable from this branch")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := loadTimezone(*tz)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := resolveRepo(pos[0])
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	if weeks != nil {
+		n = *weeks
+	}
+	return GetActivity(repo.ID, *branch, loc, n)
+}
+
+func cmdActivityHeatmap(args []string) error {
+	fs, output := newFlagSet("activity heatmap")
+	a, err := activityArgs(fs, args, nil)
+	if err != nil {
+		return err
+	}
+
+	o := Output{Headers: []string{"Day"}, Data: a.Heatmap}
+	for h := 0; h < 24; h++ {
+		o.Headers = append(o.Headers, fmt.Sprintf("%02d", h))
+	}
+	// monday first
+	for i := 1; i <= 7; i++ {
+		d := time.Weekday(i % 7)
+		row := []string{d.String()[:3]}
+		for _, count := range a.Heatmap[d] {
+			row = append(row, strconv.Itoa(count))
+		}
+		o.Rows = append(o.Rows, row)
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdActivityWeeks(args []string) error {
+	fs, output := newFlagSet("activity weeks")
+	weeks := fs.Int("weeks", 52, "number of weeks to go back")
+	a, err := activityArgs(fs, args, weeks)
+	if err != nil {
+		return err
+	}
+
+	o := Output{
+		Headers: []string{"Week", "Commits"},
+		Data:    a.Weeks,
+	}
+	for _, w := range a.Weeks {
+		o.Rows = append(o.Rows, []string{w.Week.Format("2006-01-02"), strconv.Itoa(w.Commits)})
+	}
+	return o.Write(os.Stdout, *output)
+}
+
+func cmdActivitySummary(args []string) error {
+	fs, output := newFlagSet("activity summary")
+	a, err := activityArgs(fs, args, nil)
+	if err != nil {
+		return err
+	}
+
+	return activitySummaryOutput(a).Write(os.Stdout, *output)
+}
+
+func activitySummaryOutput(a *Activity) Output {
+	span := func(s *ActivitySpan) []string {
+		if s == nil {
+			return []string{"0", "", ""}
+		}
+		return []string{strconv.Itoa(s.Days), s.From.Format("2006-01-02"), s.To.Format("2006-01-02")}
+	}
+	row := []string{a.Timezone, strconv.Itoa(a.Commits), strconv.Itoa(a.ActiveDays)}
+	row = append(row, span(a.LongestStreak)...)
+	row = append(row, span(a.LongestGap)...)
+	row = append(row, strconv.Itoa(a.CurrentStreak), strconv.Itoa(a.DaysSinceLast))
+	return Output{
+		Headers: []string{"Timezone", "Commits", "Active Days", "Longest Streak", "From", "To",
+			"Longest Gap", "From", "To", "Current Streak", "Days Since Last"},
+		Rows: [][]string{row},
+		Data: a,
+	}
+}
+
+func cmdRefresh(args []string) error {
+	fs, output := newFlagSet("refresh")
+	workers := fs.Int("workers", refreshWorkers(), "number of repositories refreshed at once")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	summary := RefreshRepos(context.Background(), *workers)
+	for _, f := range summary.Failures {
+		fmt.Fprintf(os.Stderr, "%s : %s\n", f.Repository, f.Error)
+	}
+
+	err = refreshOutput(summary).Write(os.Stdout, *output)
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d repositories failed to refresh", summary.Failed)
+	}
+	return nil
+}
+
+func refreshOutput(s *RefreshSummary) Output {
+	return Output{
+		Headers: []string{"Repos", "Updated", "Skipped", "Failed", "Commits Added", "PRs Updated", "Issues Updated", "Releases Added", "Deployments", "Runs", "Commit Files", "Duration"},
+		Rows: [][]string{{
+			strconv.Itoa(s.Repos), strconv.Itoa(s.Updated), strconv.Itoa(s.Skipped),
+			strconv.Itoa(s.Failed), strconv.Itoa(s.CommitsAdded), strconv.Itoa(s.PullRequestsUpdated), strconv.Itoa(s.IssuesUpdated),
+			strconv.Itoa(s.ReleasesAdded), strconv.Itoa(s.DeploymentsUpdated), strconv.Itoa(s.RunsUpdated), strconv.Itoa(s.CommitFilesFetched),
+			s.Duration.Round(time.Millisecond).String(),
+		}},
+		Data: s,
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is the github api client shared by every fetcher. It tracks the rate limit budget
+// across all calls, waits out primary and secondary rate limits, and retries server and
+// network errors with jittered exponential backoff.
+type Client struct {
+	HTTP       *http.Client
+	MaxRetries int
+
+	// OnWait is called whenever the client pauses before sending a request,
+	// with the reason and the time it expects to resume
+	OnWait func(reason string, until time.Time)
+
+	// Context bounds the requests sent by Get and GetIfChanged, cancelling it aborts them and any wait
+	Context context.Context
+
+	mu           sync.Mutex
+	remaining    int
+	reset        time.Time
+	blockedUntil time.Time
+}
+
+// APIError is returned for responses github answered with an unexpected status
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("github api error : %v, %v", e.Status, e.Body)
+}
+
+// ErrNotModified is returned by GetIfChanged when the resource is unchanged since the last fetch
+var ErrNotModified = errors.New("not modified")
+
+// github is the client used for all api calls so they share one rate limit budget
+var github = NewClient()
+
+// NewClient creates a client with sensible timeouts and retry defaults
+func NewClient() *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: 60 * time.Second},
+		MaxRetries: 5,
+		remaining:  -1,
+	}
+}
+
+// RateLimit returns the last known remaining request count and reset time, remaining is -1 if unknown
+func (c *Client) RateLimit() (int, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remaining, c.reset
+}
+
+// Do sends the request, waiting for the rate limit budget and retrying where it makes sense.
+// Rate limited and server error responses are closed and retried, any other response is returned as is.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	attempt := 0
+	for {
+		took, err := c.waitForBudget(req)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.HTTP.Do(req.Clone(req.Context()))
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, req.Context().Err()
+			}
+			if attempt >= c.MaxRetries {
+				return nil, err
+			}
+			LogError(fmt.Errorf("error with request to %s, retrying : %v", req.URL, err))
+			err = c.backoff(req, attempt, "network error")
+			if err != nil {
+				return nil, err
+			}
+			attempt++
+			continue
+		}
+
+		// conditional requests answered with 304 don't count against the rate limit
+		if resp.StatusCode != http.StatusNotModified {
+			c.updateBudget(resp.Header)
+		} else if took {
+			c.giveBack()
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+			limited, bounded := c.checkRateLimited(resp)
+			if !limited {
+				// a regular permission error, let the caller handle it
+				return resp, nil
+			}
+			resp.Body.Close()
+
+			if !bounded {
+				if attempt >= c.MaxRetries {
+					return nil, fmt.Errorf("rate limited by github, giving up after %d attempts", attempt+1)
+				}
+				attempt++
+			}
+		case resp.StatusCode >= 500:
+			resp.Body.Close()
+			if attempt >= c.MaxRetries {
+				return nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status}
+			}
+			err = c.backoff(req, attempt, resp.Status)
+			if err != nil {
+				return nil, err
+			}
+			attempt++
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// Get sends a GET request to the given url and reads the response body.
+// Any status other than 2xx is returned as an *APIError.
+func (c *Client) Get(URL string) (*http.Response, []byte, error) {
+	req, err := c.newRequest(URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, body, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	return resp, body, nil
+}
+
+// GetIfChanged is like Get but sends the validators stored for the url from the previous fetch.
+// It returns ErrNotModified if github answers 304, and the new validators otherwise, nil if there are none.
+// They aren't stored, the caller saves them once it has stored what the body holds, so that a failure in
+// between doesn't make the next fetch look unchanged.
+func (c *Client) GetIfChanged(URL string) (*http.Response, []byte, *CacheEntry, error) {
+	req, err := c.newRequest(URL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entry, err := store.GetCacheEntry(URL)
+	if err != nil {
+		// still fetch, just without the validators
+		LogError(fmt.Errorf("error loading cache entry : %v", err))
+	}
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil, nil, ErrNotModified
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, body, nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	if resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return resp, body, nil, nil
+	}
+	validators := &CacheEntry{
+		URL:          URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Updated:      time.Now(),
+	}
+	return resp, body, validators, nil
+}
+
+// saveValidators stores the validators returned by GetIfChanged, once what came with them is stored
+func saveValidators(validators *CacheEntry) {
+	if validators == nil {
+		return
+	}
+	err := store.SaveCacheEntry(validators)
+	if err != nil {
+		LogError(fmt.Errorf("error saving cache entry : %v", err))
+	}
+}
+
+// newRequest creates a GET request bound to the client context
+func (c *Client) newRequest(URL string) (*http.Request, error) {
+	req, err := NewRequest("GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Context != nil {
+		req = req.WithContext(c.Context)
+	}
+
+	return req, nil
+}
+
+// updateBudget records the rate limit headers of a response
+func (c *Client) updateBudget(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remaining = remaining
+	c.reset = time.Unix(reset, 0)
+}
+
+// checkRateLimited tells if a 403/429 response is a rate limit, and whether the wait is bounded by
+// a reset time given by github. If it is, the client will hold further requests until then.
+func (c *Client) checkRateLimited(resp *http.Response) (limited bool, bounded bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// secondary rate limits tell us how long to wait
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		seconds, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			c.blockedUntil = time.Now().Add(time.Duration(seconds) * time.Second)
+			return true, true
+		}
+	}
+
+	// primary rate limit exhausted, updateBudget already recorded the reset time
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true, true
+	}
+
+	// secondary rate limit without a retry-after header, wait at least a minute
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusTooManyRequests || strings.Contains(strings.ToLower(string(body)), "rate limit") {
+		c.blockedUntil = time.Now().Add(time.Minute)
+		return true, false
+	}
+
+	// put back what was read so the caller sees the full body
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	return false, false
+}
+
+// waitForBudget blocks until the client is allowed to send another request, then takes it out of
+// the budget. Concurrent callers share the budget, so they can't overshoot it between responses.
+// took tells whether the request was taken out of it, it's not when the budget is unknown.
+func (c *Client) waitForBudget(req *http.Request) (took bool, err error) {
+	for {
+		c.mu.Lock()
+		until := time.Time{}
+		reason := ""
+		if c.remaining == 0 && c.reset.After(time.Now()) {
+			until = c.reset
+			reason = "rate limit exceeded"
+		}
+		if c.blockedUntil.After(until) {
+			until = c.blockedUntil
+			reason = "secondary rate limit"
+		}
+		if until.IsZero() && c.remaining > 0 {
+			// the next response will correct this with the count github has
+			c.remaining--
+			took = true
+		}
+		c.mu.Unlock()
+
+		if until.IsZero() {
+			return took, nil
+		}
+
+		err = c.wait(req, reason, until)
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// giveBack puts a request taken by waitForBudget back in the budget, for the ones github didn't count
+func (c *Client) giveBack() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remaining++
+}
+
+// backoff sleeps for a jittered exponential delay before retrying
+func (c *Client) backoff(req *http.Request, attempt int, reason string) error {
+	delay := time.Second << attempt
+	if delay > time.Minute {
+		delay = time.Minute
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	return c.wait(req, "retrying after "+reason, time.Now().Add(delay))
+}
+
+func (c *Client) wait(req *http.Request, reason string, until time.Time) error {
+	LogApp(fmt.Sprintf("%s. Waiting until %v (%v)...", reason, until, time.Until(until).Round(time.Second)))
+	if c.OnWait != nil {
+		c.OnWait(reason, until)
+	}
+
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
@@ -1,35 +1,133 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strconv"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
-
-	"github.com/nsf/termbox-go"
 )
 
+// Commit is a commit of a repository. Date is when it was authored, Committed when it was committed,
+// which differ once a commit is rebased or cherry-picked. Commits with several parents are merges.
 type Commit struct {
 	SHA         string    `json:"sha" db:"sha"`
 	Message     string    `json:"message" db:"message"`
 	URL         string    `json:"url" db:"url"`
 	AuthorName  string    `json:"author_name" db:"author_name"`
 	AuthorEmail string    `json:"author_email" db:"author_email"`
+	AuthorLogin string    `json:"author_login" db:"author_login"`
 	Date        time.Time `json:"date" db:"date"`
 
-	RepositoryID int `json:"repository_id" db:"repository_id"`
+	CommitterName  string    `json:"committer_name" db:"committer_name"`
+	CommitterEmail string    `json:"committer_email" db:"committer_email"`
+	CommitterLogin string    `json:"committer_login" db:"committer_login"`
+	Committed      time.Time `json:"committed_at" db:"committed_at"`
+
+	Parents         []string `json:"parents" db:"parents"`
+	Verified        bool     `json:"verified" db:"verified"`
+	SignatureReason string   `json:"signature_reason" db:"signature_reason"`
+
+	RepositoryID  int `json:"repository_id" db:"repository_id"`
+	ContributorID int `json:"contributor_id" db:"contributor_id"`
+}
+
+// Merge tells whether the commit merges several parents
+func (c Commit) Merge() bool {
+	return len(c.Parents) > 1
+}
+
+// Signed tells whether the commit carries a signature, verified or not
+func (c Commit) Signed() bool {
+	return c.SignatureReason != "" && c.SignatureReason != "unsigned"
+}
+
+// githubCommit is a commit as returned by the commits and compare apis
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	URL    string `json:"url"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"author"`
+		Committer struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"committer"`
+		Verification struct {
+			Verified bool   `json:"verified"`
+			Reason   string `json:"reason"`
+		} `json:"verification"`
+	} `json:"commit"`
+	// the github accounts of the author and committer, null when the email isn't linked to one
+	Author *struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	Committer *struct {
+		Login string `json:"login"`
+	} `json:"committer"`
+	Parents []struct {
+		SHA string `json:"sha"`
+	} `json:"parents"`
+}
+
+func (g githubCommit) toCommit(repo_id int) Commit {
+	c := Commit{
+		SHA:             g.SHA,
+		Message:         g.Commit.Message,
+		URL:             g.URL,
+		AuthorName:      g.Commit.Author.Name,
+		AuthorEmail:     g.Commit.Author.Email,
+		Date:            g.Commit.Author.Date,
+		CommitterName:   g.Commit.Committer.Name,
+		CommitterEmail:  g.Commit.Committer.Email,
+		Committed:       g.Commit.Committer.Date,
+		Parents:         []string{},
+		Verified:        g.Commit.Verification.Verified,
+		SignatureReason: g.Commit.Verification.Reason,
+		RepositoryID:    repo_id,
+	}
+	if g.Author != nil {
+		c.AuthorLogin = g.Author.Login
+	}
+	if g.Committer != nil {
+		c.CommitterLogin = g.Committer.Login
+	}
+	for _, p := range g.Parents {
+		c.Parents = append(c.Parents, p.SHA)
+	}
+	if c.Committed.IsZero() {
+		c.Committed = c.Date
+	}
+	return c
+}
+
+// SaveCommit saves the given commit to the commits table, commits already stored are left as is
+func (s *sqlStore) SaveCommit(c *Commit) error {
+	_, err := insertCommit(s.db, c)
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
-// Save saves the given repository metadata to the repositories table
-func (c *Commit) Save() error {
-	// get db connection instance
-	db, err := SQLConnect()
+// insertCommit saves the commit attributed to its contributor, the login of a commit already stored
+// still ties its author's emails together
+func insertCommit(db querier, c *Commit) (sql.Result, error) {
+	id, err := resolveContributor(db, c.AuthorLogin, c.AuthorName, c.AuthorEmail)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error resolving the author : %v", err)
 	}
+	c.ContributorID = id
 
 	// insert statement
 	insert := `insert into commits (
@@ -38,335 +136,326 @@ func (c *Commit) Save() error {
 		url,
 		author_name,
 		author_email,
+		author_login,
 		date,
-		repository_id
-	) values ($1,$2,$3,$4,$5,$6,$7)
+		committer_name,
+		committer_email,
+		committer_login,
+		committed_at,
+		parents,
+		verified,
+		signature_reason,
+		repository_id,
+		contributor_id
+	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
 	 ON CONFLICT (sha) DO NOTHING`
 
+	committed := c.Committed
+	if committed.IsZero() {
+		committed = c.Date
+	}
+
 	// execute insert statement
-	_, err = db.Exec(insert,
+	return db.Exec(insert,
 		c.SHA,
 		c.Message,
 		c.URL,
 		c.AuthorName,
 		c.AuthorEmail,
-		c.Date,
+		c.AuthorLogin,
+		c.Date.UTC(),
+		c.CommitterName,
+		c.CommitterEmail,
+		c.CommitterLogin,
+		committed.UTC(),
+		strings.Join(c.Parents, " "),
+		c.Verified,
+		c.SignatureReason,
 		c.RepositoryID,
+		c.ContributorID,
 	)
-
-	return err
 }
 
-var cwg sync.WaitGroup
-
-// FetchCommits fetchs the repository metadata from github, stores it, and return it
-func FetchCommits(repo_url string, start *time.Time) ([]Commit, error) {
-	// wait for any other active jobs
-	cwg.Wait()
-
-	// add to waitgroup
-	cwg.Add(1)
-
-	// get repo from repos table
-	repo, err := GetRepoByURL(repo_url)
-	if err != nil {
-		LogError(fmt.Errorf("error getting repository from database : %v", err))
-		return nil, err
+// commitsURL creates the base url for fetching the commits of a repo, on the default branch if branch is empty
+func commitsURL(repo_url, branch string, start *time.Time) string {
+	params := url.Values{}
+	if branch != "" {
+		params.Set("sha", branch)
+	}
+	if start != nil && !start.IsZero() {
+		params.Set("since", start.UTC().Format("2006-01-02T15:04:05Z"))
 	}
 
 	URL := repo_url + "/commits"
-	if start != nil {
-		URL += "?since=" + start.Format("2006-01-02T15:04:05Z")
+	if len(params) > 0 {
+		URL += "?" + params.Encode()
 	}
+	return URL
+}
 
-	commits := []Commit{}
-
-	// clear existing commits
-	err = DeleteCommitByRepoID(repo.ID)
+// fetchCommitPage fetches a page of a commits listing and returns its commits along with the url of the next page
+func fetchCommitPage(repo *Repository, URL string) ([]Commit, string, error) {
+	resp, body, err := github.Get(URL)
 	if err != nil {
-		LogError(fmt.Errorf("error clearing commits : %v", err))
-		return nil, err
+		LogError(fmt.Errorf("error with commits request : %v", err))
+		return nil, "", err
 	}
 
-	for URL != "" {
-		// create the request
-		req, err := NewRequest("GET", URL, nil)
-		if err != nil {
-			LogError(fmt.Errorf("error creating commit request : %v", err))
-			return nil, err
-		}
-
-		// create https client and execute the request
-		client := http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			LogError(fmt.Errorf("error with commits request : %v", err))
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
-			// check for rate limit and wait for reset time
-			resetTimeStr := resp.Header.Get("X-RateLimit-Reset")
-			resetTimeInt, err := strconv.ParseInt(resetTimeStr, 10, 64)
-			if err != nil {
-				LogError(fmt.Errorf("error parsing X-RateLimit-Reset : %s", err))
-			}
-
-			resetTime := time.Unix(resetTimeInt, 0)
-			info := fmt.Sprintf("Rate limit exceeded. Waiting until %v (%v seconds)...\n", resetTime, time.Until(resetTime))
-			LogApp(info)
-			y++
-			x = 0
-			drawText(x, y, termbox.ColorCyan, termbox.ColorBlack, info)
-			termbox.Flush()
-			time.Sleep(time.Until(resetTime))
-			continue
-		}
-
-		// set URL to next page link, will be empty and break loop if no next link
-		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
-
-		// read the response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			LogError(fmt.Errorf("error reading commits response : %v", err))
-			return nil, err
-		}
+	return parseCommitPage(repo, resp, body)
+}
 
-		response := []struct {
-			SHA    string `json:"sha"`
-			URL    string `json:"url"`
-			Commit struct {
-				Message string `json:"message"`
-				Author  struct {
-					AuthorName  string    `json:"name"`
-					AuthorEmail string    `json:"email"`
-					Date        time.Time `json:"date"`
-				} `json:"author"`
-			} `json:"commit"`
-		}{}
-
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			LogError(fmt.Errorf("error parsing commits : %v", err))
-			return nil, err
-		}
+// fetchCommitPageIfChanged is fetchCommitPage sending the validators of the previous fetch of the page. It returns
+// ErrNotModified if the page is unchanged and its new validators otherwise, to save once its commits are stored.
+func fetchCommitPageIfChanged(repo *Repository, URL string) ([]Commit, string, *CacheEntry, error) {
+	resp, body, validators, err := github.GetIfChanged(URL)
+	if err == ErrNotModified {
+		return nil, "", nil, err
+	}
+	if err != nil {
+		LogError(fmt.Errorf("error with commits request : %v", err))
+		return nil, "", nil, err
+	}
 
-		for _, c := range response {
-			commits = append(commits, Commit{
-				SHA:          c.SHA,
-				Message:      c.Commit.Message,
-				URL:          c.URL,
-				AuthorName:   c.Commit.Author.AuthorName,
-				AuthorEmail:  c.Commit.Author.AuthorEmail,
-				Date:         c.Commit.Author.Date,
-				RepositoryID: repo.ID,
-			})
-		}
+	commits, next, err := parseCommitPage(repo, resp, body)
+	return commits, next, validators, err
+}
 
-		// save commits
-		for _, commit := range commits {
-			commit.RepositoryID = repo.ID
-			err = commit.Save()
-			if err != nil {
-				LogError(fmt.Errorf("error saving commit : %v", err))
-			}
-		}
+// parseCommitPage parses a page of a commits listing, see fetchCommitPage
+func parseCommitPage(repo *Repository, resp *http.Response, body []byte) ([]Commit, string, error) {
+	response := []githubCommit{}
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		LogError(fmt.Errorf("error parsing commits : %v", err))
+		return nil, "", err
 	}
 
-	cwg.Done()
+	commits := []Commit{}
+	for _, c := range response {
+		commits = append(commits, c.toCommit(repo.ID))
+	}
 
-	return commits, nil
+	// next page link, empty if this was the last page
+	return commits, GetNextFromLinkHeader(resp.Header.Get("link")), nil
 }
-func FetchCommitsNoOverride(repo_url string, start *time.Time) ([]Commit, error) {
-	// wait for any other active jobs
-	cwg.Wait()
 
-	// add to waitgroup
-	cwg.Add(1)
+// commitColumns lists the columns scanned into a Commit, in order
+const commitColumns = `sha, message, url, author_name, author_email, author_login, date,
+	committer_name, committer_email, committer_login, committed_at, parents, verified, signature_reason,
+	repository_id, COALESCE(contributor_id, 0)`
 
-	// get repo from repos table
-	repo, err := GetRepoByURL(repo_url)
+func (s *sqlStore) GetCommits(repo_id int) ([]Commit, error) {
+	rows, err := s.db.Query("SELECT "+commitColumns+" FROM commits WHERE repository_id=$1 ORDER BY date DESC", repo_id)
 	if err != nil {
-		LogError(fmt.Errorf("error getting repository from database : %v", err))
+		LogError(fmt.Errorf("error getting commits from db : %v", err))
 		return nil, err
 	}
-
-	// create base url for fetching the commits
-	URL := repo_url + "/commits"
-	if start != nil {
-		URL += "?since=" + start.Format("2006-01-02T15:04:05Z")
-	}
+	defer rows.Close()
 
 	commits := []Commit{}
-
-	// run loop while there's a url to fetch commits (could be pages)
-	for URL != "" {
-		// create the request
-		req, err := NewRequest("GET", URL, nil)
-		if err != nil {
-			LogError(fmt.Errorf("error creating commit request : %v", err))
-			return nil, err
-		}
-
-		// create https client and execute the request
-		client := http.Client{}
-		resp, err := client.Do(req)
+	for rows.Next() {
+		c, err := scanCommit(rows)
 		if err != nil {
-			LogError(fmt.Errorf("error with commits request : %v", err))
+			LogError(fmt.Errorf("error scanning commit result : %v", err))
 			return nil, err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
-			// check for rate limit and wait for reset time
-			resetTimeStr := resp.Header.Get("X-RateLimit-Reset")
-			resetTimeInt, err := strconv.ParseInt(resetTimeStr, 10, 64)
-			if err != nil {
-				LogError(fmt.Errorf("error parsing X-RateLimit-Reset : %s", err))
-			}
-
-			resetTime := time.Unix(resetTimeInt, 0)
-			LogApp(fmt.Sprintf("Rate limit exceeded. Waiting until %v (%v seconds)...\n", resetTime, time.Until(resetTime)))
-			time.Sleep(time.Until(resetTime))
-			continue
-		}
 
-		// set URL to next page link, will be empty and break loop if no next link
-		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+		commits = append(commits, *c)
+	}
 
-		// read the response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			LogError(fmt.Errorf("error reading commits response : %v", err))
-			return nil, err
-		}
+	return commits, rows.Err()
+}
 
-		response := []struct {
-			SHA    string `json:"sha"`
-			URL    string `json:"url"`
-			Commit struct {
-				Message string `json:"message"`
-				Author  struct {
-					AuthorName  string    `json:"name"`
-					AuthorEmail string    `json:"email"`
-					Date        time.Time `json:"date"`
-				} `json:"author"`
-			} `json:"commit"`
-		}{}
-
-		LogApp("Response : " + string(body))
-
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			LogError(fmt.Errorf("error parsing commits : %v", err))
-			return nil, err
-		}
+func (s *sqlStore) GetLastCommit(repo_id int) (*Commit, error) {
+	c, err := scanCommit(s.db.QueryRow("SELECT "+commitColumns+" FROM commits WHERE repository_id=$1 ORDER BY date DESC LIMIT 1", repo_id))
+	if err != nil {
+		LogError(fmt.Errorf("error scanning commit result : %v", err))
+		return nil, err
+	}
 
-		for _, c := range response {
-			commits = append(commits, Commit{
-				SHA:          c.SHA,
-				Message:      c.Commit.Message,
-				URL:          c.URL,
-				AuthorName:   c.Commit.Author.AuthorName,
-				AuthorEmail:  c.Commit.Author.AuthorEmail,
-				Date:         c.Commit.Author.Date,
-				RepositoryID: repo.ID,
-			})
-		}
+	return c, nil
+}
 
-		// save commits
-		for _, commit := range commits {
-			commit.RepositoryID = repo.ID
-			err = commit.Save()
-			if err != nil {
-				LogError(fmt.Errorf("error saving commits : %v", err))
-			}
-		}
+// GetCommit returns the stored commit of the repository with that sha, sql.ErrNoRows if there's none
+func (s *sqlStore) GetCommit(repo_id int, sha string) (*Commit, error) {
+	c, err := scanCommit(s.db.QueryRow("SELECT "+commitColumns+" FROM commits WHERE repository_id=$1 AND sha=$2", repo_id, sha))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		LogError(fmt.Errorf("error scanning commit result : %v", err))
 	}
+	return c, err
+}
+
+// CommitFilter narrows down the commits returned by ListCommits.
+// Results are ordered newest first, After continues a listing from the cursor of its last commit.
+// Branch keeps the commits reachable from that branch, all stored commits are listed if it's empty.
+// Author matches the name or email of the commit, or any login, email or name of its contributor.
+// Merges lists only the merge commits with "only", or leaves them out with "exclude".
+// Message is a regular expression the message has to match, (?i) at its start ignores the case.
+type CommitFilter struct {
+	Since   *time.Time
+	Until   *time.Time
+	Author  string
+	Branch  string
+	Merges  string
+	Message string
+	After   *CommitCursor
+	Limit   int
+}
+
+// values of CommitFilter.Merges, every commit is listed when it's empty
+const (
+	MergesOnly    = "only"
+	MergesExclude = "exclude"
+)
 
-	cwg.Done()
+// mergeCondition matches the commits with several parents
+const mergeCondition = "parents LIKE '% %'"
 
-	return commits, nil
+// CommitCursor is the position of a commit in a listing ordered by date then sha
+type CommitCursor struct {
+	Date time.Time
+	SHA  string
 }
 
-func GetCommits(repo_id int) ([]Commit, error) {
-	db, err := SQLConnect()
+// ListCommits returns the commits of the repository matching the filter
+func (s *sqlStore) ListCommits(repo_id int, f CommitFilter) ([]Commit, error) {
+	q := newQuery("SELECT "+commitColumns+" FROM commits WHERE repository_id=$1", repo_id)
+	if f.Since != nil {
+		q.where("date >= $%d", f.Since.UTC())
+	}
+	if f.Until != nil {
+		q.where("date <= $%d", f.Until.UTC())
+	}
+	if f.Author != "" {
+		q.where(`(LOWER(author_name) = LOWER($%[1]d) OR LOWER(author_email) = LOWER($%[1]d)
+			OR contributor_id IN (SELECT contributor_id FROM contributor_aliases WHERE value = LOWER($%[1]d)))`, f.Author)
+	}
+	if f.Branch != "" {
+		q.where("sha IN (SELECT sha FROM commit_branches WHERE repository_id=$1 AND branch=$%d)", f.Branch)
+	}
+	if f.Message != "" {
+		q.where(s.regexpCondition("message", "$%d"), f.Message)
+	}
+	switch f.Merges {
+	case MergesOnly:
+		q.sql += " AND " + mergeCondition
+	case MergesExclude:
+		q.sql += " AND NOT " + mergeCondition
+	}
+	if f.After != nil {
+		q.where("(date < $%[1]d OR (date = $%[1]d AND sha < $%[2]d))", f.After.Date.UTC(), f.After.SHA)
+	}
+	q.sql += " ORDER BY date DESC, sha DESC"
+	if f.Limit > 0 {
+		q.sql += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.Query(q.sql, q.args...)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
+		LogError(fmt.Errorf("error listing commits : %v", err))
 		return nil, err
 	}
+	defer rows.Close()
 
 	commits := []Commit{}
-	rows, err := db.Query("SELECT sha, message, url, author_name, author_email, date, repository_id FROM commits WHERE repository_id=$1", repo_id)
 	for rows.Next() {
-		c := Commit{}
-		err = rows.Scan(&c.SHA, &c.Message, &c.URL, &c.AuthorName,
-			&c.AuthorEmail, &c.Date, &c.RepositoryID)
+		c, err := scanCommit(rows)
 		if err != nil {
 			LogError(fmt.Errorf("error scanning commit result : %v", err))
 			return nil, err
 		}
 
-		commits = append(commits, c)
+		commits = append(commits, *c)
 	}
 
-	return commits, nil
+	return commits, rows.Err()
 }
 
-func GetLastCommit(repo_id int) (*Commit, error) {
-	db, err := SQLConnect()
+func scanCommit(row scanner) (*Commit, error) {
+	c := new(Commit)
+	var parents string
+	err := row.Scan(&c.SHA, &c.Message, &c.URL, &c.AuthorName, &c.AuthorEmail, &c.AuthorLogin, &c.Date,
+		&c.CommitterName, &c.CommitterEmail, &c.CommitterLogin, &c.Committed, &parents, &c.Verified, &c.SignatureReason,
+		&c.RepositoryID, &c.ContributorID)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
 		return nil, err
 	}
+	c.Parents = strings.Fields(parents)
 
-	c := Commit{}
-	row := db.QueryRow("SELECT sha, message, url, author_name, author_email, date, repository_id FROM commits WHERE repository_id=$1 ORDER BY date DESC LIMIT 1", repo_id)
-	err = row.Scan(&c.SHA, &c.Message, &c.URL, &c.AuthorName,
-		&c.AuthorEmail, &c.Date, &c.RepositoryID)
+	return c, nil
+}
+
+// CommitStats counts the kinds of commits of a repository.
+// Rebased commits were committed at another time than they were authored, rebased, amended or cherry-picked.
+type CommitStats struct {
+	Commits       int     `json:"commits"`
+	Merges        int     `json:"merges"`
+	Rebased       int     `json:"rebased"`
+	Signed        int     `json:"signed"`
+	Verified      int     `json:"verified"`
+	SignedShare   float64 `json:"signed_share"`
+	VerifiedShare float64 `json:"verified_share"`
+}
+
+// GetCommitStats counts the merge, rebased, signed and verified commits of the repository,
+// only those reachable from branch if it's given
+func (s *sqlStore) GetCommitStats(repo_id int, branch string) (*CommitStats, error) {
+	q := newQuery(`SELECT count(*),
+		COALESCE(sum(CASE WHEN `+mergeCondition+` THEN 1 ELSE 0 END), 0),
+		COALESCE(sum(CASE WHEN committed_at <> date THEN 1 ELSE 0 END), 0),
+		COALESCE(sum(CASE WHEN signature_reason NOT IN ('', 'unsigned') THEN 1 ELSE 0 END), 0),
+		COALESCE(sum(CASE WHEN verified THEN 1 ELSE 0 END), 0)
+		FROM commits WHERE repository_id=$1`, repo_id)
+	if branch != "" {
+		q.where("sha IN (SELECT sha FROM commit_branches WHERE repository_id=$1 AND branch=$%d)", branch)
+	}
+
+	stats := new(CommitStats)
+	err := s.db.QueryRow(q.sql, q.args...).Scan(&stats.Commits, &stats.Merges, &stats.Rebased, &stats.Signed, &stats.Verified)
 	if err != nil {
-		LogError(fmt.Errorf("error scanning commit result : %v", err))
+		LogError(fmt.Errorf("error counting commits : %v", err))
 		return nil, err
 	}
 
-	return &c, nil
+	if stats.Commits > 0 {
+		stats.SignedShare = float64(stats.Signed) / float64(stats.Commits)
+		stats.VerifiedShare = float64(stats.Verified) / float64(stats.Commits)
+	}
+	return stats, nil
 }
 
+// Author is a contributor along with how many commits they made
 type Author struct {
-	AuthorName  string `db:"name"`
-	AuthorEmail string `db:"email"`
-	Commits     int    `db:"commits"`
+	ContributorID int    `json:"contributor_id" db:"contributor_id"`
+	AuthorName    string `json:"name" db:"name"`
+	AuthorEmail   string `json:"email" db:"email"`
+	Login         string `json:"login" db:"login"`
+	Commits       int    `json:"commits" db:"commits"`
 }
 
-func GetTopAuthors(repo_id, n int) ([]Author, error) {
-	db, err := SQLConnect()
-	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
-		return nil, err
+// GetTopAuthors returns the n contributors with the most commits, counting only the commits reachable from branch if it's given
+func (s *sqlStore) GetTopAuthors(repo_id, n int, branch string) ([]Author, error) {
+	q := newQuery(`SELECT p.id, p.name, p.email, p.login, count(c.sha) AS commits
+		FROM commits c JOIN contributors p ON p.id = c.contributor_id WHERE c.repository_id=$1`, repo_id)
+	if branch != "" {
+		q.where("c.sha IN (SELECT sha FROM commit_branches WHERE repository_id=$1 AND branch=$%d)", branch)
 	}
-
-	authors := []Author{}
-	qry := `SELECT author_name, author_email, count(sha) AS commits 
-		FROM commits 
-		WHERE repository_id=$1 
-		GROUP BY author_name, author_email
-		ORDER BY commits DESC
-	`
+	q.sql += " GROUP BY p.id, p.name, p.email, p.login ORDER BY commits DESC, p.id"
 	if n > 0 {
 		// top n authors
-		qry = fmt.Sprintf("%s LIMIT %d", qry, n)
+		q.sql = fmt.Sprintf("%s LIMIT %d", q.sql, n)
 	}
 
-	rows, err := db.Query(qry, repo_id)
+	rows, err := s.db.Query(q.sql, q.args...)
 	if err != nil {
 		LogError(fmt.Errorf("error getting top authors from db : %v", err))
 		return nil, err
 	}
+	defer rows.Close()
+
+	authors := []Author{}
 	for rows.Next() {
 		a := Author{}
-		err = rows.Scan(&a.AuthorName, &a.AuthorEmail, &a.Commits)
+		err = rows.Scan(&a.ContributorID, &a.AuthorName, &a.AuthorEmail, &a.Login, &a.Commits)
 		if err != nil {
 			LogError(fmt.Errorf("error scanning authors result : %v", err))
 			return nil, err
@@ -375,47 +464,27 @@ func GetTopAuthors(repo_id, n int) ([]Author, error) {
 		authors = append(authors, a)
 	}
 
-	return authors, nil
+	return authors, rows.Err()
 }
 
-func DeleteCommitByRepoID(repo_id int) error {
-	db, err := SQLConnect()
+func (s *sqlStore) DeleteCommitByRepoID(repo_id int) error {
+	_, err := s.db.Exec("DELETE FROM commit_branches WHERE repository_id=$1", repo_id)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
+		LogError(fmt.Errorf("error deleting commit branches : %v", err))
 		return err
 	}
 
-	_, err = db.Exec("DELETE FROM commits WHERE repository_id=$1", repo_id)
+	_, err = s.db.Exec("DELETE FROM commit_files WHERE repository_id=$1", repo_id)
 	if err != nil {
-		LogError(fmt.Errorf("error deleting commits : %v", err))
+		LogError(fmt.Errorf("error deleting commit files : %v", err))
 		return err
 	}
 
-	return nil
-}
-
-// init function to create the commits table if it doesn't exist already
-func init() {
-	// make sure commits table exists
-	db, err := SQLConnect()
+	_, err = s.db.Exec("DELETE FROM commits WHERE repository_id=$1", repo_id)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
-		return
-	}
-
-	create := `CREATE TABLE IF NOT EXISTS commits (
-		sha varchar(255) PRIMARY KEY,
-		message text,
-		url varchar(255) UNIQUE,
-		author_name varchar(255),
-		author_email varchar(255),
-		repository_id INTEGER,
-		date timestamp,
-		FOREIGN KEY (repository_id) REFERENCES repositories(id)
-	)`
-
-	_, err = db.Exec(create)
-	if err != nil {
-		LogError(fmt.Errorf("error creating commits table : %v", err))
+		LogError(fmt.Errorf("error deleting commits : %v", err))
+		return err
 	}
+
+	return nil
 }
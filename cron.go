@@ -1,33 +1,222 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 )
 
-func RefreshRepos() {
-	repos, err := GetRepos()
+// RefreshSummary describes the outcome of a refresh cycle
+type RefreshSummary struct {
+	Repos   int `json:"repos"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	RefreshCounts
+	Failures []RefreshFailure `json:"failures,omitempty"`
+	Started  time.Time        `json:"started_at"`
+	Duration time.Duration    `json:"duration"`
+}
+
+// RefreshCounts counts what a refresh brought in
+type RefreshCounts struct {
+	CommitsAdded        int `json:"commits_added"`
+	PullRequestsUpdated int `json:"pull_requests_updated"`
+	IssuesUpdated       int `json:"issues_updated"`
+	ReleasesAdded       int `json:"releases_added"`
+	DeploymentsUpdated  int `json:"deployments_updated"`
+	RunsUpdated         int `json:"runs_updated"`
+	CommitFilesFetched  int `json:"commit_files_fetched"`
+}
+
+func (c *RefreshCounts) add(o RefreshCounts) {
+	c.CommitsAdded += o.CommitsAdded
+	c.PullRequestsUpdated += o.PullRequestsUpdated
+	c.IssuesUpdated += o.IssuesUpdated
+	c.ReleasesAdded += o.ReleasesAdded
+	c.DeploymentsUpdated += o.DeploymentsUpdated
+	c.RunsUpdated += o.RunsUpdated
+	c.CommitFilesFetched += o.CommitFilesFetched
+}
+
+// RefreshFailure is a repository that couldn't be refreshed
+type RefreshFailure struct {
+	Repository string `json:"repository"`
+	Error      string `json:"error"`
+}
+
+func (s *RefreshSummary) String() string {
+	return fmt.Sprintf("%d/%d repositories updated, %d commits added, %d pull requests and %d issues updated, %d releases added, %d deployments and %d workflow runs updated, files of %d commits fetched, %d failed, %d skipped in %v",
+		s.Updated, s.Repos, s.CommitsAdded, s.PullRequestsUpdated, s.IssuesUpdated, s.ReleasesAdded, s.DeploymentsUpdated, s.RunsUpdated, s.CommitFilesFetched, s.Failed, s.Skipped, s.Duration.Round(time.Millisecond))
+}
+
+// refreshWorkers reads the number of repositories refreshed at once from REFRESH_WORKERS, 4 by default
+func refreshWorkers() int {
+	workers := os.Getenv("REFRESH_WORKERS")
+	i := 4
+	if workers != "" {
+		val, err := strconv.Atoi(workers)
+		if err != nil || val < 1 {
+			LogError(fmt.Errorf("error parsing REFRESH_WORKERS env variable : %v", workers))
+			return i
+		}
+		i = val
+	}
+	return i
+}
+
+// RefreshRepos refreshes the metadata and commits of every tracked repository, with the given number
+// of workers sharing the rate limit budget of the client. Repositories already being synced are skipped.
+// Once ctx is done the workers stop before their next repository.
+func RefreshRepos(ctx context.Context, workers int) *RefreshSummary {
+	summary := &RefreshSummary{Started: time.Now()}
+
+	repos, err := store.GetRepos()
 	if err != nil {
 		// error loading repos to pull changes
 		LogError(fmt.Errorf("error fetching repos from the db : %s", err))
+		return summary
 	}
+	summary.Repos = len(repos)
 
-	for _, r := range repos {
-		// refresh repo meta data first
-		_, err := FetchRepo(r.URL)
-		if err != nil {
-			LogError(fmt.Errorf("error fetching repo metadata : %v", err))
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan Repository)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range queue {
+				counts, skipped, err := refreshRepo(r)
+
+				mu.Lock()
+				switch {
+				case skipped:
+					summary.Skipped++
+				case err != nil:
+					summary.Failed++
+					summary.Failures = append(summary.Failures, RefreshFailure{r.Name, err.Error()})
+				default:
+					summary.Updated++
+				}
+				summary.add(counts)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	left := 0
+dispatch:
+	for i, r := range repos {
+		if ctx.Err() != nil {
+			left = len(repos) - i
+			break
 		}
+		select {
+		case queue <- r:
+		case <-ctx.Done():
+			left = len(repos) - i
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if left > 0 {
+		LogApp(fmt.Sprintf("refresh stopped, %d repositories left", left))
+	}
+
+	summary.Duration = time.Since(summary.Started)
+	LogApp("refresh summary : " + summary.String())
+	return summary
+}
 
-		lastCommit, err := GetLastCommit(r.ID)
+// refreshRepo refreshes a repository unless it's already being synced, and counts what it brought in.
+// A failing step doesn't stop the following ones, their errors are joined.
+func refreshRepo(r Repository) (counts RefreshCounts, skipped bool, err error) {
+	unlock, ok := syncLocks.tryLock(r.ID)
+	if !ok {
+		LogApp(fmt.Sprintf("%s is already being synced, skipping it", r.Name))
+		return counts, true, nil
+	}
+	defer unlock()
+
+	errs := []error{}
+
+	// refresh repo meta data and branches first
+	fetched, err := FetchRepo(r.URL)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching repo metadata : %v", err))
+		errs = append(errs, err)
+	} else {
+		fetched.ID = r.ID
+		r = *fetched
+
+		// keep the history of the counters the metadata overwrites
+		err = store.SaveSnapshot(snapshotOf(&r, time.Now()))
 		if err != nil {
-			LogError(fmt.Errorf("error fetching last commit from db : %v", err))
+			errs = append(errs, err)
 		}
 
-		// pull commits
-		_, err = FetchCommitsNoOverride(r.URL, &lastCommit.Date)
+		_, err = FetchBranches(&r)
 		if err != nil {
-			LogError(fmt.Errorf("error fetching new commits : %v", err))
+			LogError(fmt.Errorf("error fetching branches : %v", err))
+			errs = append(errs, err)
 		}
 	}
 
+	// merge new commits, and resume the backfill if the last sync was interrupted
+	result, err := syncCommits(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching new commits : %v", err))
+		errs = append(errs, err)
+	}
+	counts.CommitsAdded = result.Added
+
+	counts.PullRequestsUpdated, err = syncPullRequests(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching pull requests : %v", err))
+		errs = append(errs, err)
+	}
+
+	counts.IssuesUpdated, err = syncIssues(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching issues : %v", err))
+		errs = append(errs, err)
+	}
+
+	counts.ReleasesAdded, err = syncReleases(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching releases : %v", err))
+		errs = append(errs, err)
+	}
+
+	counts.DeploymentsUpdated, err = syncDeployments(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching deployments : %v", err))
+		errs = append(errs, err)
+	}
+
+	counts.RunsUpdated, err = syncWorkflowRuns(&r)
+	if err != nil {
+		LogError(fmt.Errorf("error fetching workflow runs : %v", err))
+		errs = append(errs, err)
+	}
+
+	// fetch the changed files of a batch of commits, older ones are caught up over the next refreshes
+	counts.CommitFilesFetched, err = syncCommitFiles(&r, commitFilesBatch())
+	if err != nil {
+		LogError(fmt.Errorf("error fetching commit files : %v", err))
+		errs = append(errs, err)
+	}
+
+	return counts, false, errors.Join(errs...)
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jasonlvhit/gocron"
+)
+
+// daemon states reported by the readiness endpoint
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateDraining
+)
+
+var stateNames = map[int32]string{
+	stateStarting: "starting",
+	stateReady:    "ready",
+	stateDraining: "draining",
+}
+
+// Daemon runs the refresh scheduler headless until it's told to stop
+type Daemon struct {
+	state atomic.Int32
+
+	// stop tells running refreshes to finish the repository they're on and return
+	stop       context.Context
+	cancelStop context.CancelFunc
+
+	// running guards against overlapping refreshes. inflight tracks the refresh and the api writes in progress,
+	// draining is set by Shutdown under mu so nothing joins inflight once it's waiting for it
+	running  sync.Mutex
+	inflight sync.WaitGroup
+	mu       sync.Mutex
+	draining bool
+
+	lastRefresh atomic.Pointer[time.Time]
+	lastSummary atomic.Pointer[RefreshSummary]
+
+	// workers is the number of repositories refreshed at once
+	workers int
+}
+
+// NewDaemon creates a daemon in the starting state
+func NewDaemon() *Daemon {
+	d := new(Daemon)
+	d.stop, d.cancelStop = context.WithCancel(context.Background())
+	return d
+}
+
+// begin adds some work to the ones Shutdown waits for, it returns false once the daemon is stopping
+func (d *Daemon) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight.Add(1)
+	return true
+}
+
+// refresh runs a refresh cycle unless one is already in progress or the daemon is stopping
+func (d *Daemon) refresh() {
+	if !d.running.TryLock() {
+		LogApp("previous refresh still running, skipping this one")
+		return
+	}
+	defer d.running.Unlock()
+
+	if !d.begin() {
+		return
+	}
+	defer d.inflight.Done()
+
+	LogApp("refresh started")
+	summary := RefreshRepos(d.stop, d.workers)
+	now := time.Now()
+	d.lastRefresh.Store(&now)
+	d.lastSummary.Store(summary)
+	LogApp("refresh finished")
+}
+
+// Shutdown stops new refreshes and waits for the one in progress to checkpoint, at most timeout.
+// Past the timeout in-flight requests are aborted.
+func (d *Daemon) Shutdown(timeout time.Duration, abort context.CancelFunc) {
+	d.state.Store(stateDraining)
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+	d.cancelStop()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		LogApp("in-flight refresh finished")
+	case <-time.After(timeout):
+		LogApp("shutdown timeout reached, aborting in-flight requests")
+		abort()
+		<-done
+	}
+}
+
+// track makes Shutdown wait for the api requests that write, like pulls, and refuses new ones once it's draining
+func (d *Daemon) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !d.begin() {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("shutting down"))
+			return
+		}
+		defer d.inflight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthz reports the process is alive
+func (d *Daemon) healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether the daemon is ready, which needs a reachable database and no shutdown in progress
+func (d *Daemon) readyz(w http.ResponseWriter, r *http.Request) {
+	state := d.state.Load()
+	status := http.StatusOK
+
+	dbErr := ""
+	if err := store.Ping(); err != nil {
+		dbErr = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	if state != stateReady {
+		status = http.StatusServiceUnavailable
+	}
+
+	response := struct {
+		State       string          `json:"state"`
+		Database    string          `json:"database,omitempty"`
+		LastRefresh *time.Time      `json:"last_refresh"`
+		LastSummary *RefreshSummary `json:"last_summary,omitempty"`
+	}{stateNames[state], dbErr, d.lastRefresh.Load(), d.lastSummary.Load()}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// refreshInterval reads the number of hours between refreshes from INTERVAL, 1 by default
+func refreshInterval() uint64 {
+	interval := os.Getenv("INTERVAL")
+	i := uint64(1)
+	if interval != "" {
+		val, err := strconv.Atoi(interval)
+		if err != nil || val < 1 {
+			LogError(fmt.Errorf("error parsing INTERVAL env variable : %v", interval))
+			return i
+		}
+		i = uint64(val)
+	}
+	return i
+}
+
+// cmdDaemon runs the refresh scheduler without the ui until SIGINT or SIGTERM
+func cmdDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	addr := fs.String("addr", os.Getenv("DAEMON_ADDR"), "address to serve /healthz and /readyz on, e.g. :8080")
+	timeout := fs.Duration("shutdown-timeout", 30*time.Second, "how long to wait for an in-flight refresh on shutdown")
+	onStart := fs.Bool("refresh-on-start", true, "refresh right away instead of waiting for the first interval")
+	api := fs.Bool("api", false, "also serve the rest api on --addr")
+	workers := fs.Int("workers", refreshWorkers(), "number of repositories refreshed at once")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	github.OnWait = nil
+
+	// aborting this context cancels in-flight requests and rate limit waits
+	fetchCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	github.Context = fetchCtx
+
+	d := NewDaemon()
+	d.workers = *workers
+
+	var server *http.Server
+	if *addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", d.healthz)
+		mux.HandleFunc("/readyz", d.readyz)
+		if *api {
+			apiMux := http.NewServeMux()
+			registerAPI(apiMux)
+			mux.Handle("/", d.track(apiMux))
+		}
+		server = &http.Server{Addr: *addr, Handler: mux}
+
+		go func() {
+			LogApp(fmt.Sprintf("serving on %s", *addr))
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				LogError(fmt.Errorf("error serving on %s : %v", *addr, err))
+			}
+		}()
+	}
+
+	interval := refreshInterval()
+	scheduler := gocron.NewScheduler()
+	scheduler.Every(interval).Hours().Do(d.refresh)
+	stopScheduler := scheduler.Start()
+	LogApp(fmt.Sprintf("daemon started, refreshing every %d hour(s)", interval))
+
+	if *onStart {
+		go d.refresh()
+	}
+	d.state.Store(stateReady)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	LogApp(fmt.Sprintf("received %v, shutting down", sig))
+
+	// a second signal skips the graceful part
+	go func() {
+		<-signals
+		LogApp("received second signal, exiting now")
+		os.Exit(1)
+	}()
+
+	close(stopScheduler)
+	d.Shutdown(*timeout, abort)
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+
+	LogApp("daemon stopped")
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDaemonShutdownWaitsForWrites(t *testing.T) {
+	d := NewDaemon()
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := d.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/repos/1/pull", nil))
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Shutdown(time.Minute, func() {})
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned while a pull was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// new writes are refused while draining, reads still go through
+	for _, tt := range []struct {
+		method string
+		want   int
+	}{
+		{"POST", http.StatusServiceUnavailable},
+		{"GET", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		d.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest(tt.method, "/repos", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s while draining = %d, want %d", tt.method, w.Code, tt.want)
+		}
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't return once the pull finished")
+	}
+
+	// the scheduler can still fire after shutdown, the refresh must not start
+	d.refresh()
+	if d.lastRefresh.Load() != nil {
+		t.Error("refresh ran after shutdown")
+	}
+}
+
+func TestDaemonShutdownTimeout(t *testing.T) {
+	d := NewDaemon()
+	ctx, abort := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	handler := d.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-ctx.Done()
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/repos/1/pull", nil))
+	<-started
+
+	d.Shutdown(10*time.Millisecond, abort)
+	if ctx.Err() == nil {
+		t.Error("in-flight requests weren't aborted past the timeout")
+	}
+}
@@ -4,34 +4,169 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
-
-	_ "github.com/lib/pq"
+	"regexp"
+	"time"
 )
 
-var db *sql.DB
+// Store persists the data collected from github
+type Store interface {
+	SaveRepo(r *Repository) error
+	GetRepos() ([]Repository, error)
+	ListRepos(f RepoFilter) ([]Repository, error)
+	GetRepoByID(id int) (*Repository, error)
+	GetRepoByURL(repo_url string) (*Repository, error)
+	DeleteRepo(id int) error
+
+	SaveCommit(c *Commit) error
+	GetCommits(repo_id int) ([]Commit, error)
+	ListCommits(repo_id int, f CommitFilter) ([]Commit, error)
+	CheckRegexp(pattern string) error
+	GetCommit(repo_id int, sha string) (*Commit, error)
+	GetLastCommit(repo_id int) (*Commit, error)
+	DeleteCommitByRepoID(repo_id int) error
+	GetTopAuthors(repo_id, n int, branch string) ([]Author, error)
+
+	SaveBranches(repo_id int, branches []Branch) error
+	GetBranches(repo_id int) ([]Branch, error)
+	TrackBranch(repo_id int, name string, tracked bool) error
+
+	GetSyncCursor(repo_id int, branch string) (*SyncCursor, error)
+	SaveCommits(branch string, commits []Commit, cursor *SyncCursor) (int, error)
+	ReplaceCommits(repo_id int, histories []BranchCommits) (int, error)
+
+	GetResourceCursor(repo_id int, resource string) (time.Time, error)
+	SaveResourceCursor(repo_id int, resource string, until time.Time) error
+
+	SavePullRequest(pr *PullRequest) error
+	ListPullRequests(repo_id int, f PullRequestFilter) ([]PullRequest, error)
+
+	SaveIssues(repo_id int, issues []Issue, syncedUntil time.Time) error
+	ListIssues(repo_id int, f IssueFilter) ([]Issue, error)
+	CountIssues(repo_id int, f IssueFilter) (int, error)
+	SaveTags(repo_id int, tags []Tag) error
+	GetTags(repo_id int) ([]Tag, error)
+	SaveRelease(r *Release) error
+	SaveReleaseCommits(repo_id int, tag string, commits []Commit) error
+	GetReleases(repo_id int) ([]Release, error)
+	GetReleaseCommits(repo_id int, tag string) ([]Commit, error)
+	SaveSnapshot(snap RepoSnapshot) error
+	GetSnapshots(repo_id int, since time.Time) ([]RepoSnapshot, error)
+	GetTrend(repo_id int, window string) (*RepoTrend, error)
+	GetContributors() ([]Contributor, error)
+	GetCommitStats(repo_id int, branch string) (*CommitStats, error)
+	MergeContributors(from, into int) error
+	ApplyMailmap(entries []MailmapEntry) (int, error)
+	GetCommitsWithoutFiles(repo_id, limit int) ([]Commit, error)
+	SaveCommitFiles(repo_id int, sha string, files []CommitFile) error
+	GetFileChanges(repo_id int, since *time.Time) ([]FileChange, error)
+	GetAuthorChurn(repo_id int, since *time.Time) ([]AuthorChurn, error)
+	GetAuthoredCommits(repo_id int, branch string) ([]AuthoredCommit, error)
+
+	SaveDeployment(d *Deployment) error
+	SaveDeploymentCommits(repo_id int, id int64, previousSHA string, commits []Commit) error
+	GetDeployments(repo_id int) ([]Deployment, error)
+	GetDeployedCommits(repo_id int, environment string) ([]ShippedCommit, error)
+	GetReleasedCommits(repo_id int) ([]ShippedCommit, error)
+	GetTaggedCommits(repo_id int) ([]ShippedCommit, error)
+
+	SaveWorkflows(repo_id int, workflows []Workflow) error
+	GetWorkflows(repo_id int) ([]Workflow, error)
+	SaveWorkflowRun(r *WorkflowRun) error
+	GetWorkflowRuns(repo_id int, f WorkflowRunFilter) ([]WorkflowRun, error)
+
+	SaveCacheEntry(e *CacheEntry) error
+	GetCacheEntry(URL string) (*CacheEntry, error)
 
-func SQLConnect() (*sql.DB, error) {
-	if db == nil {
-		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	SchemaVersion() (int, error)
+	MigrationStatus() ([]MigrationState, error)
+	MigrateUp() ([]Migration, error)
+	MigrateDown(n int) ([]Migration, error)
+
+	Ping() error
+	Close() error
+}
+
+// store is the storage backend used by the app, opened on startup
+var store Store
+
+// OpenStore opens the storage backend selected by DB_DRIVER, postgres by default
+func OpenStore() (Store, error) {
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "postgres":
+		return NewPostgresStore(fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 			os.Getenv("DB_HOST"),
 			os.Getenv("DB_PORT"),
 			os.Getenv("DB_USERNAME"),
 			os.Getenv("DB_PASSWORD"),
 			os.Getenv("DB_NAME"),
-		)
-
-		var err error
-		db, err = sql.Open("postgres", dsn)
-		if err != nil {
-			LogError(fmt.Errorf("error connecting to db : %v", err))
-			return nil, err
+		))
+	case "sqlite":
+		path := os.Getenv("DB_PATH")
+		if path == "" {
+			path = "github-api.db"
 		}
+		return NewSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q, use postgres or sqlite", driver)
+	}
+}
 
-		err = db.Ping()
-		if err != nil {
-			LogError(fmt.Errorf("error pinging db : %v", err))
-			return nil, err
-		}
+// sqlStore implements Store on top of database/sql. The queries are shared by the postgres and
+// sqlite backends, which differ only in how they connect and in some migrations.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (s *sqlStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// query builds a statement with numbered placeholders from optional conditions
+type query struct {
+	sql  string
+	args []interface{}
+}
+
+func newQuery(sql string, args ...interface{}) *query {
+	return &query{sql: sql, args: args}
+}
+
+// where appends a condition, its placeholders are written as $%d (or $%[n]d) and numbered after the existing args
+func (q *query) where(cond string, args ...interface{}) {
+	nums := make([]interface{}, len(args))
+	for i := range args {
+		nums[i] = len(q.args) + i + 1
 	}
-	return db, nil
+
+	q.sql += " AND " + fmt.Sprintf(cond, nums...)
+	q.args = append(q.args, args...)
+}
+
+// regexpCondition matches column against a regular expression, placeholder is where it goes in the query.
+// Postgres has the ~ operator, sqlite the REGEXP one backed by the go regexp package.
+func (s *sqlStore) regexpCondition(column, placeholder string) string {
+	if s.dialect == "sqlite" {
+		return column + " REGEXP " + placeholder
+	}
+	return column + " ~ " + placeholder
+}
+
+// CheckRegexp tells whether the database can match against pattern. The syntax is the database's, postgres
+// regular expressions differ from the go ones sqlite uses, \pL only works on sqlite and [[:<:]] only on postgres.
+func (s *sqlStore) CheckRegexp(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if s.dialect == "sqlite" {
+		_, err := regexp.Compile(pattern)
+		return err
+	}
+
+	var matched bool
+	return s.db.QueryRow("SELECT '' ~ $1", pattern).Scan(&matched)
 }
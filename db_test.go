@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// keep the tests from writing log files next to the sources
+	LogToStdout()
+	os.Exit(m.Run())
+}
+
+// newTestStore opens a migrated sqlite store in a temporary directory and makes it the global store
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	_, err = s.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := store
+	store = s
+	t.Cleanup(func() { store = previous })
+	return s
+}
+
+// newTestRepo stores a repository and returns it with its id
+func newTestRepo(t *testing.T, s Store, name string) *Repository {
+	t.Helper()
+	r := &Repository{Name: name, URL: "https://api.github.com/repos/org/" + name, DefaultBranch: "main"}
+	err := s.SaveRepo(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.GetRepoByURL(r.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testCommit makes a commit of the repository made the given number of hours after 2024-01-01
+func testCommit(repo_id int, sha string, hours int) Commit {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(hours) * time.Hour)
+	return Commit{
+		SHA:          sha,
+		Message:      "commit " + sha,
+		URL:          "https://github.com/org/repo/commit/" + sha,
+		AuthorName:   "Jane Doe",
+		AuthorEmail:  "jane@example.com",
+		Date:         date,
+		Committed:    date,
+		RepositoryID: repo_id,
+	}
+}
+
+func shas(commits []Commit) string {
+	s := ""
+	for _, c := range commits {
+		s += c.SHA
+	}
+	return s
+}
+
+func TestMigrations(t *testing.T) {
+	s := newTestStore(t)
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Fatalf("version after up = %d, want %d", version, LatestSchemaVersion())
+	}
+
+	reverted, err := s.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(migrations))
+	}
+	version, err = s.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("version after down = %d, want 0", version)
+	}
+
+	applied, err := s.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	// the schema works again
+	repo := newTestRepo(t, s, "repo")
+	_, err = s.SaveCommits("main", []Commit{testCommit(repo.ID, "a", 0)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveCommits(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	tests := []struct {
+		name      string
+		branch    string
+		commits   []Commit
+		wantAdded int
+		wantAll   string
+		wantMain  string
+	}{
+		{"new commits", "main", []Commit{testCommit(repo.ID, "b", 1), testCommit(repo.ID, "a", 0)}, 2, "ba", "ba"},
+		{"already stored", "main", []Commit{testCommit(repo.ID, "b", 1)}, 0, "ba", "ba"},
+		{"another branch", "feature", []Commit{testCommit(repo.ID, "c", 2), testCommit(repo.ID, "b", 1)}, 1, "cba", "ba"},
+		{"no branch", "", []Commit{testCommit(repo.ID, "d", 3)}, 1, "dcba", "ba"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, err := s.SaveCommits(tt.branch, tt.commits, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != tt.wantAdded {
+				t.Errorf("added = %d, want %d", added, tt.wantAdded)
+			}
+
+			all, err := s.ListCommits(repo.ID, CommitFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shas(all) != tt.wantAll {
+				t.Errorf("all commits = %q, want %q", shas(all), tt.wantAll)
+			}
+			main, err := s.ListCommits(repo.ID, CommitFilter{Branch: "main"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shas(main) != tt.wantMain {
+				t.Errorf("main commits = %q, want %q", shas(main), tt.wantMain)
+			}
+		})
+	}
+
+	// the cursor is saved along with the commits
+	cursor := &SyncCursor{RepositoryID: repo.ID, NewestSHA: "e", NewestDate: testCommit(repo.ID, "e", 4).Date, Updated: time.Now()}
+	_, err := s.SaveCommits("main", []Commit{testCommit(repo.ID, "e", 4)}, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := s.GetSyncCursor(repo.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil || saved.NewestSHA != "e" || !saved.NewestDate.Equal(cursor.NewestDate) {
+		t.Errorf("cursor = %+v, want newest e at %v", saved, cursor.NewestDate)
+	}
+}
+
+func TestReplaceCommits(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+	other := newTestRepo(t, s, "other")
+
+	_, err := s.SaveCommits("main", []Commit{testCommit(repo.ID, "a", 0), testCommit(repo.ID, "b", 1)},
+		&SyncCursor{RepositoryID: repo.ID, NewestSHA: "b", BackfillURL: "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SaveCommits("main", []Commit{testCommit(other.ID, "x", 0)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := s.ReplaceCommits(repo.ID, []BranchCommits{
+		{Branch: "main", Commits: []Commit{testCommit(repo.ID, "c", 2), testCommit(repo.ID, "a", 0)},
+			Cursor: &SyncCursor{RepositoryID: repo.ID, NewestSHA: "c"}},
+		{Branch: "feature", Commits: []Commit{testCommit(repo.ID, "d", 3), testCommit(repo.ID, "a", 0)},
+			Cursor: &SyncCursor{RepositoryID: repo.ID, Branch: "feature", NewestSHA: "d"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Errorf("added = %d, want 3", added)
+	}
+
+	tests := []struct {
+		repo_id int
+		branch  string
+		want    string
+	}{
+		{repo.ID, "", "dca"},
+		{repo.ID, "main", "ca"},
+		{repo.ID, "feature", "da"},
+		{other.ID, "", "x"},
+	}
+	for _, tt := range tests {
+		commits, err := s.ListCommits(tt.repo_id, CommitFilter{Branch: tt.branch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shas(commits) != tt.want {
+			t.Errorf("commits of repo %d branch %q = %q, want %q", tt.repo_id, tt.branch, shas(commits), tt.want)
+		}
+	}
+
+	cursor, err := s.GetSyncCursor(repo.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == nil || cursor.NewestSHA != "c" || cursor.BackfillURL != "" {
+		t.Errorf("cursor = %+v, want newest c without backfill", cursor)
+	}
+}
+
+func TestListCommitsPages(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	// two commits share each date so the pages have to break ties on the sha
+	commits := []Commit{}
+	for i := 0; i < 7; i++ {
+		commits = append(commits, testCommit(repo.ID, fmt.Sprintf("%c", 'a'+i), i/2))
+	}
+	_, err := s.SaveCommits("main", commits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{1, []string{"g", "f", "e", "d", "c", "b", "a"}},
+		{2, []string{"gf", "ed", "cb", "a"}},
+		{3, []string{"gfe", "dcb", "a"}},
+		{7, []string{"gfedcba"}},
+		{10, []string{"gfedcba"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			pages := []string{}
+			f := CommitFilter{Limit: tt.limit}
+			for {
+				page, err := s.ListCommits(repo.ID, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				pages = append(pages, shas(page))
+				last := page[len(page)-1]
+				f.After = &CommitCursor{Date: last.Date, SHA: last.SHA}
+			}
+			if fmt.Sprint(pages) != fmt.Sprint(tt.want) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRegexp(t *testing.T) {
+	s := newTestStore(t)
+	for pattern, valid := range map[string]bool{"": true, `(?i)^fix`: true, `\pL+`: true, `fix(`: false, `[[:<:]]fix`: false} {
+		err := s.CheckRegexp(pattern)
+		if (err == nil) != valid {
+			t.Errorf("CheckRegexp(%q) = %v, want valid %v", pattern, err, valid)
+		}
+	}
+}
+
+func TestDeleteRepoCache(t *testing.T) {
+	s := newTestStore(t)
+	deleted := newTestRepo(t, s, "a_b")
+	kept := newTestRepo(t, s, "aXb")
+
+	for _, u := range []string{deleted.URL, deleted.URL + "/commits?sha=main", kept.URL, kept.URL + "/commits?sha=main"} {
+		err := s.SaveCacheEntry(&CacheEntry{URL: u, ETag: `"etag"`, Updated: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := s.DeleteRepo(deleted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		cached bool
+	}{
+		{deleted.URL, false},
+		{deleted.URL + "/commits?sha=main", false},
+		{kept.URL, true},
+		{kept.URL + "/commits?sha=main", true},
+	}
+	for _, tt := range tests {
+		e, err := s.GetCacheEntry(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (e != nil) != tt.cached {
+			t.Errorf("%s cached = %v, want %v", tt.url, e != nil, tt.cached)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Deployment is a deployment of a repository to an environment. State is the state of its latest status,
+// Succeeded and Failed when it first reported success and failure or error, if it did. PreviousSHA is the sha
+// of the successful deployment to the same environment before it, the commits between the two are the commits
+// it shipped.
+type Deployment struct {
+	ID           int64              `json:"id" db:"id"`
+	RepositoryID int                `json:"repository_id" db:"repository_id"`
+	SHA          string             `json:"sha" db:"sha"`
+	Ref          string             `json:"ref" db:"ref"`
+	Environment  string             `json:"environment" db:"environment"`
+	Creator      string             `json:"creator" db:"creator"`
+	Created      time.Time          `json:"created_at" db:"created_at"`
+	State        string             `json:"state" db:"state"`
+	Succeeded    *time.Time         `json:"succeeded_at" db:"succeeded_at"`
+	Failed       *time.Time         `json:"failed_at" db:"failed_at"`
+	PreviousSHA  string             `json:"previous_sha" db:"previous_sha"`
+	Commits      int                `json:"commits"`
+	Statuses     []DeploymentStatus `json:"statuses,omitempty"`
+
+	commitsLinked bool
+}
+
+// DeploymentStatus is a status reported for a deployment
+type DeploymentStatus struct {
+	ID      int64     `json:"id" db:"id"`
+	State   string    `json:"state" db:"state"`
+	Created time.Time `json:"created_at" db:"created_at"`
+}
+
+// deployment states
+const (
+	DeploymentSuccess  = "success"
+	DeploymentFailure  = "failure"
+	DeploymentError    = "error"
+	DeploymentInactive = "inactive"
+)
+
+// final tells whether no status is expected after this one. Successful deployments become inactive
+// once a later one succeeds, which doesn't matter here.
+func (d Deployment) final() bool {
+	return d.Succeeded != nil || d.Failed != nil
+}
+
+// applyStatuses sets the state of the deployment and when it succeeded and failed from its statuses
+func (d *Deployment) applyStatuses(statuses []DeploymentStatus) {
+	d.Statuses = statuses
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Created.Before(statuses[j].Created) })
+	for _, s := range statuses {
+		d.State = s.State
+		created := s.Created
+		switch s.State {
+		case DeploymentSuccess:
+			if d.Succeeded == nil {
+				d.Succeeded = &created
+			}
+		case DeploymentFailure, DeploymentError:
+			if d.Failed == nil {
+				d.Failed = &created
+			}
+		}
+	}
+}
+
+// deploymentsResource names the deployments in the resource_cursors table
+const deploymentsResource = "deployments"
+
+// deploymentPendingLimit is how long after its creation the statuses of a deployment that hasn't succeeded or failed
+// are still fetched, some never get a final status
+const deploymentPendingLimit = 7 * 24 * time.Hour
+
+// SyncDeployments fetches the deployments created since the last sync, newest first, and the statuses of those
+// that haven't succeeded or failed yet, for a week after their creation. The sync point only moves once the whole
+// listing has been walked. It then records the commits of every newly successful deployment by comparing its sha
+// with the one of the successful deployment to the same environment before it. The first one to an environment has
+// nothing to compare with, so no commits are recorded for it. It returns how many deployments were new or changed.
+func SyncDeployments(repo *Repository) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncDeployments(repo)
+}
+
+// syncDeployments is SyncDeployments for callers already holding the repository lock
+func syncDeployments(repo *Repository) (int, error) {
+	since, err := store.GetResourceCursor(repo.ID, deploymentsResource)
+	if err != nil {
+		LogError(fmt.Errorf("error getting deployments cursor : %v", err))
+		return 0, err
+	}
+
+	stored, err := store.GetDeployments(repo.ID)
+	if err != nil {
+		return 0, err
+	}
+	known := map[int64]Deployment{}
+	for _, d := range stored {
+		known[d.ID] = d
+	}
+
+	deployments, err := fetchDeployments(repo, since)
+	if err != nil {
+		return 0, err
+	}
+	// the ones created before the sync point aren't listed again
+	listed := map[int64]bool{}
+	for _, d := range deployments {
+		listed[d.ID] = true
+	}
+	for _, d := range stored {
+		if !listed[d.ID] {
+			deployments = append(deployments, d)
+		}
+	}
+
+	updated := 0
+	newest := since
+	pendingSince := time.Now().Add(-deploymentPendingLimit)
+	for i := range deployments {
+		d := &deployments[i]
+		if d.Created.After(newest) {
+			newest = d.Created
+		}
+		old, seen := known[d.ID]
+		if seen && (old.final() || old.Created.Before(pendingSince)) {
+			// keep what's stored, a final deployment doesn't change and one pending for that long won't anymore
+			*d = old
+			continue
+		}
+
+		statuses, err := fetchDeploymentStatuses(repo, d.ID)
+		if err != nil {
+			return updated, err
+		}
+		d.applyStatuses(statuses)
+		if seen && d.State == old.State {
+			*d = old
+			continue
+		}
+
+		err = store.SaveDeployment(d)
+		if err != nil {
+			LogError(fmt.Errorf("error saving deployment %d : %v", d.ID, err))
+			return updated, err
+		}
+		updated++
+	}
+
+	// successful deployments in the order they succeeded, to find the one before each one
+	successful := []Deployment{}
+	for _, d := range deployments {
+		if d.Succeeded != nil {
+			successful = append(successful, d)
+		}
+	}
+	sort.SliceStable(successful, func(i, j int) bool { return successful[i].Succeeded.Before(*successful[j].Succeeded) })
+
+	previous := map[string]string{}
+	for _, d := range successful {
+		prev := previous[d.Environment]
+		previous[d.Environment] = d.SHA
+		if d.commitsLinked && d.PreviousSHA == prev {
+			continue
+		}
+
+		commits := []Commit{}
+		if prev != "" && prev != d.SHA {
+			commits, err = compareCommits(repo, prev, d.SHA)
+			if err != nil {
+				return updated, err
+			}
+		}
+		err = store.SaveDeploymentCommits(repo.ID, d.ID, prev, commits)
+		if err != nil {
+			LogError(fmt.Errorf("error saving the commits of deployment %d : %v", d.ID, err))
+			return updated, err
+		}
+	}
+
+	err = store.SaveResourceCursor(repo.ID, deploymentsResource, newest)
+	if err != nil {
+		LogError(fmt.Errorf("error saving deployments cursor : %v", err))
+		return updated, err
+	}
+
+	return updated, nil
+}
+
+// fetchDeployments fetches the deployments of the repository created since the given time, newest first, every
+// one if it's zero
+func fetchDeployments(repo *Repository, since time.Time) ([]Deployment, error) {
+	deployments := []Deployment{}
+	URL := repo.URL + "/deployments?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with deployments request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			ID          int64  `json:"id"`
+			SHA         string `json:"sha"`
+			Ref         string `json:"ref"`
+			Environment string `json:"environment"`
+			Creator     *struct {
+				Login string `json:"login"`
+			} `json:"creator"`
+			Created time.Time `json:"created_at"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing deployments : %v", err))
+			return nil, err
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+		for _, d := range response {
+			if d.Created.Before(since) {
+				// everything from here on was synced before
+				URL = ""
+				break
+			}
+			deployment := Deployment{
+				ID:           d.ID,
+				RepositoryID: repo.ID,
+				SHA:          d.SHA,
+				Ref:          d.Ref,
+				Environment:  d.Environment,
+				Created:      d.Created,
+			}
+			if d.Creator != nil {
+				deployment.Creator = d.Creator.Login
+			}
+			deployments = append(deployments, deployment)
+		}
+	}
+
+	return deployments, nil
+}
+
+// fetchDeploymentStatuses fetches every status reported for a deployment
+func fetchDeploymentStatuses(repo *Repository, id int64) ([]DeploymentStatus, error) {
+	statuses := []DeploymentStatus{}
+	URL := fmt.Sprintf("%s/deployments/%d/statuses?per_page=100", repo.URL, id)
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with deployment statuses request : %v", err))
+			return nil, err
+		}
+
+		response := []DeploymentStatus{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing deployment statuses : %v", err))
+			return nil, err
+		}
+		statuses = append(statuses, response...)
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return statuses, nil
+}
+
+// SaveDeployment upserts the deployment and replaces its statuses, in one transaction
+func (s *sqlStore) SaveDeployment(d *Deployment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO deployments (id, repository_id, sha, ref, environment, creator, created_at,
+			state, succeeded_at, failed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		ON CONFLICT (id) DO UPDATE SET
+			state=$8,
+			succeeded_at=$9,
+			failed_at=$10`,
+		d.ID, d.RepositoryID, d.SHA, d.Ref, d.Environment, d.Creator, d.Created.UTC(),
+		d.State, utcOrNil(d.Succeeded), utcOrNil(d.Failed))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM deployment_statuses WHERE deployment_id=$1", d.ID)
+	if err != nil {
+		return err
+	}
+	for _, st := range d.Statuses {
+		_, err = tx.Exec(`INSERT INTO deployment_statuses (id, deployment_id, repository_id, state, created_at)
+			VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING`,
+			st.ID, d.ID, d.RepositoryID, st.State, st.Created.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SaveDeploymentCommits records the shas of the commits shipped by a deployment since the previous one and marks
+// them linked, in one transaction. The commits aren't added to the history of the synced branches.
+func (s *sqlStore) SaveDeploymentCommits(repo_id int, id int64, previousSHA string, commits []Commit) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM deployment_commits WHERE deployment_id=$1", id)
+	if err != nil {
+		return err
+	}
+	for _, c := range commits {
+		_, err = tx.Exec("INSERT INTO deployment_commits (repository_id, deployment_id, sha) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING",
+			repo_id, id, c.SHA)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE deployments SET previous_sha=$2, commits_linked=$3 WHERE id=$1", id, previousSHA, true)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeployments returns the deployments of the repository, latest first, without their statuses
+func (s *sqlStore) GetDeployments(repo_id int) ([]Deployment, error) {
+	rows, err := s.db.Query(`SELECT id, repository_id, sha, ref, environment, creator, created_at, state,
+			succeeded_at, failed_at, previous_sha, commits_linked,
+			(SELECT count(*) FROM deployment_commits c WHERE c.deployment_id = d.id)
+		FROM deployments d WHERE repository_id=$1 ORDER BY created_at DESC, id DESC`, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting deployments from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := []Deployment{}
+	for rows.Next() {
+		d := Deployment{}
+		err = rows.Scan(&d.ID, &d.RepositoryID, &d.SHA, &d.Ref, &d.Environment, &d.Creator, &d.Created, &d.State,
+			&d.Succeeded, &d.Failed, &d.PreviousSHA, &d.commitsLinked, &d.Commits)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning deployment : %v", err))
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments, rows.Err()
+}
+
+// ShippedCommit is a commit along with when it was first shipped, by a deployment or a release
+type ShippedCommit struct {
+	SHA       string     `json:"sha"`
+	Authored  time.Time  `json:"authored_at"`
+	Committed *time.Time `json:"committed_at"`
+	Shipped   time.Time  `json:"shipped_at"`
+}
+
+// GetDeployedCommits returns the synced commits shipped by the successful deployments of the repository to the
+// environment, every environment if it's empty, with when each was first deployed
+func (s *sqlStore) GetDeployedCommits(repo_id int, environment string) ([]ShippedCommit, error) {
+	q := newQuery(`SELECT c.sha, c.date, c.committed_at, d.succeeded_at
+		FROM deployment_commits dc JOIN deployments d ON d.id = dc.deployment_id
+		JOIN commits c ON c.repository_id = dc.repository_id AND c.sha = dc.sha
+		WHERE dc.repository_id=$1 AND d.succeeded_at IS NOT NULL`, repo_id)
+	if environment != "" {
+		q.where("d.environment = $%d", environment)
+	}
+	q.sql += " ORDER BY d.succeeded_at"
+
+	return s.shippedCommits(q)
+}
+
+// GetReleasedCommits returns the commits shipped by the published releases of the repository, with when
+// each was first released
+func (s *sqlStore) GetReleasedCommits(repo_id int) ([]ShippedCommit, error) {
+	q := newQuery(`SELECT c.sha, c.date, c.committed_at, r.published_at
+		FROM release_commits rc JOIN releases r ON r.repository_id = rc.repository_id AND r.tag = rc.tag
+		JOIN commits c ON c.repository_id = rc.repository_id AND c.sha = rc.sha
+		WHERE rc.repository_id=$1 AND NOT r.draft AND r.published_at IS NOT NULL
+		ORDER BY r.published_at`, repo_id)
+
+	return s.shippedCommits(q)
+}
+
+// GetTaggedCommits returns the tagged commits of the repository that are stored, without when they were shipped
+func (s *sqlStore) GetTaggedCommits(repo_id int) ([]ShippedCommit, error) {
+	rows, err := s.db.Query(`SELECT c.sha, c.date, c.committed_at
+		FROM tags t JOIN commits c ON c.repository_id = t.repository_id AND c.sha = t.sha
+		WHERE t.repository_id=$1`, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting tagged commits from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	commits := []ShippedCommit{}
+	for rows.Next() {
+		c := ShippedCommit{}
+		err = rows.Scan(&c.SHA, &c.Authored, &c.Committed)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning tagged commit : %v", err))
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+
+	return commits, rows.Err()
+}
+
+// shippedCommits runs a query listing commits along with when they were shipped, keeping the first time
+// each commit was shipped
+func (s *sqlStore) shippedCommits(q *query) ([]ShippedCommit, error) {
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error getting shipped commits from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	commits := []ShippedCommit{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		c := ShippedCommit{}
+		err = rows.Scan(&c.SHA, &c.Authored, &c.Committed, &c.Shipped)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning shipped commit : %v", err))
+			return nil, err
+		}
+		if seen[c.SHA] {
+			continue
+		}
+		seen[c.SHA] = true
+		commits = append(commits, c)
+	}
+
+	return commits, rows.Err()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeploymentCommitsStayOutOfHistory(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	_, err := s.SaveCommits("main", []Commit{testCommit(repo.ID, "a", 0)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	succeeded := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	err = s.SaveDeployment(&Deployment{ID: 1, RepositoryID: repo.ID, SHA: "b", Environment: "production",
+		Created: succeeded, State: DeploymentSuccess, Succeeded: &succeeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b was deployed from a branch that isn't synced
+	err = s.SaveDeploymentCommits(repo.ID, 1, "x", []Commit{testCommit(repo.ID, "b", 2), testCommit(repo.ID, "a", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := s.ListCommits(repo.ID, CommitFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shas(commits) != "a" {
+		t.Errorf("stored commits = %q, want a", shas(commits))
+	}
+
+	deployments, err := s.GetDeployments(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Commits != 2 || deployments[0].PreviousSHA != "x" {
+		t.Errorf("deployments = %+v, want one with 2 commits since x", deployments)
+	}
+
+	// the delivery metrics take the dates of the commits from the synced history
+	shipped, err := s.GetDeployedCommits(repo.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shipped) != 1 || shipped[0].SHA != "a" || !shipped[0].Shipped.Equal(succeeded) {
+		t.Errorf("deployed commits = %+v, want a deployed at %v", shipped, succeeded)
+	}
+}
+
+// fakeDeployments serves the deployments of a repository, newest first, pageSize a page, along with their statuses
+// and the comparison of their shas
+type fakeDeployments struct {
+	mu          sync.Mutex
+	deployments []Deployment
+	statuses    map[int64][]DeploymentStatus
+	pageSize    int
+	// pages counts the listing requests by page, status the status requests by deployment, and compares are the
+	// base...head of the comparisons
+	pages    map[int]int
+	status   map[int64]int
+	compares []string
+}
+
+func (f *fakeDeployments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rest := strings.TrimPrefix(r.URL.Path, "/repos/org/repo/")
+	switch {
+	case strings.HasPrefix(rest, "compare/"):
+		basehead := strings.TrimPrefix(rest, "compare/")
+		f.compares = append(f.compares, basehead)
+		c := githubCommit{SHA: strings.SplitN(basehead, "...", 2)[1]}
+		json.NewEncoder(w).Encode(map[string][]githubCommit{"commits": {c}})
+	case strings.HasSuffix(rest, "/statuses"):
+		id, _ := strconv.ParseInt(strings.Split(rest, "/")[1], 10, 64)
+		f.status[id]++
+		json.NewEncoder(w).Encode(f.statuses[id])
+	default:
+		f.list(w, r)
+	}
+}
+
+func (f *fakeDeployments) list(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	f.pages[page]++
+
+	deployments := append([]Deployment{}, f.deployments...)
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].Created.After(deployments[j].Created) })
+
+	start, end := (page-1)*f.pageSize, page*f.pageSize
+	if end < len(deployments) {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, next.Encode()))
+	} else {
+		end = len(deployments)
+	}
+
+	response := []map[string]interface{}{}
+	for _, d := range deployments[start:end] {
+		response = append(response, map[string]interface{}{
+			"id":          d.ID,
+			"sha":         d.SHA,
+			"environment": d.Environment,
+			"created_at":  d.Created,
+		})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// deploy adds a deployment to production, successful a minute after its creation if succeeded is true
+func (f *fakeDeployments) deploy(id int64, sha string, created time.Time, succeeded bool) {
+	f.deployments = append(f.deployments, Deployment{ID: id, SHA: sha, Environment: "production", Created: created})
+	if succeeded {
+		f.statuses[id] = []DeploymentStatus{{ID: id, State: DeploymentSuccess, Created: created.Add(time.Minute)}}
+	}
+}
+
+func TestSyncDeployments(t *testing.T) {
+	s := newTestStore(t)
+	fake := &fakeDeployments{statuses: map[int64][]DeploymentStatus{}, pageSize: 2, pages: map[int]int{},
+		status: map[int64]int{}}
+	now := time.Now().UTC().Truncate(time.Second)
+	fake.deploy(1, "a", now.AddDate(0, 0, -20), true)
+	// 2 and 3 never get a status, 2 for longer than the statuses are fetched
+	fake.deploy(2, "b", now.AddDate(0, 0, -10), false)
+	fake.deploy(3, "c", now.Add(-2*time.Hour), false)
+	fake.deploy(4, "d", now.Add(-time.Hour), true)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+
+	updated, err := SyncDeployments(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 4 || len(fake.status) != 4 || fmt.Sprint(fake.compares) != "[a...d]" {
+		t.Errorf("first sync updated %d, fetched the statuses of %v and compared %v", updated, fake.status, fake.compares)
+	}
+	since, err := s.GetResourceCursor(repo.ID, deploymentsResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.Equal(now.Add(-time.Hour)) {
+		t.Errorf("cursor = %v, want %v", since, now.Add(-time.Hour))
+	}
+
+	// the next sync lists up to the cursor, and only fetches the statuses of the new deployment and of the
+	// recent pending one
+	fake.deploy(5, "e", now.Add(-10*time.Minute), true)
+	fake.pages = map[int]int{}
+	fake.status = map[int64]int{}
+	fake.compares = nil
+	updated, err = SyncDeployments(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 || len(fake.status) != 2 || fake.status[5] != 1 || fake.status[3] != 1 {
+		t.Errorf("second sync updated %d fetching the statuses of %v, want 1 fetching 5 and 3", updated, fake.status)
+	}
+	if fake.pages[3] != 0 {
+		t.Error("second sync listed past the cursor")
+	}
+	if fmt.Sprint(fake.compares) != "[d...e]" {
+		t.Errorf("compared %v, want d...e", fake.compares)
+	}
+
+	deployments, err := s.GetDeployments(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 5 || deployments[0].ID != 5 || deployments[0].PreviousSHA != "d" || deployments[0].Commits != 1 {
+		t.Errorf("deployments = %+v, want 5 with the latest shipping 1 commit since d", deployments)
+	}
+}
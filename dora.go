@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DORAPeriod are the four delivery metrics over a week or a month starting at Start. Deployments counts the
+// successful ones, Failed the ones that reported a failure or an error, out of Attempts that reported either.
+// The lead time runs from when a commit was made to when it was first deployed, the time to restore from when
+// a deployment failed to when the next one to the same environment succeeded.
+type DORAPeriod struct {
+	Start             time.Time     `json:"start"`
+	Deployments       int           `json:"deployments"`
+	Attempts          int           `json:"attempts"`
+	Failed            int           `json:"failed"`
+	ChangeFailureRate *float64      `json:"change_failure_rate"`
+	LeadTime          DurationStats `json:"lead_time"`
+	TimeToRestore     DurationStats `json:"time_to_restore"`
+}
+
+// DORA are the delivery metrics of a repository over its last periods, oldest first, and over all of them.
+// Source tells what they're computed from : deployments, or releases and then tags when the repository has
+// none, in which case nothing ever fails, the change failure rate is left out and no time to restore is measured.
+type DORA struct {
+	RepositoryID int          `json:"repository_id"`
+	Source       string       `json:"source"`
+	Environment  string       `json:"environment"`
+	Period       string       `json:"period"`
+	Periods      []DORAPeriod `json:"periods"`
+	Total        DORAPeriod   `json:"total"`
+}
+
+// sources of the delivery metrics
+const (
+	DORADeployments = "deployments"
+	DORAReleases    = "releases"
+	DORATags        = "tags"
+)
+
+// doraEnvironment is the environment the delivery metrics are computed for when none is given, if it's deployed to
+const doraEnvironment = "production"
+
+// periodStart returns when the week, starting on monday, or the month of t starts, in UTC
+func periodStart(t time.Time, period string) (time.Time, error) {
+	t = t.UTC()
+	switch period {
+	case "week":
+		return weekOf(t), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period %q, use week or month", period)
+}
+
+// nextPeriod returns when the period starting at start ends
+func nextPeriod(start time.Time, period string) time.Time {
+	if period == "month" {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+// GetDORA computes the delivery metrics of the repository over its last n weeks or months. The deployments to
+// environment count, to production if it's empty and the repository deploys there, to every environment otherwise.
+// Without any deployment, the published releases stand for them, and without releases the tags of known commits.
+func GetDORA(repo *Repository, environment, period string, n int) (*DORA, error) {
+	_, err := periodStart(time.Now(), period)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := store.GetDeployments(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var d *DORA
+	switch {
+	case len(deployments) > 0:
+		if environment == "" {
+			for _, dep := range deployments {
+				if dep.Environment == doraEnvironment {
+					environment = doraEnvironment
+					break
+				}
+			}
+		}
+		selected := []Deployment{}
+		for _, dep := range deployments {
+			if environment == "" || dep.Environment == environment {
+				selected = append(selected, dep)
+			}
+		}
+		commits, err := store.GetDeployedCommits(repo.ID, environment)
+		if err != nil {
+			return nil, err
+		}
+		d = DORAReport(selected, commits, period, n, time.Now())
+		d.Source = DORADeployments
+		d.Environment = environment
+	default:
+		d, err = releaseDORA(repo, period, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.RepositoryID = repo.ID
+	return d, nil
+}
+
+// releaseDORA computes the delivery metrics of a repository without deployments from its published releases,
+// or from its tags if it has none. A tag is taken as deployed when its commit was made, and every commit of the
+// default branch as shipped by the first tag made after it, so the lead times are only a rough estimate.
+func releaseDORA(repo *Repository, period string, n int) (*DORA, error) {
+	releases, err := store.GetReleases(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	shipped := []Deployment{}
+	for _, r := range releases {
+		if !r.Draft && r.Published != nil {
+			shipped = append(shipped, Deployment{SHA: r.TargetSHA, Ref: r.Tag, State: DeploymentSuccess, Created: *r.Published, Succeeded: r.Published})
+		}
+	}
+	if len(shipped) > 0 {
+		commits, err := store.GetReleasedCommits(repo.ID)
+		if err != nil {
+			return nil, err
+		}
+		d := DORAReport(shipped, commits, period, n, time.Now())
+		d.Source = DORAReleases
+		d.withoutFailures()
+		return d, nil
+	}
+
+	tags, err := store.GetTaggedCommits(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	authored, err := store.GetAuthoredCommits(repo.ID, repo.DefaultBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// several tags of the same commit ship once
+	seen := map[string]bool{}
+	for _, t := range tags {
+		if seen[t.SHA] {
+			continue
+		}
+		seen[t.SHA] = true
+		date := t.Authored
+		if t.Committed != nil {
+			date = *t.Committed
+		}
+		shipped = append(shipped, Deployment{SHA: t.SHA, State: DeploymentSuccess, Created: date, Succeeded: &date})
+	}
+	sort.Slice(shipped, func(i, j int) bool { return shipped[i].Succeeded.Before(*shipped[j].Succeeded) })
+
+	commits := []ShippedCommit{}
+	for _, c := range authored {
+		i := sort.Search(len(shipped), func(i int) bool { return !shipped[i].Succeeded.Before(c.Date) })
+		if i < len(shipped) {
+			commits = append(commits, ShippedCommit{Authored: c.Date, Shipped: *shipped[i].Succeeded})
+		}
+	}
+
+	d := DORAReport(shipped, commits, period, n, time.Now())
+	d.Source = DORATags
+	d.withoutFailures()
+	return d, nil
+}
+
+// withoutFailures leaves out the change failure rates, for sources that can't fail
+func (d *DORA) withoutFailures() {
+	for i := range d.Periods {
+		d.Periods[i].ChangeFailureRate = nil
+	}
+	d.Total.ChangeFailureRate = nil
+}
+
+// DORAReport computes the delivery metrics over the last n periods ending with the one of now, from the given
+// deployments and the commits they shipped. The failure rate is nil when no deployment succeeded or failed
+// in a period.
+func DORAReport(deployments []Deployment, commits []ShippedCommit, period string, n int, now time.Time) *DORA {
+	d := &DORA{Period: period, Periods: []DORAPeriod{}}
+	current, err := periodStart(now, period)
+	if err != nil || n <= 0 {
+		return d
+	}
+
+	starts := []time.Time{current}
+	for i := 1; i < n; i++ {
+		start := starts[0].AddDate(0, 0, -7)
+		if period == "month" {
+			start = starts[0].AddDate(0, -1, 0)
+		}
+		starts = append([]time.Time{start}, starts...)
+	}
+	end := nextPeriod(current, period)
+
+	// index returns the period t falls in, -1 if it's not in any of them
+	index := func(t time.Time) int {
+		if t.Before(starts[0]) || !t.Before(end) {
+			return -1
+		}
+		i := sort.Search(len(starts), func(i int) bool { return starts[i].After(t) })
+		return i - 1
+	}
+
+	type durations struct{ lead, restore []time.Duration }
+	periods := make([]DORAPeriod, n)
+	collected := make([]durations, n)
+	total, all := DORAPeriod{Start: starts[0]}, durations{}
+	for i := range periods {
+		periods[i].Start = starts[i]
+	}
+
+	// the successful deployments to each environment, in the order they succeeded, to find what restored a failure
+	successes := map[string][]time.Time{}
+	for _, dep := range deployments {
+		if dep.Succeeded != nil {
+			successes[dep.Environment] = append(successes[dep.Environment], *dep.Succeeded)
+		}
+	}
+	for _, s := range successes {
+		sort.Slice(s, func(i, j int) bool { return s[i].Before(s[j]) })
+	}
+
+	for _, dep := range deployments {
+		if dep.Succeeded != nil {
+			if i := index(*dep.Succeeded); i >= 0 {
+				periods[i].Deployments++
+				total.Deployments++
+			}
+		}
+
+		// an attempt counts when it first reported success or failure
+		if !dep.final() {
+			continue
+		}
+		attempted := dep.Succeeded
+		if attempted == nil || (dep.Failed != nil && dep.Failed.Before(*attempted)) {
+			attempted = dep.Failed
+		}
+		if i := index(*attempted); i >= 0 {
+			periods[i].Attempts++
+			total.Attempts++
+			if dep.Failed != nil {
+				periods[i].Failed++
+				total.Failed++
+			}
+		}
+
+		if dep.Failed == nil {
+			continue
+		}
+		s := successes[dep.Environment]
+		j := sort.Search(len(s), func(j int) bool { return s[j].After(*dep.Failed) })
+		if j == len(s) {
+			continue
+		}
+		if i := index(*dep.Failed); i >= 0 {
+			restore := s[j].Sub(*dep.Failed)
+			collected[i].restore = append(collected[i].restore, restore)
+			all.restore = append(all.restore, restore)
+		}
+	}
+
+	for _, c := range commits {
+		i := index(c.Shipped)
+		if i < 0 {
+			continue
+		}
+		made := c.Authored
+		if c.Committed != nil {
+			made = *c.Committed
+		}
+		lead := c.Shipped.Sub(made)
+		if lead < 0 {
+			// clocks don't always agree
+			lead = 0
+		}
+		collected[i].lead = append(collected[i].lead, lead)
+		all.lead = append(all.lead, lead)
+	}
+
+	finish := func(p *DORAPeriod, ds durations) {
+		p.LeadTime = durationStats(ds.lead)
+		p.TimeToRestore = durationStats(ds.restore)
+		if p.Attempts > 0 {
+			rate := float64(p.Failed) / float64(p.Attempts)
+			p.ChangeFailureRate = &rate
+		}
+	}
+	for i := range periods {
+		finish(&periods[i], collected[i])
+	}
+	finish(&total, all)
+
+	d.Periods = periods
+	d.Total = total
+	return d
+}
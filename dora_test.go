@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDORAReport(t *testing.T) {
+	at := func(day, hour int) *time.Time {
+		d := time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC)
+		return &d
+	}
+	deployments := []Deployment{
+		{Environment: "production", Succeeded: at(5, 10)},
+		{Environment: "production", Failed: at(6, 10)},
+		{Environment: "production", Succeeded: at(6, 14)},
+		// failed then succeeded once retried
+		{Environment: "production", Failed: at(12, 8), Succeeded: at(12, 9)},
+		{Environment: "production", Created: *at(12, 10)},
+		{Environment: "staging", Failed: at(12, 10)},
+		// before the periods
+		{Environment: "production", Succeeded: at(-9, 0)},
+	}
+	commits := []ShippedCommit{
+		{Authored: *at(5, 8), Shipped: *at(5, 10)},
+		{Authored: *at(1, 0), Committed: at(6, 6), Shipped: *at(6, 14)},
+		{Authored: *at(12, 10), Shipped: *at(12, 9)},
+		{Authored: *at(-11, 0), Shipped: *at(-9, 0)},
+	}
+
+	d := DORAReport(deployments, commits, "week", 3, *at(13, 12))
+	if len(d.Periods) != 3 {
+		t.Fatalf("periods = %+v", d.Periods)
+	}
+
+	rate := func(r *float64) float64 {
+		if r == nil {
+			return -1
+		}
+		return *r
+	}
+	tests := []struct {
+		name                         string
+		p                            DORAPeriod
+		start                        time.Time
+		deployments, attempts, fails int
+		rate                         float64
+		lead, restore                DurationStats
+	}{
+		{"first week", d.Periods[0], time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), 0, 0, 0, -1,
+			DurationStats{}, DurationStats{}},
+		{"second week", d.Periods[1], *at(4, 0), 2, 3, 1, 1.0 / 3,
+			DurationStats{Count: 2, MedianHours: 2, P90Hours: 8, MeanHours: 5}, DurationStats{Count: 1, MedianHours: 4, P90Hours: 4, MeanHours: 4}},
+		{"current week", d.Periods[2], *at(11, 0), 1, 2, 2, 1,
+			DurationStats{Count: 1}, DurationStats{Count: 1, MedianHours: 1, P90Hours: 1, MeanHours: 1}},
+		{"total", d.Total, time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), 3, 5, 3, 0.6,
+			DurationStats{Count: 3, MedianHours: 2, P90Hours: 8, MeanHours: 10.0 / 3}, DurationStats{Count: 2, MedianHours: 1, P90Hours: 4, MeanHours: 2.5}},
+	}
+	for _, tt := range tests {
+		p := tt.p
+		if !p.Start.Equal(tt.start) || p.Deployments != tt.deployments || p.Attempts != tt.attempts || p.Failed != tt.fails {
+			t.Errorf("%s = %+v, want %d deployments, %d attempts and %d failed from %v", tt.name, p, tt.deployments,
+				tt.attempts, tt.fails, tt.start)
+		}
+		if rate(p.ChangeFailureRate) != tt.rate {
+			t.Errorf("%s change failure rate = %v, want %v", tt.name, rate(p.ChangeFailureRate), tt.rate)
+		}
+		if p.LeadTime != tt.lead || p.TimeToRestore != tt.restore {
+			t.Errorf("%s lead time = %+v and time to restore = %+v, want %+v and %+v", tt.name, p.LeadTime,
+				p.TimeToRestore, tt.lead, tt.restore)
+		}
+	}
+
+	for _, tt := range []struct {
+		period string
+		n      int
+	}{{"day", 3}, {"week", 0}} {
+		if d := DORAReport(deployments, commits, tt.period, tt.n, *at(13, 12)); len(d.Periods) != 0 {
+			t.Errorf("%d %s periods = %+v, want none", tt.n, tt.period, d.Periods)
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	wednesday := time.Date(2024, 3, 13, 23, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+	tests := []struct {
+		period    string
+		want, end time.Time
+	}{
+		// in UTC it's already thursday
+		{"week", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := periodStart(wednesday, tt.period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) || !nextPeriod(got, tt.period).Equal(tt.end) {
+			t.Errorf("%s of %v = %v to %v, want %v to %v", tt.period, wednesday, got, nextPeriod(got, tt.period), tt.want, tt.end)
+		}
+	}
+	if _, err := periodStart(wednesday, "year"); err == nil {
+		t.Error("year period accepted")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Host is a github instance repositories can be tracked from, github.com or an enterprise server
+type Host struct {
+	Name   string `json:"name"`
+	APIURL string `json:"api_url"`
+}
+
+// DefaultHost is github.com, always available
+var DefaultHost = Host{Name: "github.com", APIURL: "https://api.github.com"}
+
+// Hosts returns the configured github hosts, github.com first.
+// Enterprise hosts are read from GITHUB_HOSTS, a comma separated list of host names, each
+// optionally followed by =<api-url> when the api isn't served from the default https://<host>/api/v3.
+func Hosts() []Host {
+	hosts := []Host{DefaultHost}
+
+	for _, entry := range strings.Split(os.Getenv("GITHUB_HOSTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, apiURL, found := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == DefaultHost.Name {
+			continue
+		}
+		if !found || apiURL == "" {
+			apiURL = "https://" + name + "/api/v3"
+		}
+
+		hosts = append(hosts, Host{Name: name, APIURL: strings.TrimSuffix(strings.TrimSpace(apiURL), "/")})
+	}
+
+	return hosts
+}
+
+// LookupHost returns the configured host with the given name
+func LookupHost(name string) (*Host, error) {
+	name = strings.ToLower(name)
+	for _, h := range Hosts() {
+		if h.Name == name {
+			return &h, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown github host %q, add it to GITHUB_HOSTS", name)
+}
+
+// HostForAPIURL returns the configured host serving the given api url
+func HostForAPIURL(apiURL string) (*Host, error) {
+	for _, h := range Hosts() {
+		if apiURL == h.APIURL || strings.HasPrefix(apiURL, h.APIURL+"/") {
+			return &h, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s doesn't belong to any configured github host", apiURL)
+}
+
+// WebURL returns the url of the web interface of the host
+func (h Host) WebURL() string {
+	return "https://" + h.Name
+}
+
+// RepoURL returns the api url of the given repository on the host
+func (h Host) RepoURL(owner, name string) string {
+	return fmt.Sprintf("%s/repos/%s/%s", h.APIURL, owner, name)
+}
+
+// envKey returns the suffix used for per host environment variables, e.g. GHE_CORP for ghe.corp
+func (h Host) envKey() string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, h.Name))
+}
+
+// Getenv reads a per host setting, GITHUB_TOKEN for github.com and GITHUB_TOKEN_GHE_CORP for ghe.corp
+func (h Host) Getenv(key string) string {
+	if h.Name == DefaultHost.Name {
+		return os.Getenv(key)
+	}
+	return os.Getenv(key + "_" + h.envKey())
+}
+
+// ParseRepoURL parses a repository reference into its host, owner and name.
+// It accepts web urls, .git clone urls, git@host:org/repo ssh urls and api urls.
+func ParseRepoURL(raw string) (*Host, string, string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, "", "", fmt.Errorf("invalid url provided")
+	}
+
+	// api urls point straight at the repository
+	if h, err := HostForAPIURL(raw); err == nil {
+		path := strings.TrimPrefix(raw, h.APIURL)
+		path = strings.TrimPrefix(path, "/repos")
+		owner, name, err := splitRepoPath(path)
+		return h, owner, name, err
+	}
+
+	hostName, path := "", ""
+	switch {
+	case strings.Contains(raw, "://"):
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("invalid url provided : %v", err)
+		}
+		hostName, path = u.Hostname(), u.Path
+	case strings.Contains(raw, "@") && strings.Contains(raw, ":"):
+		// scp like ssh form, git@host:org/repo.git
+		_, rest, _ := strings.Cut(raw, "@")
+		hostName, path, _ = strings.Cut(rest, ":")
+	default:
+		// no scheme, host/org/repo
+		hostName, path, _ = strings.Cut(raw, "/")
+	}
+
+	h, err := LookupHost(hostName)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	owner, name, err := splitRepoPath(path)
+	return h, owner, name, err
+}
+
+// splitRepoPath extracts owner and repository name from a /org/repo[.git][/...] path
+func splitRepoPath(path string) (string, string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid url provided")
+	}
+
+	return parts[0], strings.TrimSuffix(parts[1], ".git"), nil
+}
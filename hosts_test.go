@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseRepoURL(t *testing.T) {
+	t.Setenv("GITHUB_HOSTS", "ghe.corp, git.example.com=https://api.git.example.com/")
+
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"https://github.com/org/repo", "https://api.github.com/repos/org/repo", false},
+		{"https://github.com/org/repo/tree/main/docs", "https://api.github.com/repos/org/repo", false},
+		{"https://github.com/org/repo.git", "https://api.github.com/repos/org/repo", false},
+		{"git@github.com:org/repo.git", "https://api.github.com/repos/org/repo", false},
+		{"github.com/org/repo", "https://api.github.com/repos/org/repo", false},
+		{"https://api.github.com/repos/org/repo", "https://api.github.com/repos/org/repo", false},
+		{"  https://GitHub.com/org/repo  ", "https://api.github.com/repos/org/repo", false},
+		// enterprise hosts, with the default api url and with their own
+		{"https://ghe.corp/org/repo", "https://ghe.corp/api/v3/repos/org/repo", false},
+		{"git@ghe.corp:org/repo.git", "https://ghe.corp/api/v3/repos/org/repo", false},
+		{"https://ghe.corp/api/v3/repos/org/repo", "https://ghe.corp/api/v3/repos/org/repo", false},
+		{"https://git.example.com/org/repo", "https://api.git.example.com/repos/org/repo", false},
+		{"https://api.git.example.com/repos/org/repo", "https://api.git.example.com/repos/org/repo", false},
+		{"https://gitlab.com/org/repo", "", true},
+		{"git@gitlab.com:org/repo.git", "", true},
+		{"https://github.com/org", "", true},
+		{"https://api.github.com/repos/org", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := SanitizeRepoURL(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SanitizeRepoURL(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SanitizeRepoURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	t.Setenv("GITHUB_HOSTS", " GHE.corp ,,github.com, git.example.com=https://api.git.example.com/")
+
+	hosts := Hosts()
+	want := []Host{
+		DefaultHost,
+		{Name: "ghe.corp", APIURL: "https://ghe.corp/api/v3"},
+		{Name: "git.example.com", APIURL: "https://api.git.example.com"},
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("hosts = %+v, want %+v", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("host %d = %+v, want %+v", i, hosts[i], want[i])
+		}
+	}
+
+	_, err := LookupHost("gitlab.com")
+	if err == nil {
+		t.Error("unknown host gitlab.com found")
+	}
+	h, err := HostForAPIURL("https://ghe.corp/api/v3/repos/org/repo")
+	if err != nil || h.Name != "ghe.corp" {
+		t.Errorf("host of an enterprise api url = %+v, %v", h, err)
+	}
+	if h := (Host{Name: "ghe.corp"}); h.envKey() != "GHE_CORP" {
+		t.Errorf("env key of ghe.corp = %s, want GHE_CORP", h.envKey())
+	}
+}
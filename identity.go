@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Contributor is the person behind the commits of one or more logins, emails and names.
+// Every commit is attributed to a contributor when it's stored.
+type Contributor struct {
+	ID      int      `json:"id" db:"id"`
+	Name    string   `json:"name" db:"name"`
+	Email   string   `json:"email" db:"email"`
+	Login   string   `json:"login" db:"login"`
+	Commits int      `json:"commits"`
+	Aliases []string `json:"aliases"`
+}
+
+// kinds of contributor aliases, a commit is matched by name and email first, then login, then email
+const (
+	aliasNameEmail = "name_email"
+	aliasLogin     = "login"
+	aliasEmail     = "email"
+)
+
+// sources of contributor aliases
+const (
+	sourceCommit  = "commit"
+	sourceGithub  = "github"
+	sourceMailmap = "mailmap"
+	sourceManual  = "manual"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nameEmailAlias is the value of the alias matching a name and email together
+func nameEmailAlias(name, email string) string {
+	return strings.ToLower(strings.TrimSpace(name)) + " <" + strings.ToLower(strings.TrimSpace(email)) + ">"
+}
+
+// noreplyLogin returns the login of a github noreply address, 123+login@users.noreply.github.com or login@users.noreply.github.com
+func noreplyLogin(email string) string {
+	local, ok := strings.CutSuffix(strings.ToLower(email), "@users.noreply.github.com")
+	if !ok {
+		return ""
+	}
+	if _, login, found := strings.Cut(local, "+"); found {
+		return login
+	}
+	return local
+}
+
+// findAlias returns the contributor holding the alias, 0 if none does
+func findAlias(db querier, kind, value string) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT contributor_id FROM contributor_aliases WHERE kind=$1 AND value=$2", kind, value).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// setAlias gives the alias to the contributor, taking it from whoever held it
+func setAlias(db querier, kind, value string, id int, source string) error {
+	_, err := db.Exec(`INSERT INTO contributor_aliases (kind, value, contributor_id, source) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (kind, value) DO UPDATE SET contributor_id=$3, source=$4`, kind, value, id, source)
+	return err
+}
+
+// addAlias gives the alias to the contributor unless someone already holds it
+func addAlias(db querier, kind, value string, id int, source string) error {
+	_, err := db.Exec(`INSERT INTO contributor_aliases (kind, value, contributor_id, source) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (kind, value) DO NOTHING`, kind, value, id, source)
+	return err
+}
+
+// createContributor adds a contributor known by the email and login, when given
+func createContributor(db querier, name, email, login string) (int, error) {
+	var id int
+	err := db.QueryRow("INSERT INTO contributors (name, email, login) VALUES ($1,$2,$3) RETURNING id",
+		name, strings.ToLower(email), login).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if email != "" {
+		err = addAlias(db, aliasEmail, strings.ToLower(email), id, sourceCommit)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if login != "" {
+		err = addAlias(db, aliasLogin, strings.ToLower(login), id, sourceGithub)
+	}
+	return id, err
+}
+
+// resolveContributor returns the contributor of a commit, creating one for unknown authors.
+// The github login ties together the emails someone commits from: an email first seen without
+// a login is merged into the contributor of the login once a commit links the two.
+func resolveContributor(db querier, login, name, email string) (int, error) {
+	if login == "" {
+		login = noreplyLogin(email)
+	}
+	email = strings.ToLower(email)
+
+	id, err := findAlias(db, aliasNameEmail, nameEmailAlias(name, email))
+	if err != nil || id != 0 {
+		return id, err
+	}
+
+	// without an email only the name or the login tell authors apart
+	byEmail := 0
+	if email != "" {
+		byEmail, err = findAlias(db, aliasEmail, email)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if login == "" {
+		if byEmail != 0 {
+			return byEmail, nil
+		}
+		id, err := createContributor(db, name, email, "")
+		if err != nil || email != "" {
+			return id, err
+		}
+		// nothing but the name to recognize the next commits by
+		return id, addAlias(db, aliasNameEmail, nameEmailAlias(name, email), id, sourceCommit)
+	}
+
+	byLogin, err := findAlias(db, aliasLogin, strings.ToLower(login))
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case byLogin == 0 && byEmail == 0:
+		return createContributor(db, name, email, login)
+	case byLogin == 0:
+		// the contributor of the email gets the login unless it already has another one
+		var known string
+		err = db.QueryRow("SELECT login FROM contributors WHERE id=$1", byEmail).Scan(&known)
+		if err != nil {
+			return 0, err
+		}
+		if known != "" {
+			// the email is shared with someone else, the login gets its own contributor
+			return createContributor(db, name, "", login)
+		}
+		_, err = db.Exec("UPDATE contributors SET login=$2 WHERE id=$1", byEmail, login)
+		if err != nil {
+			return 0, err
+		}
+		return byEmail, addAlias(db, aliasLogin, strings.ToLower(login), byEmail, sourceGithub)
+	case byEmail == 0:
+		if email == "" {
+			return byLogin, nil
+		}
+		return byLogin, addAlias(db, aliasEmail, email, byLogin, sourceGithub)
+	case byEmail != byLogin:
+		var known string
+		err = db.QueryRow("SELECT login FROM contributors WHERE id=$1", byEmail).Scan(&known)
+		if err != nil {
+			return 0, err
+		}
+		if known == "" {
+			return byLogin, mergeContributors(db, byEmail, byLogin)
+		}
+	}
+	return byLogin, nil
+}
+
+// mergeContributors moves the aliases and commits of a contributor to another one and removes it
+func mergeContributors(db querier, from, into int) error {
+	if from == into {
+		return nil
+	}
+
+	var login string
+	err := db.QueryRow("SELECT login FROM contributors WHERE id=$1", from).Scan(&login)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		"UPDATE contributor_aliases SET contributor_id=$2 WHERE contributor_id=$1",
+		"UPDATE commits SET contributor_id=$2 WHERE contributor_id=$1",
+	} {
+		_, err = db.Exec(stmt, from, into)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec("DELETE FROM contributors WHERE id=$1", from)
+	if err != nil {
+		return err
+	}
+
+	if login != "" {
+		_, err = db.Exec("UPDATE contributors SET login=$2 WHERE id=$1 AND login=''", into, login)
+	}
+	return err
+}
+
+// MergeContributors attributes everything of the contributor from to the contributor into
+func (s *sqlStore) MergeContributors(from, into int) error {
+	if from == into {
+		return fmt.Errorf("can't merge contributor %d into itself", from)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{from, into} {
+		var n int
+		err = tx.QueryRow("SELECT count(*) FROM contributors WHERE id=$1", id).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("contributor %d not found : %w", id, sql.ErrNoRows)
+		}
+	}
+
+	// record that the aliases moved by hand
+	_, err = tx.Exec("UPDATE contributor_aliases SET source=$2 WHERE contributor_id=$1", from, sourceManual)
+	if err != nil {
+		return err
+	}
+
+	err = mergeContributors(tx, from, into)
+	if err != nil {
+		LogError(fmt.Errorf("error merging contributors : %v", err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MailmapEntry is a line of a .mailmap file, mapping the commits of CommitEmail, only those also
+// named CommitName if it's given, to ProperName and ProperEmail. Empty proper fields are left as is.
+type MailmapEntry struct {
+	ProperName  string `json:"proper_name"`
+	ProperEmail string `json:"proper_email"`
+	CommitName  string `json:"commit_name"`
+	CommitEmail string `json:"commit_email"`
+}
+
+// ParseMailmap reads the entries of a file in the .mailmap format
+func ParseMailmap(r io.Reader) ([]MailmapEntry, error) {
+	entries := []MailmapEntry{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// up to two names, each followed by an email
+		names, emails := []string{}, []string{}
+		rest := line
+		for {
+			open := strings.Index(rest, "<")
+			if open < 0 {
+				break
+			}
+			end := strings.Index(rest[open:], ">")
+			if end < 0 {
+				return nil, fmt.Errorf("mailmap line %d : unterminated email", n)
+			}
+			names = append(names, strings.TrimSpace(rest[:open]))
+			emails = append(emails, strings.TrimSpace(rest[open+1:open+end]))
+			rest = rest[open+end+1:]
+		}
+		if strings.TrimSpace(rest) != "" || len(emails) == 0 || len(emails) > 2 {
+			return nil, fmt.Errorf("mailmap line %d : expected Proper Name <proper@email> Commit Name <commit@email>", n)
+		}
+
+		e := MailmapEntry{ProperName: names[0]}
+		if len(emails) == 1 {
+			e.CommitEmail = emails[0]
+		} else {
+			e.ProperEmail = emails[0]
+			e.CommitName = names[1]
+			e.CommitEmail = emails[1]
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// ApplyMailmap attributes the commits matched by each entry to the contributor of its proper email,
+// or to the contributor of the commit email when the entry only sets a name. The aliases recorded
+// make commits fetched later follow the mailmap too. It returns how many entries were applied.
+func (s *sqlStore) ApplyMailmap(entries []MailmapEntry) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for i, e := range entries {
+		err = applyMailmapEntry(tx, e)
+		if err != nil {
+			LogError(fmt.Errorf("error applying mailmap entry %d : %v", i+1, err))
+			return 0, err
+		}
+	}
+
+	// forget the contributors left without aliases nor commits
+	_, err = tx.Exec(`DELETE FROM contributors WHERE id NOT IN (SELECT contributor_id FROM contributor_aliases)
+		AND id NOT IN (SELECT contributor_id FROM commits WHERE contributor_id IS NOT NULL)`)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(entries), tx.Commit()
+}
+
+func applyMailmapEntry(tx *sql.Tx, e MailmapEntry) error {
+	commitEmail := strings.ToLower(e.CommitEmail)
+
+	// the contributor the commits go to
+	target := 0
+	var err error
+	if e.ProperEmail != "" {
+		target, err = findAlias(tx, aliasEmail, strings.ToLower(e.ProperEmail))
+		if err != nil {
+			return err
+		}
+		if target == 0 {
+			target, err = createContributor(tx, e.ProperName, e.ProperEmail, "")
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		target, err = findAlias(tx, aliasEmail, commitEmail)
+		if err != nil {
+			return err
+		}
+		if target == 0 {
+			target, err = createContributor(tx, e.ProperName, commitEmail, "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.CommitName != "" {
+		// only the commits with both the name and the email
+		err = setAlias(tx, aliasNameEmail, nameEmailAlias(e.CommitName, commitEmail), target, sourceMailmap)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE commits SET contributor_id=$1
+			WHERE LOWER(author_email)=$2 AND LOWER(TRIM(author_name))=$3`, target, commitEmail, strings.ToLower(strings.TrimSpace(e.CommitName)))
+		if err != nil {
+			return err
+		}
+	} else {
+		source, err := findAlias(tx, aliasEmail, commitEmail)
+		if err != nil {
+			return err
+		}
+		if source != 0 && source != target {
+			err = mergeContributors(tx, source, target)
+			if err != nil {
+				return err
+			}
+		}
+		err = setAlias(tx, aliasEmail, commitEmail, target, sourceMailmap)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE commits SET contributor_id=$1 WHERE LOWER(author_email)=$2", target, commitEmail)
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.ProperName != "" {
+		_, err = tx.Exec("UPDATE contributors SET name=$2 WHERE id=$1", target, e.ProperName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetContributors returns the contributors with their aliases and how many commits they made, most commits first
+func (s *sqlStore) GetContributors() ([]Contributor, error) {
+	rows, err := s.db.Query(`SELECT id, name, email, login,
+			(SELECT count(*) FROM commits c WHERE c.contributor_id = p.id) AS commits
+		FROM contributors p ORDER BY commits DESC, id`)
+	if err != nil {
+		LogError(fmt.Errorf("error getting contributors from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributors := []Contributor{}
+	index := map[int]int{}
+	for rows.Next() {
+		c := Contributor{Aliases: []string{}}
+		err = rows.Scan(&c.ID, &c.Name, &c.Email, &c.Login, &c.Commits)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning contributor result : %v", err))
+			return nil, err
+		}
+
+		index[c.ID] = len(contributors)
+		contributors = append(contributors, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	aliases, err := s.db.Query("SELECT contributor_id, kind, value FROM contributor_aliases ORDER BY kind, value")
+	if err != nil {
+		LogError(fmt.Errorf("error getting contributor aliases from db : %v", err))
+		return nil, err
+	}
+	defer aliases.Close()
+
+	for aliases.Next() {
+		var id int
+		var kind, value string
+		err = aliases.Scan(&id, &kind, &value)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning contributor alias : %v", err))
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			contributors[i].Aliases = append(contributors[i].Aliases, kind+":"+value)
+		}
+	}
+
+	return contributors, aliases.Err()
+}
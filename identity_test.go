@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMailmap(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []MailmapEntry
+		wantErr bool
+	}{
+		{"proper name", "Jane Doe <jane@example.com>",
+			[]MailmapEntry{{ProperName: "Jane Doe", CommitEmail: "jane@example.com"}}, false},
+		{"proper email", "<jane@example.com> <jdoe@old.example.com>",
+			[]MailmapEntry{{ProperEmail: "jane@example.com", CommitEmail: "jdoe@old.example.com"}}, false},
+		{"commit name", "Jane Doe <jane@example.com> jd <jdoe@old.example.com>",
+			[]MailmapEntry{{ProperName: "Jane Doe", ProperEmail: "jane@example.com", CommitName: "jd", CommitEmail: "jdoe@old.example.com"}}, false},
+		{"comments and blank lines", "# authors\n\nJane Doe <jane@example.com> # work\n",
+			[]MailmapEntry{{ProperName: "Jane Doe", CommitEmail: "jane@example.com"}}, false},
+		{"unterminated email", "Jane Doe <jane@example.com", nil, true},
+		{"no email", "Jane Doe", nil, true},
+		{"trailing text", "Jane Doe <jane@example.com> jd", nil, true},
+		{"three emails", "<a@example.com> <b@example.com> <c@example.com>", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseMailmap(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.want)
+			}
+		})
+	}
+}
+
+// contributorsOf returns the contributor of each stored commit by sha
+func contributorsOf(t *testing.T, s Store) map[string]int {
+	t.Helper()
+	rows, err := s.(*sqlStore).db.Query("SELECT sha, COALESCE(contributor_id, 0) FROM commits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	contributors := map[string]int{}
+	for rows.Next() {
+		var sha string
+		var id int
+		err = rows.Scan(&sha, &id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contributors[sha] = id
+	}
+	return contributors
+}
+
+func TestEmptyEmailAuthors(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	commits := []Commit{testCommit(repo.ID, "a", 0), testCommit(repo.ID, "b", 1), testCommit(repo.ID, "c", 2)}
+	commits[0].AuthorEmail, commits[0].AuthorName = "", "Jane Doe"
+	commits[1].AuthorEmail, commits[1].AuthorName = "", "John Smith"
+	commits[2].AuthorEmail, commits[2].AuthorName = "", "jane doe"
+	_, err := s.SaveCommits("main", commits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := contributorsOf(t, s)
+	if ids["a"] == ids["b"] || ids["a"] != ids["c"] {
+		t.Errorf("contributors = %v, want a and c together, b apart", ids)
+	}
+}
+
+func TestEmptyEmailAliasMigration(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+	_, err := s.MigrateDown(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the state the alias seeding used to leave: one contributor for every commit without an email
+	db := s.(*sqlStore).db
+	for _, stmt := range []string{
+		`INSERT INTO contributors (id, name, email, login) VALUES (100, 'John Smith', '', 'jsmith')`,
+		`INSERT INTO contributor_aliases (kind, value, contributor_id, source) VALUES ('email', '', 100, 'commit')`,
+		`INSERT INTO contributor_aliases (kind, value, contributor_id, source) VALUES ('login', 'jsmith', 100, 'github')`,
+		`INSERT INTO contributors (id, name, email, login) VALUES (101, 'Bob', 'bob@example.com', 'bob')`,
+		`INSERT INTO contributor_aliases (kind, value, contributor_id, source) VALUES ('login', 'bob', 101, 'github')`,
+	} {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	commits := []Commit{testCommit(repo.ID, "a", 0), testCommit(repo.ID, "b", 1), testCommit(repo.ID, "c", 2),
+		testCommit(repo.ID, "d", 3)}
+	commits[0].AuthorEmail, commits[0].AuthorName = "", "Jane Doe"
+	commits[1].AuthorEmail, commits[1].AuthorName = "", "John Smith"
+	commits[2].AuthorEmail, commits[2].AuthorName = "", "Jane Doe"
+	commits[3].AuthorEmail, commits[3].AuthorName, commits[3].AuthorLogin = "", "Bob", "bob"
+	_, err = s.SaveCommits("main", commits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE commits SET contributor_id = 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := contributorsOf(t, s)
+	if ids["a"] == 100 || ids["a"] != ids["c"] || ids["a"] == ids["b"] || ids["d"] != 101 {
+		t.Errorf("contributors = %v, want a and c together, b apart and d to 101", ids)
+	}
+	var aliases int
+	err = db.QueryRow("SELECT count(*) FROM contributor_aliases WHERE kind = 'email' AND value = ''").Scan(&aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliases != 0 {
+		t.Errorf("%d empty email aliases left", aliases)
+	}
+}
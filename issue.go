@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Issue is an issue of a repository, pull requests are kept apart in PullRequest
+type Issue struct {
+	RepositoryID int        `json:"repository_id" db:"repository_id"`
+	Number       int        `json:"number" db:"number"`
+	Title        string     `json:"title" db:"title"`
+	State        string     `json:"state" db:"state"`
+	Author       string     `json:"author" db:"author"`
+	Milestone    string     `json:"milestone" db:"milestone"`
+	Comments     int        `json:"comments" db:"comments"`
+	Created      time.Time  `json:"created_at" db:"created_at"`
+	Updated      time.Time  `json:"updated_at" db:"updated_at"`
+	Closed       *time.Time `json:"closed_at" db:"closed_at"`
+	Labels       []string   `json:"labels"`
+	Assignees    []string   `json:"assignees"`
+}
+
+// issuesResource names the issues in the resource_cursors table
+const issuesResource = "issues"
+
+// SyncIssues fetches the issues updated since the last sync, oldest update first so the sync point can
+// move with every page. Pull requests listed by the issues api are skipped. It returns how many were updated.
+func SyncIssues(repo *Repository) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncIssues(repo)
+}
+
+// syncIssues is SyncIssues for callers already holding the repository lock
+func syncIssues(repo *Repository) (int, error) {
+	since, err := store.GetResourceCursor(repo.ID, issuesResource)
+	if err != nil {
+		LogError(fmt.Errorf("error getting issues cursor : %v", err))
+		return 0, err
+	}
+
+	params := url.Values{}
+	params.Set("state", "all")
+	params.Set("sort", "updated")
+	params.Set("direction", "asc")
+	params.Set("per_page", "100")
+	if !since.IsZero() {
+		params.Set("since", since.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	URL := repo.URL + "/issues?" + params.Encode()
+
+	updated := 0
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with issues request : %v", err))
+			return updated, err
+		}
+
+		response := []struct {
+			Number int    `json:"number"`
+			Title  string `json:"title"`
+			State  string `json:"state"`
+			User   struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			Labels []struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+			Assignees []struct {
+				Login string `json:"login"`
+			} `json:"assignees"`
+			Milestone *struct {
+				Title string `json:"title"`
+			} `json:"milestone"`
+			Comments    int        `json:"comments"`
+			Created     time.Time  `json:"created_at"`
+			Updated     time.Time  `json:"updated_at"`
+			Closed      *time.Time `json:"closed_at"`
+			PullRequest *struct{}  `json:"pull_request"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing issues : %v", err))
+			return updated, err
+		}
+
+		issues := []Issue{}
+		for _, i := range response {
+			if i.Updated.After(since) {
+				since = i.Updated
+			}
+			if i.PullRequest != nil {
+				continue
+			}
+
+			issue := Issue{
+				RepositoryID: repo.ID,
+				Number:       i.Number,
+				Title:        i.Title,
+				State:        i.State,
+				Author:       i.User.Login,
+				Comments:     i.Comments,
+				Created:      i.Created,
+				Updated:      i.Updated,
+				Closed:       i.Closed,
+				Labels:       []string{},
+				Assignees:    []string{},
+			}
+			if i.Milestone != nil {
+				issue.Milestone = i.Milestone.Title
+			}
+			for _, l := range i.Labels {
+				issue.Labels = append(issue.Labels, l.Name)
+			}
+			for _, a := range i.Assignees {
+				issue.Assignees = append(issue.Assignees, a.Login)
+			}
+			issues = append(issues, issue)
+		}
+
+		err = store.SaveIssues(repo.ID, issues, since)
+		if err != nil {
+			LogError(fmt.Errorf("error saving issues : %v", err))
+			return updated, err
+		}
+		updated += len(issues)
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return updated, nil
+}
+
+// SaveIssues saves the issues with their labels and assignees, and moves the issues sync point, in one transaction
+func (s *sqlStore) SaveIssues(repo_id int, issues []Issue, syncedUntil time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := `insert into issues (
+		repository_id,
+		number,
+		title,
+		state,
+		author,
+		milestone,
+		comments,
+		created_at,
+		updated_at,
+		closed_at
+	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+	ON CONFLICT (repository_id, number) DO UPDATE SET
+		title=$3,
+		state=$4,
+		author=$5,
+		milestone=$6,
+		comments=$7,
+		updated_at=$9,
+		closed_at=$10
+	`
+
+	for _, i := range issues {
+		_, err = tx.Exec(insert, repo_id, i.Number, i.Title, i.State, i.Author, i.Milestone, i.Comments,
+			i.Created.UTC(), i.Updated.UTC(), utcOrNil(i.Closed))
+		if err != nil {
+			return fmt.Errorf("error saving issue %d : %v", i.Number, err)
+		}
+
+		for _, stmt := range []string{
+			"DELETE FROM issue_labels WHERE repository_id=$1 AND number=$2",
+			"DELETE FROM issue_assignees WHERE repository_id=$1 AND number=$2",
+		} {
+			_, err = tx.Exec(stmt, repo_id, i.Number)
+			if err != nil {
+				return err
+			}
+		}
+		for _, l := range i.Labels {
+			_, err = tx.Exec("INSERT INTO issue_labels (repository_id, number, label) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING",
+				repo_id, i.Number, l)
+			if err != nil {
+				return err
+			}
+		}
+		for _, a := range i.Assignees {
+			_, err = tx.Exec("INSERT INTO issue_assignees (repository_id, number, login) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING",
+				repo_id, i.Number, a)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`insert into resource_cursors (repository_id, resource, synced_until, updated_at)
+		values ($1,$2,$3,$4)
+		ON CONFLICT (repository_id, resource) DO UPDATE SET
+			synced_until=$3,
+			updated_at=$4`,
+		repo_id, issuesResource, syncedUntil.UTC(), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IssueFilter narrows down the issues returned by ListIssues and counted by CountIssues.
+// Label and assignee match case insensitively, empty fields don't filter.
+type IssueFilter struct {
+	State     string
+	Label     string
+	Assignee  string
+	Author    string
+	Milestone string
+}
+
+// where adds the conditions of the filter to the query
+func (f IssueFilter) where(q *query) {
+	if f.State != "" {
+		q.where("state = $%d", f.State)
+	}
+	if f.Label != "" {
+		q.where("number IN (SELECT number FROM issue_labels WHERE repository_id=$1 AND LOWER(label) = LOWER($%d))", f.Label)
+	}
+	if f.Assignee != "" {
+		q.where("number IN (SELECT number FROM issue_assignees WHERE repository_id=$1 AND LOWER(login) = LOWER($%d))", f.Assignee)
+	}
+	if f.Author != "" {
+		q.where("LOWER(author) = LOWER($%d)", f.Author)
+	}
+	if f.Milestone != "" {
+		q.where("milestone = $%d", f.Milestone)
+	}
+}
+
+// ListIssues returns the issues of the repository matching the filter, newest first
+func (s *sqlStore) ListIssues(repo_id int, f IssueFilter) ([]Issue, error) {
+	q := newQuery(`SELECT repository_id, number, title, state, author, milestone, comments,
+		created_at, updated_at, closed_at FROM issues WHERE repository_id=$1`, repo_id)
+	f.where(q)
+	q.sql += " ORDER BY number DESC"
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error listing issues : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := []Issue{}
+	index := map[int]int{}
+	for rows.Next() {
+		i := Issue{Labels: []string{}, Assignees: []string{}}
+		err = rows.Scan(&i.RepositoryID, &i.Number, &i.Title, &i.State, &i.Author, &i.Milestone, &i.Comments,
+			&i.Created, &i.Updated, &i.Closed)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning issue result : %v", err))
+			return nil, err
+		}
+
+		index[i.Number] = len(issues)
+		issues = append(issues, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// attach the labels and assignees
+	for _, table := range []string{"issue_labels", "issue_assignees"} {
+		column := "label"
+		if table == "issue_assignees" {
+			column = "login"
+		}
+
+		rows, err := s.db.Query("SELECT number, "+column+" FROM "+table+" WHERE repository_id=$1 ORDER BY "+column, repo_id)
+		if err != nil {
+			LogError(fmt.Errorf("error listing %s : %v", table, err))
+			return nil, err
+		}
+		for rows.Next() {
+			var number int
+			var value string
+			err = rows.Scan(&number, &value)
+			if err != nil {
+				rows.Close()
+				LogError(fmt.Errorf("error scanning %s : %v", table, err))
+				return nil, err
+			}
+
+			i, ok := index[number]
+			if !ok {
+				continue
+			}
+			if column == "label" {
+				issues[i].Labels = append(issues[i].Labels, value)
+			} else {
+				issues[i].Assignees = append(issues[i].Assignees, value)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return issues, nil
+}
+
+// CountIssues returns how many issues of the repository match the filter
+func (s *sqlStore) CountIssues(repo_id int, f IssueFilter) (int, error) {
+	q := newQuery("SELECT count(*) FROM issues WHERE repository_id=$1", repo_id)
+	f.where(q)
+
+	var n int
+	err := s.db.QueryRow(q.sql, q.args...).Scan(&n)
+	if err != nil {
+		LogError(fmt.Errorf("error counting issues : %v", err))
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// BacklogPoint is the number of issues open at a point in time
+type BacklogPoint struct {
+	Date   time.Time `json:"date"`
+	Open   int       `json:"open"`
+	Opened int       `json:"opened"`
+	Closed int       `json:"closed"`
+}
+
+// IssueBacklog counts the open issues at the end of each of the last n weeks, along with how many
+// were opened and closed during that week. The issues are expected to be listed regardless of state.
+func IssueBacklog(issues []Issue, weeks int, now time.Time) []BacklogPoint {
+	points := []BacklogPoint{}
+	for w := weeks - 1; w >= 0; w-- {
+		end := now.AddDate(0, 0, -7*w)
+		start := end.AddDate(0, 0, -7)
+
+		p := BacklogPoint{Date: end}
+		for _, i := range issues {
+			if i.Created.After(end) {
+				continue
+			}
+			if i.Closed == nil || i.Closed.After(end) {
+				p.Open++
+			}
+			if i.Created.After(start) {
+				p.Opened++
+			}
+			if i.Closed != nil && i.Closed.After(start) && !i.Closed.After(end) {
+				p.Closed++
+			}
+		}
+		points = append(points, p)
+	}
+
+	return points
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssues serves the issues listing of a repository, pull requests included, oldest update first,
+// pageSize a page
+type fakeIssues struct {
+	mu      sync.Mutex
+	updated map[int]time.Time
+	pulls   map[int]bool
+	// failPage makes the next request for that page fail, 0 for none
+	failPage int
+	pageSize int
+	// sinces are the since parameters of the requests for the first page
+	sinces []string
+}
+
+func (f *fakeIssues) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+		f.sinces = append(f.sinces, r.URL.Query().Get("since"))
+	}
+	if page == f.failPage {
+		f.failPage = 0
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	numbers := []int{}
+	since, _ := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	for n, updated := range f.updated {
+		if !updated.Before(since) {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return f.updated[numbers[i]].Before(f.updated[numbers[j]]) })
+
+	start, end := (page-1)*f.pageSize, page*f.pageSize
+	if end < len(numbers) {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, next.Encode()))
+	} else {
+		end = len(numbers)
+	}
+
+	response := []map[string]interface{}{}
+	for _, n := range numbers[start:end] {
+		item := map[string]interface{}{
+			"number":     n,
+			"title":      fmt.Sprintf("issue %d", n),
+			"state":      "open",
+			"user":       map[string]string{"login": "alice"},
+			"labels":     []map[string]string{{"name": "bug"}},
+			"created_at": f.updated[n],
+			"updated_at": f.updated[n],
+		}
+		if f.pulls[n] {
+			item["pull_request"] = map[string]string{}
+		}
+		response = append(response, item)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestSyncIssues(t *testing.T) {
+	s := newTestStore(t)
+	hour := func(h int) time.Time { return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC) }
+	// 2 and 4 are pull requests
+	fake := &fakeIssues{updated: map[int]time.Time{}, pulls: map[int]bool{2: true, 4: true}, pageSize: 2}
+	for n := 1; n <= 5; n++ {
+		fake.updated[n] = hour(n)
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+
+	stored := func() []int {
+		t.Helper()
+		issues, err := s.ListIssues(repo.ID, IssueFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers := []int{}
+		for _, i := range issues {
+			numbers = append(numbers, i.Number)
+		}
+		sort.Ints(numbers)
+		return numbers
+	}
+
+	// the second page fails, the first one is kept along with the cursor it moved to
+	fake.failPage = 2
+	_, err := SyncIssues(repo)
+	if err == nil {
+		t.Fatal("sync with a failing page succeeded")
+	}
+	if got := fmt.Sprint(stored()); got != "[1]" {
+		t.Errorf("stored after a failed sync = %s, want [1]", got)
+	}
+	since, err := s.GetResourceCursor(repo.ID, issuesResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.Equal(hour(2)) {
+		t.Errorf("cursor = %v, want %v, the pull request ending the first page", since, hour(2))
+	}
+
+	// the resume lists from the cursor
+	updated, err := SyncIssues(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 2 || fmt.Sprint(stored()) != "[1 3 5]" {
+		t.Errorf("resume updated %d, stored = %v, want 2 and [1 3 5]", updated, stored())
+	}
+	if len(fake.sinces) != 2 || fake.sinces[0] != "" || fake.sinces[1] != "2024-01-01T02:00:00Z" {
+		t.Errorf("since parameters = %q, want none then the cursor", fake.sinces)
+	}
+	since, err = s.GetResourceCursor(repo.ID, issuesResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.Equal(hour(5)) {
+		t.Errorf("cursor = %v, want %v", since, hour(5))
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DurationStats sums up how long something took over several pull requests, in hours
+type DurationStats struct {
+	Count       int     `json:"count"`
+	MedianHours float64 `json:"median_hours"`
+	P90Hours    float64 `json:"p90_hours"`
+	MeanHours   float64 `json:"mean_hours"`
+}
+
+// ReviewStats are the delivery metrics of a set of pull requests. The lead times count from when a pull request
+// was opened, only reviews from someone else than its author count. A review round ends with changes requested,
+// or with the last review, so a pull request approved straight away took one round.
+type ReviewStats struct {
+	PullRequests             int            `json:"pull_requests"`
+	Merged                   int            `json:"merged"`
+	MergedWithoutReview      int            `json:"merged_without_review"`
+	MergedWithoutReviewShare float64        `json:"merged_without_review_share"`
+	TimeToFirstReview        DurationStats  `json:"time_to_first_review"`
+	TimeToApproval           DurationStats  `json:"time_to_approval"`
+	TimeToMerge              DurationStats  `json:"time_to_merge"`
+	ReviewRounds             float64        `json:"review_rounds"`
+	Sizes                    map[string]int `json:"sizes"`
+}
+
+// AuthorReviewStats are the delivery metrics of the pull requests opened by an author
+type AuthorReviewStats struct {
+	Author string `json:"author"`
+	ReviewStats
+}
+
+// ReviewWindow are the delivery metrics of the pull requests opened over a trailing window, of the whole
+// repository and of each author, those with the most pull requests first
+type ReviewWindow struct {
+	Window  string              `json:"window"`
+	Since   *time.Time          `json:"since"`
+	Stats   ReviewStats         `json:"stats"`
+	Authors []AuthorReviewStats `json:"authors"`
+}
+
+// PullMetrics are the delivery metrics of a repository over the last 30, 90 and 365 days and all time
+type PullMetrics struct {
+	RepositoryID int            `json:"repository_id"`
+	Windows      []ReviewWindow `json:"windows"`
+}
+
+// pullSizes are the size buckets of the pull requests by lines changed, the last one has no upper bound
+var pullSizes = []struct {
+	Name  string
+	Below int
+}{
+	{"XS", 10},
+	{"S", 50},
+	{"M", 250},
+	{"L", 1000},
+	{"XL", 0},
+}
+
+// pullSize returns the size bucket of a pull request
+func pullSize(pr PullRequest) string {
+	lines := pr.Additions + pr.Deletions
+	for _, s := range pullSizes {
+		if s.Below == 0 || lines < s.Below {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// pullReviews returns the reviews of a pull request by someone else than its author, submitted before it was
+// merged or closed if it was
+func pullReviews(pr PullRequest) []Review {
+	end := pr.Merged
+	if end == nil {
+		end = pr.Closed
+	}
+
+	reviews := []Review{}
+	for _, r := range pr.Reviews {
+		if strings.EqualFold(r.Reviewer, pr.Author) {
+			continue
+		}
+		if end != nil && r.Submitted.After(*end) {
+			continue
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews
+}
+
+// reviewRounds counts the review rounds of a pull request from its reviews, oldest first
+func reviewRounds(reviews []Review) int {
+	rounds := 0
+	open := false
+	for _, r := range reviews {
+		open = true
+		if r.State == ReviewChangesRequested {
+			rounds++
+			open = false
+		}
+	}
+	if open {
+		rounds++
+	}
+	return rounds
+}
+
+// durationStats sums up the given durations
+func durationStats(durations []time.Duration) DurationStats {
+	stats := DurationStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	stats.MedianHours = percentile(durations, 0.5).Hours()
+	stats.P90Hours = percentile(durations, 0.9).Hours()
+	stats.MeanHours = (sum / time.Duration(len(durations))).Hours()
+	return stats
+}
+
+// percentile returns the nearest rank percentile p of the given sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+// reviewStats computes the delivery metrics of the given pull requests
+func reviewStats(prs []PullRequest) ReviewStats {
+	stats := ReviewStats{PullRequests: len(prs), Sizes: map[string]int{}}
+	for _, s := range pullSizes {
+		stats.Sizes[s.Name] = 0
+	}
+
+	firstReview, approval, merge := []time.Duration{}, []time.Duration{}, []time.Duration{}
+	reviewed, rounds := 0, 0
+	for _, pr := range prs {
+		stats.Sizes[pullSize(pr)]++
+
+		reviews := pullReviews(pr)
+		if len(reviews) > 0 {
+			firstReview = append(firstReview, reviews[0].Submitted.Sub(pr.Created))
+			reviewed++
+			rounds += reviewRounds(reviews)
+		}
+		for _, r := range reviews {
+			if r.State == ReviewApproved {
+				approval = append(approval, r.Submitted.Sub(pr.Created))
+				break
+			}
+		}
+
+		if pr.Merged != nil {
+			stats.Merged++
+			merge = append(merge, pr.Merged.Sub(pr.Created))
+			if len(reviews) == 0 {
+				stats.MergedWithoutReview++
+			}
+		}
+	}
+
+	stats.TimeToFirstReview = durationStats(firstReview)
+	stats.TimeToApproval = durationStats(approval)
+	stats.TimeToMerge = durationStats(merge)
+	if reviewed > 0 {
+		stats.ReviewRounds = float64(rounds) / float64(reviewed)
+	}
+	if stats.Merged > 0 {
+		stats.MergedWithoutReviewShare = float64(stats.MergedWithoutReview) / float64(stats.Merged)
+	}
+	return stats
+}
+
+// formatHours formats a duration given in hours, in days past two days
+func formatHours(hours float64) string {
+	if hours >= 48 {
+		return strconv.FormatFloat(hours/24, 'f', 1, 64) + "d"
+	}
+	return strconv.FormatFloat(hours, 'f', 1, 64) + "h"
+}
+
+// window returns the metrics over the named window, nil if there's no such window
+func (m *PullMetrics) window(name string) *ReviewWindow {
+	for i := range m.Windows {
+		if m.Windows[i].Window == name {
+			return &m.Windows[i]
+		}
+	}
+	return nil
+}
+
+// GetPullMetrics computes the delivery metrics of the stored pull requests of the repository
+func GetPullMetrics(repo_id int) (*PullMetrics, error) {
+	prs, err := store.ListPullRequests(repo_id, PullRequestFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	m := PullMetricsReport(prs, time.Now())
+	m.RepositoryID = repo_id
+	return m, nil
+}
+
+// PullMetricsReport computes the delivery metrics of the pull requests opened over each trailing window ending now.
+// Drafts are left out until they're marked ready.
+func PullMetricsReport(prs []PullRequest, now time.Time) *PullMetrics {
+	m := &PullMetrics{Windows: []ReviewWindow{}}
+	for _, days := range trailingWindows {
+		w := ReviewWindow{Window: windowName(days), Authors: []AuthorReviewStats{}}
+		if days > 0 {
+			since := now.AddDate(0, 0, -days)
+			w.Since = &since
+		}
+
+		selected := []PullRequest{}
+		byAuthor := map[string][]PullRequest{}
+		for _, pr := range prs {
+			if pr.Draft || (w.Since != nil && pr.Created.Before(*w.Since)) {
+				continue
+			}
+			selected = append(selected, pr)
+			byAuthor[pr.Author] = append(byAuthor[pr.Author], pr)
+		}
+
+		w.Stats = reviewStats(selected)
+		for author, authored := range byAuthor {
+			w.Authors = append(w.Authors, AuthorReviewStats{Author: author, ReviewStats: reviewStats(authored)})
+		}
+		sort.Slice(w.Authors, func(i, j int) bool {
+			a, b := w.Authors[i], w.Authors[j]
+			if a.PullRequests != b.PullRequests {
+				return a.PullRequests > b.PullRequests
+			}
+			return a.Author < b.Author
+		})
+		m.Windows = append(m.Windows, w)
+	}
+	return m
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Hour)
+	}
+	tests := []struct {
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{sorted, 0.5, 5 * time.Hour},
+		{sorted, 0.9, 9 * time.Hour},
+		{sorted, 0.95, 10 * time.Hour},
+		{sorted, 1, 10 * time.Hour},
+		{sorted[:1], 0.5, time.Hour},
+		{sorted[:3], 0.5, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.durations, tt.p); got != tt.want {
+			t.Errorf("percentile of %d durations at %v = %v, want %v", len(tt.durations), tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestReviewStats(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	after := func(hours int) time.Time { return created.Add(time.Duration(hours) * time.Hour) }
+	merged := func(hours int) *time.Time {
+		t := after(hours)
+		return &t
+	}
+	review := func(reviewer, state string, hours int) Review {
+		return Review{Reviewer: reviewer, State: state, Submitted: after(hours)}
+	}
+
+	prs := []PullRequest{
+		{Author: "alice", Created: created, Merged: merged(12), Additions: 3, Deletions: 2, Reviews: []Review{
+			review("alice", ReviewApproved, 1),
+			review("bob", ReviewCommented, 2),
+			review("bob", ReviewChangesRequested, 4),
+			review("carol", ReviewApproved, 10),
+		}},
+		// the review came after the merge
+		{Author: "bob", Created: created, Merged: merged(24), Additions: 100, Reviews: []Review{
+			review("alice", ReviewApproved, 30),
+		}},
+		{Author: "alice", Created: created, Additions: 1500, Deletions: 500, Reviews: []Review{
+			review("bob", ReviewApproved, 6),
+		}},
+	}
+
+	got := reviewStats(prs)
+	want := ReviewStats{
+		PullRequests:             3,
+		Merged:                   2,
+		MergedWithoutReview:      1,
+		MergedWithoutReviewShare: 0.5,
+		TimeToFirstReview:        DurationStats{Count: 2, MedianHours: 2, P90Hours: 6, MeanHours: 4},
+		TimeToApproval:           DurationStats{Count: 2, MedianHours: 6, P90Hours: 10, MeanHours: 8},
+		TimeToMerge:              DurationStats{Count: 2, MedianHours: 12, P90Hours: 24, MeanHours: 18},
+		ReviewRounds:             1.5,
+		Sizes:                    map[string]int{"XS": 1, "S": 0, "M": 1, "L": 0, "XL": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v\nwant %+v", got, want)
+	}
+
+	empty := reviewStats(nil)
+	if empty.PullRequests != 0 || empty.TimeToMerge.Count != 0 || empty.ReviewRounds != 0 || len(empty.Sizes) != len(pullSizes) {
+		t.Errorf("stats without pull requests = %+v", empty)
+	}
+}
+
+func TestPullMetricsReport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	prs := []PullRequest{
+		{Author: "alice", Created: now.AddDate(0, 0, -10)},
+		{Author: "bob", Created: now.AddDate(0, 0, -10), Draft: true},
+		{Author: "bob", Created: now.AddDate(0, 0, -100)},
+		{Author: "alice", Created: now.AddDate(0, 0, -200)},
+	}
+
+	m := PullMetricsReport(prs, now)
+	want := map[string][]string{
+		"30d":  {"alice"},
+		"90d":  {"alice"},
+		"365d": {"alice", "bob"},
+		"all":  {"alice", "bob"},
+	}
+	pulls := map[string]int{"30d": 1, "90d": 1, "365d": 3, "all": 3}
+	for name, authors := range want {
+		w := m.window(name)
+		if w == nil {
+			t.Fatalf("no %s window", name)
+		}
+		if w.Stats.PullRequests != pulls[name] || len(w.Authors) != len(authors) {
+			t.Errorf("%s window = %d pull requests by %d authors, want %d by %d", name, w.Stats.PullRequests,
+				len(w.Authors), pulls[name], len(authors))
+			continue
+		}
+		for i, a := range authors {
+			if w.Authors[i].Author != a {
+				t.Errorf("%s window author %d = %s, want %s", name, i, w.Authors[i].Author, a)
+			}
+		}
+	}
+}
@@ -1,26 +1,52 @@
 package main
 
 import (
+	"io"
 	"log"
 	"os"
+	"sync"
 )
 
-var errorLogFile *os.File
-var appLogFile *os.File
+var errorLogFile io.Writer
+var appLogFile io.Writer
+
+var logToStdout bool
+var openLogs sync.Once
+
+// LogToStdout sends all logs to stdout instead of the log files, it must be called before anything is logged
+func LogToStdout() {
+	logToStdout = true
+}
+
+// openLogFiles opens the log files on first use, falling back to stderr if they can't be opened
+func openLogFiles() {
+	if logToStdout {
+		appLogFile = os.Stdout
+		errorLogFile = os.Stdout
+		return
+	}
+
+	err := os.MkdirAll("logs", 0755)
+	if err != nil {
+		log.Printf("Failed to create logs directory: %v", err)
+	}
 
-func init() {
 	appLogFile, err = os.OpenFile("logs/app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		log.Printf("Failed to open log file: %v", err)
+		appLogFile = os.Stderr
 	}
 
 	errorLogFile, err = os.OpenFile("logs/error.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		log.Printf("Failed to open log file: %v", err)
+		errorLogFile = os.Stderr
 	}
 }
 
 func LogError(err error) {
+	openLogs.Do(openLogFiles)
+
 	// Set the output of the log package to the file
 	log.SetOutput(errorLogFile)
 	logError := log.New(errorLogFile, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
@@ -28,6 +54,8 @@ func LogError(err error) {
 }
 
 func LogApp(msg string) {
+	openLogs.Do(openLogFiles)
+
 	// Set the output of the log package to the file
 	log.SetOutput(appLogFile)
 	logApp := log.New(appLogFile, "APP: ", log.Ldate|log.Ltime|log.Lshortfile)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -24,6 +25,81 @@ type Menu struct {
 	items    []string
 	parent   *Menu
 	selected int
+	// draw draws what the screen shows below its items, starting at row top
+	draw func(top int)
+	// search hides the items not containing it, searching tells whether it's being typed
+	search    string
+	searching bool
+	// offset is how many of the shown items are scrolled past when they don't fit on the screen
+	offset int
+}
+
+// shown returns the indexes of the items matching the search, all of them without one. The last item
+// is always shown so the screen can be left.
+func (m *Menu) shown() []int {
+	shown := []int{}
+	search := strings.ToLower(m.search)
+	for i, item := range m.items {
+		if search == "" || i == len(m.items)-1 || strings.Contains(strings.ToLower(item), search) {
+			shown = append(shown, i)
+		}
+	}
+	return shown
+}
+
+// move moves the selection by delta among the shown items
+func (m *Menu) move(delta int) {
+	shown := m.shown()
+	if len(shown) == 0 {
+		return
+	}
+	pos := 0
+	for i, index := range shown {
+		if index <= m.selected {
+			pos = i
+		}
+	}
+	pos = max(0, min(len(shown)-1, pos+delta))
+	m.selected = shown[pos]
+}
+
+// setSearch changes the search and selects the first item matching it if the selected one no longer does
+func (m *Menu) setSearch(search string) {
+	m.search = search
+	m.offset = 0
+	shown := m.shown()
+	for _, index := range shown {
+		if index == m.selected {
+			return
+		}
+	}
+	m.selected = shown[0]
+}
+
+// handleSearchKey edits the search of the menu as it's typed, enter keeps it and escape drops it
+func handleSearchKey(m *Menu, ev termbox.Event) {
+	switch ev.Key {
+	case termbox.KeyEnter:
+		m.searching = false
+	case termbox.KeyEsc:
+		m.searching = false
+		m.setSearch("")
+	case termbox.KeyArrowUp:
+		m.move(-1)
+	case termbox.KeyArrowDown:
+		m.move(1)
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if m.search != "" {
+			search := []rune(m.search)
+			m.setSearch(string(search[:len(search)-1]))
+		}
+	case termbox.KeySpace:
+		m.setSearch(m.search + " ")
+	default:
+		if ev.Ch != 0 {
+			m.setSearch(m.search + string(ev.Ch))
+		}
+	}
 }
 
 var mainMenu = &Menu{
@@ -31,6 +107,7 @@ var mainMenu = &Menu{
 	items: []string{
 		"- List Repositories",
 		"- Add Repository",
+		"- Login with GitHub",
 		"Exit",
 	},
 }
@@ -41,23 +118,145 @@ var reposList = &Menu{
 	parent: mainMenu,
 }
 
+// repoFilter narrows down the repositories screen
+var repoFilter RepoFilter
+
 var repoMenu = &Menu{
 	title: "Repository Menu",
 	items: []string{
 		"- Commits",
 		"- Pull",
+		"- Full Re-sync",
 		"- Top Authors",
+		"- Pull Requests",
+		"- Issues",
+		"- Branches",
+		"- Releases",
+		"- Churn",
+		"- Ownership",
+		"- Activity",
+		"- PR Metrics",
+		"- DORA",
+		"- CI",
+		"- Branch : all",
 		"Back",
 	},
 	parent: reposList,
 }
 
+var pullsList = &Menu{
+	title:  "Pull Requests",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+// pullStates are the states the pull requests screen cycles through, all first
+var pullStates = []string{"", PullOpen, PullMerged, PullClosed}
+var pullState string
+
+var issuesList = &Menu{
+	title:  "Issues",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+var issueFilter IssueFilter
+
+var branchesList = &Menu{
+	title:  "Branches",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+var releasesList = &Menu{
+	title:  "Releases",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+var releases = []Release{}
+
+var releaseCommitsList = &Menu{
+	title:  "Release Commits",
+	items:  []string{},
+	parent: releasesList,
+}
+
+var churnList = &Menu{
+	title:  "Churn",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+// churnReports are the reports the churn screen cycles through, churnWeeks the windows, 0 for all time
+var churnReports = []string{"directories", "files", "authors", "coupling"}
+var churnReport = churnReports[0]
+var churnWeeksOptions = []int{12, 52, 0, 4}
+var churnWeeks = churnWeeksOptions[0]
+
+var ownershipList = &Menu{
+	title:  "Ownership",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+var activityList = &Menu{
+	title:  "Activity",
+	items:  []string{},
+	parent: repoMenu,
+	draw:   drawActivity,
+}
+
+// activity is what the activity screen shows, counted in activityTimezone, UTC if empty
+var activity *Activity
+var activityTimezone string
+
+// heatColors are the background colors of the heatmap cells, from no commits to the most
+var heatColors = []termbox.Attribute{termbox.ColorDefault, termbox.ColorBlue, termbox.ColorGreen, termbox.ColorYellow, termbox.ColorRed}
+
+var pullMetricsList = &Menu{
+	title:  "PR Metrics",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+// pullMetricsWindow is the window the metrics per author are shown for
+var pullMetricsWindow = "90d"
+
+var doraList = &Menu{
+	title:  "DORA",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+// doraPeriod is the length of the periods the DORA screen shows, week or month
+var doraPeriod = "week"
+
+var ciList = &Menu{
+	title:  "CI",
+	items:  []string{},
+	parent: repoMenu,
+}
+
+// ciReports are the reports the CI screen cycles through, over the last ciDays days of runs
+var ciReports = []string{"workflows", "flaky", "breakages"}
+var ciReport = ciReports[0]
+
+const ciDays = 90
+
+// branchFilter is the branch the commits and top authors of the repository are shown for, all if empty
+var branchFilter string
+var branches = []Branch{}
+
 var commitsList = &Menu{
 	title:  "Commits",
 	items:  []string{},
 	parent: repoMenu,
 }
 
+// commitFilter narrows down the commits screen, its branch is branchFilter
+var commitFilter CommitFilter
+
 var authorsList = &Menu{
 	title:  "Authors",
 	items:  []string{},
@@ -67,10 +266,63 @@ var authorsList = &Menu{
 var currentMenu *Menu
 
 func main() {
+	// servers log to stdout, decide before anything gets logged
+	if len(os.Args) > 1 && (os.Args[1] == "daemon" || os.Args[1] == "serve") {
+		LogToStdout()
+	}
+
+	// open the database before anything reads or writes to it
+	store, err = OpenStore()
+	if err != nil {
+		fmt.Println("Error connecting to the database : ", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	// schema migrations run without the ui
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(store, os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			store.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = PrepareSchema(store)
+	if err != nil {
+		fmt.Println("Error preparing the database schema : ", err)
+		store.Close()
+		os.Exit(1)
+	}
+
+	// load github credentials before anything talks to the api
+	LoadAllCredentials()
+
+	// run the given command instead of the ui
+	if len(os.Args) > 1 {
+		err = runCommand(os.Args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			store.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// let the user know when requests are held back by rate limits or retries
+	github.OnWait = func(reason string, until time.Time) {
+		y++
+		drawText(0, y, termbox.ColorCyan, termbox.ColorBlack, fmt.Sprintf("%s. Waiting until %v (%v)...",
+			reason, until.Format("15:04:05"), time.Until(until).Round(time.Second)))
+		termbox.Flush()
+	}
+
 	// start refresh cron job
 	startCRON()
 
-	err := termbox.Init()
+	err = termbox.Init()
 	if err != nil {
 		panic(err)
 	}
@@ -83,19 +335,38 @@ func main() {
 	for {
 		switch ev := termbox.PollEvent(); ev.Type {
 		case termbox.EventKey:
+			if currentMenu.searching {
+				handleSearchKey(currentMenu, ev)
+				break
+			}
+
+			_, height := termbox.Size()
 			switch ev.Key {
 			case termbox.KeyArrowUp:
-				if currentMenu.selected > 0 {
-					currentMenu.selected--
-				}
+				currentMenu.move(-1)
 			case termbox.KeyArrowDown:
-				if currentMenu.selected < len(currentMenu.items)-1 {
-					currentMenu.selected++
-				}
+				currentMenu.move(1)
+			case termbox.KeyPgup:
+				currentMenu.move(-(height - 2))
+			case termbox.KeyPgdn:
+				currentMenu.move(height - 2)
 			case termbox.KeyEnter:
+				// a new screen starts without search
+				previous := currentMenu
 				handleSelect()
+				if currentMenu != previous {
+					previous.setSearch("")
+					currentMenu.setSearch("")
+				}
 			case termbox.KeyEsc:
-				return
+				if currentMenu.search == "" {
+					return
+				}
+				currentMenu.setSearch("")
+			default:
+				if ev.Ch == '/' {
+					currentMenu.searching = true
+				}
 			}
 		}
 		drawMenu(currentMenu)
@@ -107,23 +378,10 @@ func handleSelect() {
 		switch currentMenu.selected {
 		case 0:
 			// List repos selected
-			repositories, err = GetRepos()
-			if err != nil {
-				LogError(fmt.Errorf("error getting repositories from db : %v", err))
-			}
-			repos = []string{}
-			repos = append(repos, fmt.Sprintf("ID\t\t\tName\t\t\tLanguage\t\t\tForks\t\t\tStars\t\t\tIssues\t\t\tWatchers"))
-			for _, r := range repositories {
-				repos = append(repos, fmt.Sprintf("%d\t\t\t%s\t\t\t%s\t\t\t%d\t\t\t%d\t\t\t%d\t\t\t%d",
-					r.ID, r.Name, r.Language,
-					r.ForksCount, r.StarsCount,
-					r.OpenIssuesCount, r.WatchersCount))
-			}
-			repos = append(repos, "Back")
-			reposList.items = repos
+			showRepos()
 
 			currentMenu = reposList
-			currentMenu.selected = 1
+			currentMenu.selected = 3
 		case 1:
 			// add repo selected
 			url := promptForRepoURL()
@@ -147,26 +405,18 @@ func handleSelect() {
 					currentMenu = mainMenu
 					currentMenu.selected = 1
 				} else {
-					repositories, err = GetRepos()
-					if err != nil {
-						LogError(fmt.Errorf("error getting repositories from db : %v", err))
-					}
-					repos = []string{}
-					repos = append(repos, fmt.Sprintf("ID\t\t\tName\t\t\tLanguage\t\t\tForks\t\t\tStars\t\t\tIssues\t\t\tWatchers"))
-					for _, r := range repositories {
-						repos = append(repos, fmt.Sprintf("%d\t\t\t%s\t\t\t%s\t\t\t%d\t\t\t%d\t\t\t%d\t\t\t%d",
-							r.ID, r.Name, r.Language,
-							r.ForksCount, r.StarsCount,
-							r.OpenIssuesCount, r.WatchersCount))
-					}
-					repos = append(repos, "Back")
-					reposList.items = repos
+					showRepos()
 
 					currentMenu = reposList
 					currentMenu.selected = len(reposList.items) - 2
 				}
 			}
 		case 2:
+			// login selected
+			login()
+			currentMenu = mainMenu
+			currentMenu.selected = 0
+		case 3:
 			// exit
 			termbox.Close()
 			os.Exit(0)
@@ -176,14 +426,33 @@ func handleSelect() {
 		// under repos list
 		switch currentMenu.selected {
 		case 0:
-			// don't do anything, first item is header row
+			repoFilter.Language = promptForText("Only show repositories in the language, leave empty for all : ")
+			showRepos()
+		case 1:
+			input := promptForText("Only show repositories with at least this many stars, leave empty for all : ")
+			stars := 0
+			if input != "" {
+				stars, err = strconv.Atoi(input)
+				if err != nil || stars < 0 {
+					drawText(0, 2, termbox.ColorRed, termbox.ColorBlack, fmt.Sprintf("Invalid number of stars : %s", input))
+					termbox.Flush()
+					time.Sleep(2 * time.Second)
+					return
+				}
+			}
+			repoFilter.MinStars = stars
+			showRepos()
+		case 2:
+			// don't do anything, header row
 		case len(currentMenu.items) - 1:
 			// back selected
 			currentMenu = currentMenu.parent
 			currentMenu.selected = 0
 		default:
 			// repo selected
-			repository = repositories[currentMenu.selected-1]
+			repository = repositories[currentMenu.selected-3]
+			setBranchFilter("")
+			commitFilter = CommitFilter{}
 			currentMenu = repoMenu
 			currentMenu.selected = 0
 		}
@@ -191,27 +460,10 @@ func handleSelect() {
 		switch currentMenu.selected {
 		case 0:
 			// commits
-			commits, err = GetCommits(repository.ID)
-			if err != nil {
-				LogError(fmt.Errorf("error getting repositories from db : %v", err))
-			}
-
-			commitsShort = []string{}
-			commitsShort = append(commitsShort, fmt.Sprintf("Date\t\t\t\tAuthor\t\t\t\tMessage"))
-			for _, c := range commits {
-				msg := c.Message
-				if len(msg) > 50 {
-					msg = msg[:50] + "..."
-				}
-
-				commitsShort = append(commitsShort, fmt.Sprintf("%s\t\t\t\t%s\t\t\t\t%s",
-					c.Date.Format("2006-01-02"), c.AuthorName, msg))
-			}
-			commitsShort = append(commitsShort, "Back")
-			commitsList.items = commitsShort
+			showCommits()
 
 			currentMenu = commitsList
-			currentMenu.selected = 1
+			currentMenu.selected = 5
 		case 1:
 			// pull selected
 			y++
@@ -219,7 +471,10 @@ func handleSelect() {
 			// take date input from user
 			t, err := promptForDate()
 			if err != nil {
-				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error parsing your date : %v", err))
+				drawText(0, y, termbox.ColorRed, termbox.ColorBlack, fmt.Sprintf("Error parsing your date : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+				return
 			}
 			y++
 
@@ -232,33 +487,41 @@ func handleSelect() {
 			termbox.Flush()
 			time.Sleep(2 * time.Second)
 
-			// fetch commits
-			commits, err := FetchCommits(repository.URL, t)
+			// sync commits, only merging those since the date if one was given
+			if !t.IsZero() {
+				_, err = PullCommitsSince(&repository, *t)
+			} else {
+				_, err = SyncCommits(&repository)
+			}
 			if err != nil {
 				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching commits : %v", err))
 				y++
 			}
 
 			// update display
-			commitsShort = []string{}
-			commitsShort = append(commitsShort, fmt.Sprintf("Date\t\t\t\tAuthor\t\t\t\tMessage"))
-			for _, c := range commits {
-				msg := c.Message
-				if len(msg) > 50 {
-					msg = msg[:50] + "..."
-				}
-
-				commitsShort = append(commitsShort, fmt.Sprintf("%s\t\t\t\t%s\t\t\t\t%s",
-					c.Date.Format("2006-01-02"), c.AuthorName, msg))
-			}
-			commitsShort = append(commitsShort, "Back")
-			commitsList.items = commitsShort
+			showCommits()
 
 			currentMenu = commitsList
-			currentMenu.selected = 1
+			currentMenu.selected = 5
 		case 2:
+			// full re-sync selected
+			y++
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching the whole history again...")
+			y++
+			termbox.Flush()
+
+			result, err := ResyncCommits(&repository)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching commits : %v", err))
+			} else {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Replaced the stored commits with %d commits", result.Added))
+			}
+			y++
+			termbox.Flush()
+			time.Sleep(2 * time.Second)
+		case 3:
 			// top authors
-			authors, err := GetTopAuthors(repository.ID, 10)
+			authors, err := store.GetTopAuthors(repository.ID, 10, branchFilter)
 			if err != nil {
 				drawText(0, 0, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error getting authors from database : %v", err))
 			}
@@ -275,86 +538,997 @@ func handleSelect() {
 
 			currentMenu = authorsList
 			currentMenu.selected = 1
+		case 4:
+			// pull requests
+			pullState = ""
+			showPullRequests()
+			currentMenu = pullsList
+			currentMenu.selected = 0
+		case 5:
+			// issues
+			issueFilter = IssueFilter{State: "open"}
+			showIssues()
+			currentMenu = issuesList
+			currentMenu.selected = 0
+		case 6:
+			// branches
+			y++
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching branches...")
+			termbox.Flush()
+
+			branches, err = FetchBranches(&repository)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching branches : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+				return
+			}
+
+			showBranches()
+			currentMenu = branchesList
+			currentMenu.selected = 0
+		case 7:
+			// releases
+			showReleases()
+			currentMenu = releasesList
+			currentMenu.selected = 0
+		case 8:
+			// churn
+			showChurn()
+			currentMenu = churnList
+			currentMenu.selected = 0
+		case 9:
+			// ownership
+			showOwnership()
+			currentMenu = ownershipList
+			currentMenu.selected = len(currentMenu.items) - 1
+		case 10:
+			// activity
+			showActivity()
+			currentMenu = activityList
+			currentMenu.selected = 0
+		case 11:
+			// pull request metrics
+			showPullMetrics()
+			currentMenu = pullMetricsList
+			currentMenu.selected = 0
+		case 12:
+			// dora metrics
+			showDORA()
+			currentMenu = doraList
+			currentMenu.selected = 0
+		case 13:
+			// workflow runs
+			showCI()
+			currentMenu = ciList
+			currentMenu.selected = 0
+		case 14:
+			// cycle the branch filter through the tracked branches
+			tracked := []string{""}
+			all, err := store.GetBranches(repository.ID)
+			if err != nil {
+				LogError(fmt.Errorf("error getting branches from db : %v", err))
+			}
+			for _, b := range all {
+				if b.Tracked {
+					tracked = append(tracked, b.Name)
+				}
+			}
+
+			next := 0
+			for i, name := range tracked {
+				if name == branchFilter {
+					next = (i + 1) % len(tracked)
+				}
+			}
+			setBranchFilter(tracked[next])
 		case len(currentMenu.items) - 1:
 			// back selected
 			currentMenu = currentMenu.parent
-			currentMenu.selected = 0
+			currentMenu.selected = 3
 		}
-	case "Commits":
+	case "Pull Requests":
 		switch currentMenu.selected {
+		case 0:
+			// cycle the state filter
+			for i, state := range pullStates {
+				if state == pullState {
+					pullState = pullStates[(i+1)%len(pullStates)]
+					break
+				}
+			}
+			showPullRequests()
 		case len(currentMenu.items) - 1:
 			// back selected
 			currentMenu = currentMenu.parent
-			currentMenu.selected = 0
+			currentMenu.selected = 4
 		}
-	case "Authors":
+	case "Issues":
 		switch currentMenu.selected {
+		case 0:
+			// cycle the state filter
+			switch issueFilter.State {
+			case "":
+				issueFilter.State = "open"
+			case "open":
+				issueFilter.State = "closed"
+			default:
+				issueFilter.State = ""
+			}
+			showIssues()
+		case 1:
+			issueFilter.Label = promptForText("Only show issues with the label, leave empty for all : ")
+			showIssues()
+		case 2:
+			issueFilter.Assignee = promptForText("Only show issues assigned to, leave empty for all : ")
+			showIssues()
 		case len(currentMenu.items) - 1:
 			// back selected
 			currentMenu = currentMenu.parent
-			currentMenu.selected = 0
+			currentMenu.selected = 5
 		}
-	}
-}
-
-func drawMenu(menu *Menu) {
-	x, y = 0, 0
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
-	drawText(0, 0, termbox.ColorWhite, termbox.ColorDefault, menu.title)
-	for i, item := range menu.items {
-		if i == menu.selected {
-			drawText(0, i+1, termbox.ColorBlack, termbox.ColorWhite, item)
-		} else {
-			drawText(0, i+1, termbox.ColorWhite, termbox.ColorDefault, item)
+	case "Branches":
+		switch currentMenu.selected {
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 6
+		default:
+			// toggle tracking of the selected branch
+			b := &branches[currentMenu.selected]
+			err := store.TrackBranch(repository.ID, b.Name, !b.Tracked)
+			if err != nil {
+				drawText(0, len(currentMenu.items)+2, termbox.ColorRed, termbox.ColorBlack, err.Error())
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+				return
+			}
+			b.Tracked = !b.Tracked
+			showBranches()
 		}
-	}
-	termbox.Flush()
-}
-
-var x, y = 0, 0
-
-func drawText(x, y int, fg, bg termbox.Attribute, text string) {
-	for i, c := range text {
-		termbox.SetCell(x+i, y, c, fg, bg)
-	}
-}
-
-func promptForDate() (*time.Time, error) {
-	x, y = 0, 0
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
-	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, "Please enter a date to pull commits since (YYYY-MM-DD)")
-	y++
-	l := "Leave emtpt to pull from first commit: "
-	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, l)
-	x = len(l)
-	termbox.Flush()
-
-	var t time.Time
+	case "Releases":
+		switch currentMenu.selected {
+		case 0:
+			// sync selected
+			y = len(currentMenu.items) + 2
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching tags and releases...")
+			termbox.Flush()
 
-	var input []rune
-	for {
-		ev := termbox.PollEvent()
-		if ev.Type == termbox.EventKey {
-			if ev.Key == termbox.KeyEnter {
-				break
-			} else if ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2 {
-				if len(input) > 0 {
-					input = input[:len(input)-1]
-				}
-			} else if ev.Ch != 0 {
-				input = append(input, ev.Ch)
+			_, err := SyncReleases(&repository)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching releases : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
 			}
-			drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, strings.Repeat(" ", len(input)+10))
-			drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, string(input))
-			termbox.Flush()
+			showReleases()
+		case 1:
+			// don't do anything, header row
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 7
+		default:
+			// commits of the selected release
+			showReleaseCommits(releases[currentMenu.selected-2])
+			currentMenu = releaseCommitsList
+			currentMenu.selected = 1
 		}
-	}
+	case "Churn":
+		switch currentMenu.selected {
+		case 0:
+			// fetch the changed files of the commits that don't have them yet
+			y = len(currentMenu.items) + 2
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching changed files...")
+			termbox.Flush()
 
-	date := string(input)
-	if date != "" {
-		t, err = time.Parse("2006-01-02", date)
-		if err != nil {
-			return nil, err
+			_, err := SyncCommitFiles(&repository, 0)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching changed files : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+			}
+			showChurn()
+		case 1:
+			// cycle the report
+			for i, report := range churnReports {
+				if report == churnReport {
+					churnReport = churnReports[(i+1)%len(churnReports)]
+					break
+				}
+			}
+			showChurn()
+		case 2:
+			// cycle the window
+			for i, weeks := range churnWeeksOptions {
+				if weeks == churnWeeks {
+					churnWeeks = churnWeeksOptions[(i+1)%len(churnWeeksOptions)]
+					break
+				}
+			}
+			showChurn()
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 8
+		}
+	case "Ownership":
+		switch currentMenu.selected {
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 9
+		}
+	case "Activity":
+		switch currentMenu.selected {
+		case 0:
+			activityTimezone = promptForText("Timezone to count the days and hours in, e.g. Europe/Paris, leave empty for UTC : ")
+			showActivity()
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 10
+		}
+	case "PR Metrics":
+		switch currentMenu.selected {
+		case 0:
+			// cycle the window of the metrics per author
+			for i, days := range trailingWindows {
+				if windowName(days) == pullMetricsWindow {
+					pullMetricsWindow = windowName(trailingWindows[(i+1)%len(trailingWindows)])
+					break
+				}
+			}
+			showPullMetrics()
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 11
+		}
+	case "DORA":
+		switch currentMenu.selected {
+		case 0:
+			// switch between weeks and months
+			if doraPeriod == "week" {
+				doraPeriod = "month"
+			} else {
+				doraPeriod = "week"
+			}
+			showDORA()
+		case 1:
+			// fetch the deployments and their statuses
+			y = len(currentMenu.items) + 2
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching deployments...")
+			termbox.Flush()
+
+			_, err := SyncDeployments(&repository)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching deployments : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+			}
+			showDORA()
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 12
+		}
+	case "CI":
+		switch currentMenu.selected {
+		case 0:
+			// fetch the workflow runs since the last sync
+			y = len(currentMenu.items) + 2
+			drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, "Fetching workflow runs...")
+			termbox.Flush()
+
+			_, err := SyncWorkflowRuns(&repository)
+			if err != nil {
+				drawText(0, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Error fetching workflow runs : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+			}
+			showCI()
+		case 1:
+			// cycle the report
+			for i, report := range ciReports {
+				if report == ciReport {
+					ciReport = ciReports[(i+1)%len(ciReports)]
+					break
+				}
+			}
+			showCI()
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 13
+		}
+	case "Release Commits":
+		switch currentMenu.selected {
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+		}
+	case "Commits":
+		switch currentMenu.selected {
+		case 0:
+			commitFilter.Author = promptForText("Only show commits by the author, leave empty for all : ")
+			showCommits()
+		case 1:
+			since := promptForText("Only show commits since the day (YYYY-MM-DD), leave empty for all : ")
+			until := promptForText("Only show commits until the day (YYYY-MM-DD), leave empty for all : ")
+			from, to, err := parseDays(since, until)
+			if err != nil {
+				drawText(0, 2, termbox.ColorRed, termbox.ColorBlack, err.Error())
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+				return
+			}
+			commitFilter.Since, commitFilter.Until = from, to
+			showCommits()
+		case 2:
+			message := promptForText("Only show commits whose message matches the regular expression, leave empty for all : ")
+			err := store.CheckRegexp(message)
+			if err != nil {
+				drawText(0, 2, termbox.ColorRed, termbox.ColorBlack, fmt.Sprintf("Invalid regular expression : %v", err))
+				termbox.Flush()
+				time.Sleep(2 * time.Second)
+				return
+			}
+			commitFilter.Message = message
+			showCommits()
+		case 3:
+			commitFilter = CommitFilter{}
+			showCommits()
+		case 4:
+			// don't do anything, header row
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 0
+		}
+	case "Authors":
+		switch currentMenu.selected {
+		case len(currentMenu.items) - 1:
+			// back selected
+			currentMenu = currentMenu.parent
+			currentMenu.selected = 0
+		}
+	}
+}
+
+// showRepos lists the tracked repositories matching the filter, with how much their counters changed over the last week
+func showRepos() {
+	repositories, err = store.ListRepos(repoFilter)
+	if err != nil {
+		LogError(fmt.Errorf("error getting repositories from db : %v", err))
+	}
+	language := "all"
+	if repoFilter.Language != "" {
+		language = repoFilter.Language
+	}
+	repos = []string{"- Language : " + language, fmt.Sprintf("- Min stars : %d", repoFilter.MinStars)}
+	repos = append(repos, fmt.Sprintf("ID\t\t\tName\t\t\tLanguage\t\t\tForks\t\t\tStars\t\t\tIssues\t\t\tWatchers"))
+	for _, r := range repositories {
+		trend, err := store.GetTrend(r.ID, WindowWeek)
+		if err != nil {
+			LogError(fmt.Errorf("error getting the trend of %s : %v", r.Name, err))
+			trend = &RepoTrend{}
+		}
+
+		repos = append(repos, fmt.Sprintf("%d\t\t\t%s\t\t\t%s\t\t\t%d%s\t\t\t%d%s\t\t\t%d\t\t\t%d",
+			r.ID, r.Name, r.Language,
+			r.ForksCount, trendMark(trend.ForksCount), r.StarsCount, trendMark(trend.StarsCount),
+			r.OpenIssuesCount, r.WatchersCount))
+	}
+	repos = append(repos, "Back")
+	reposList.items = repos
+}
+
+// showCommits lists the stored commits of the repository matching the filter, after the filters
+func showCommits() {
+	f := commitFilter
+	f.Branch = branchFilter
+	commits, err = store.ListCommits(repository.ID, f)
+	if err != nil {
+		LogError(fmt.Errorf("error getting commits from db : %v", err))
+	}
+
+	orAll := func(v string) string {
+		if v == "" {
+			return "all"
+		}
+		return v
+	}
+	dates := "all"
+	if f.Since != nil || f.Until != nil {
+		dates = ""
+		if f.Since != nil {
+			dates = f.Since.Format("2006-01-02")
+		}
+		dates += " - "
+		if f.Until != nil {
+			dates += f.Until.Format("2006-01-02")
+		}
+	}
+
+	commitsShort = []string{
+		"- Author : " + orAll(f.Author),
+		"- Dates : " + dates,
+		"- Message : " + orAll(f.Message),
+		"- Clear filters",
+	}
+	commitsShort = append(commitsShort, fmt.Sprintf("Date\t\t\t\tAuthor\t\t\t\tMessage"))
+	for _, c := range commits {
+		msg := c.Message
+		if len(msg) > 50 {
+			msg = msg[:50] + "..."
+		}
+
+		commitsShort = append(commitsShort, fmt.Sprintf("%s\t\t\t\t%s\t\t\t\t%s",
+			c.Date.Format("2006-01-02"), c.AuthorName, msg))
+	}
+	commitsShort = append(commitsShort, "Back")
+	commitsList.items = commitsShort
+}
+
+// showPullRequests lists the stored pull requests of the repository in the selected state
+func showPullRequests() {
+	label := "all"
+	if pullState != "" {
+		label = pullState
+	}
+
+	items := []string{"- State : " + label}
+	items = append(items, fmt.Sprintf("Number\t\tState\t\tCreated\t\t\tAuthor\t\t\tTitle"))
+
+	prs, err := store.ListPullRequests(repository.ID, PullRequestFilter{State: pullState})
+	if err != nil {
+		LogError(fmt.Errorf("error getting pull requests from db : %v", err))
+	}
+	for _, pr := range prs {
+		title := pr.Title
+		if len(title) > 50 {
+			title = title[:50] + "..."
+		}
+		if pr.Draft {
+			title = "[draft] " + title
+		}
+
+		items = append(items, fmt.Sprintf("#%d\t\t%s\t\t%s\t\t\t%s\t\t\t%s",
+			pr.Number, pr.State, pr.Created.Format("2006-01-02"), pr.Author, title))
+	}
+	items = append(items, "Back")
+	pullsList.items = items
+}
+
+// showIssues lists the stored issues of the repository matching the filter, after the filters and their count
+func showIssues() {
+	orAll := func(v string) string {
+		if v == "" {
+			return "all"
+		}
+		return v
+	}
+
+	issues, err := store.ListIssues(repository.ID, issueFilter)
+	if err != nil {
+		LogError(fmt.Errorf("error getting issues from db : %v", err))
+	}
+
+	items := []string{
+		"- State : " + orAll(issueFilter.State),
+		"- Label : " + orAll(issueFilter.Label),
+		"- Assignee : " + orAll(issueFilter.Assignee),
+		fmt.Sprintf("%d issues", len(issues)),
+		fmt.Sprintf("Number\t\tState\t\tCreated\t\t\tComments\t\tLabels\t\t\tTitle"),
+	}
+	for _, i := range issues {
+		title := i.Title
+		if len(title) > 50 {
+			title = title[:50] + "..."
+		}
+
+		items = append(items, fmt.Sprintf("#%d\t\t%s\t\t%s\t\t\t%d\t\t%s\t\t\t%s",
+			i.Number, i.State, i.Created.Format("2006-01-02"), i.Comments, strings.Join(i.Labels, ","), title))
+	}
+	items = append(items, "Back")
+	issuesList.items = items
+}
+
+// showBranches lists the branches of the repository, marking the tracked ones
+func showBranches() {
+	items := []string{}
+	for _, b := range branches {
+		mark := "[ ]"
+		if b.Tracked {
+			mark = "[x]"
+		}
+		name := b.Name
+		if b.Default {
+			name += " (default)"
+		}
+		items = append(items, fmt.Sprintf("%s %s", mark, name))
+	}
+	items = append(items, "Back")
+	branchesList.items = items
+}
+
+// showReleases lists the stored releases of the repository, latest first, with the time since the previous one
+func showReleases() {
+	releases, err = store.GetReleases(repository.ID)
+	if err != nil {
+		LogError(fmt.Errorf("error getting releases from db : %v", err))
+	}
+
+	items := []string{"- Sync", fmt.Sprintf("Tag\t\t\tPublished\t\tSince Previous\t\tCommits\t\tDownloads\t\tName")}
+	for _, r := range releases {
+		published, since := "draft", ""
+		if r.Published != nil {
+			published = r.Published.Format("2006-01-02")
+		}
+		if r.SincePrevious > 0 {
+			since = formatDays(r.SincePrevious)
+		}
+		name := r.Name
+		if r.Prerelease {
+			name = "[prerelease] " + name
+		}
+
+		items = append(items, fmt.Sprintf("%s\t\t\t%s\t\t%s\t\t\t%d\t\t%d\t\t\t%s",
+			r.Tag, published, since, r.Commits, r.Downloads, name))
+	}
+	items = append(items, "Back")
+	releasesList.items = items
+}
+
+// showReleaseCommits lists the commits shipped in the release since the previous one
+func showReleaseCommits(r Release) {
+	commits, err := store.GetReleaseCommits(repository.ID, r.Tag)
+	if err != nil {
+		LogError(fmt.Errorf("error getting release commits from db : %v", err))
+	}
+
+	items := []string{}
+	if r.PreviousTag != "" {
+		items = append(items, fmt.Sprintf("%d commits in %s since %s", len(commits), r.Tag, r.PreviousTag))
+	} else {
+		items = append(items, fmt.Sprintf("%s is the first release", r.Tag))
+	}
+	for _, c := range commits {
+		msg, _, _ := strings.Cut(c.Message, "\n")
+		if len(msg) > 50 {
+			msg = msg[:50] + "..."
+		}
+
+		items = append(items, fmt.Sprintf("%s\t\t\t\t%s\t\t\t\t%s", c.Date.Format("2006-01-02"), c.AuthorName, msg))
+	}
+	items = append(items, "Back")
+	releaseCommitsList.items = items
+}
+
+// showChurn shows the selected churn report of the repository over the selected window
+func showChurn() {
+	window := "all time"
+	if churnWeeks > 0 {
+		window = fmt.Sprintf("%d weeks", churnWeeks)
+	}
+	items := []string{"- Fetch changed files", "- Report : " + churnReport, "- Window : " + window}
+
+	since := churnSince(churnWeeks, time.Now())
+	changes, err := store.GetFileChanges(repository.ID, since)
+	if err != nil {
+		LogError(fmt.Errorf("error getting file changes from db : %v", err))
+	}
+
+	switch churnReport {
+	case "directories":
+		items = append(items, fmt.Sprintf("Week\t\t\tCommits\t\tAdded\t\tRemoved\t\tDirectory"))
+		for _, d := range ChurnByDirectory(changes, 1) {
+			items = append(items, fmt.Sprintf("%s\t\t%d\t\t\t%d\t\t%d\t\t\t%s",
+				d.Week.Format("2006-01-02"), d.Commits, d.Additions, d.Deletions, d.Directory))
+		}
+	case "files":
+		items = append(items, fmt.Sprintf("Commits\t\tAuthors\t\tAdded\t\tRemoved\t\tFile"))
+		for _, f := range HottestFiles(changes, 50) {
+			items = append(items, fmt.Sprintf("%d\t\t\t%d\t\t\t%d\t\t%d\t\t\t%s",
+				f.Commits, f.Authors, f.Additions, f.Deletions, f.Filename))
+		}
+	case "authors":
+		authors, err := store.GetAuthorChurn(repository.ID, since)
+		if err != nil {
+			LogError(fmt.Errorf("error getting author churn from db : %v", err))
+		}
+		items = append(items, fmt.Sprintf("Commits\t\tAdded\t\tRemoved\t\tName\t\t\t\tEmail"))
+		for _, a := range authors {
+			items = append(items, fmt.Sprintf("%d\t\t\t%d\t\t%d\t\t\t%s\t\t\t\t%s",
+				a.Commits, a.Additions, a.Deletions, a.Name, a.Email))
+		}
+	case "coupling":
+		items = append(items, fmt.Sprintf("Together\tDegree\t\tFiles"))
+		for _, p := range FileCouplings(changes, 3, 50) {
+			items = append(items, fmt.Sprintf("%d\t\t\t%.0f%%\t\t%s <-> %s",
+				p.Together, p.Degree*100, p.FileA, p.FileB))
+		}
+	}
+	items = append(items, "Back")
+	churnList.items = items
+}
+
+// showOwnership shows the bus factors of the repository over each window, the share of its top authors,
+// the authors gone inactive and the owners of its directories
+func showOwnership() {
+	o, err := GetOwnership(repository.ID, branchFilter, 90, 1, 3)
+	if err != nil {
+		LogError(fmt.Errorf("error getting ownership : %v", err))
+		ownershipList.items = []string{"Error getting ownership : " + err.Error(), "Back"}
+		return
+	}
+
+	items := []string{fmt.Sprintf("Window\t\tCommits\t\tAuthors\t\tBus Factor 50%%\t\tBus Factor 80%%")}
+	for _, w := range o.Windows {
+		items = append(items, fmt.Sprintf("%s\t\t\t%d\t\t\t%d\t\t\t%d\t\t\t\t\t%d",
+			w.Window, w.Commits, w.Authors, w.BusFactor50, w.BusFactor80))
+	}
+
+	header := "Top Authors\t\t"
+	for _, w := range o.Windows {
+		header += "\t\t" + w.Window
+	}
+	items = append(items, "", header)
+	all := o.Windows[len(o.Windows)-1].Shares
+	if len(all) > 10 {
+		all = all[:10]
+	}
+	for _, a := range all {
+		line := a.Name
+		for _, w := range o.Windows {
+			share := "-"
+			for _, s := range w.Shares {
+				if s.ContributorID == a.ContributorID {
+					share = formatShare(s.Share)
+				}
+			}
+			line += "\t\t" + share
+		}
+		items = append(items, line)
+	}
+
+	items = append(items, "", fmt.Sprintf("Inactive for %d days\t\tCommits\t\tShare\t\tLast Commit", o.InactiveDays))
+	for _, a := range o.Inactive {
+		items = append(items, fmt.Sprintf("%s\t\t\t\t%d\t\t\t%s\t\t%s",
+			a.Name, a.Commits, formatShare(a.Share), a.LastCommit.Format("2006-01-02")))
+	}
+
+	if len(o.Directories) > 0 {
+		items = append(items, "", fmt.Sprintf("Directory\t\t\tCommits\t\tOwners"))
+		for _, d := range o.Directories {
+			items = append(items, fmt.Sprintf("%s\t\t\t%d\t\t\t%s", d.Directory, d.Commits, formatOwners(d.Owners)))
+		}
+	}
+	items = append(items, "Back")
+	ownershipList.items = items
+}
+
+// showActivity shows the streaks and gaps between the commits of the repository, drawActivity draws its heatmaps
+func showActivity() {
+	activity = nil
+	loc, err := loadTimezone(activityTimezone)
+	if err != nil {
+		activityTimezone = ""
+		activityList.items = []string{"- Timezone : UTC", err.Error(), "Back"}
+		return
+	}
+
+	activity, err = GetActivity(repository.ID, branchFilter, loc, 52)
+	if err != nil {
+		LogError(fmt.Errorf("error getting activity : %v", err))
+		activityList.items = []string{"- Timezone : " + loc.String(), "Error getting activity : " + err.Error(), "Back"}
+		return
+	}
+
+	span := func(s *ActivitySpan) string {
+		if s == nil {
+			return "none"
+		}
+		return fmt.Sprintf("%d days, %s to %s", s.Days, s.From.Format("2006-01-02"), s.To.Format("2006-01-02"))
+	}
+	activityList.items = []string{
+		"- Timezone : " + activity.Timezone,
+		fmt.Sprintf("%d commits over %d active days", activity.Commits, activity.ActiveDays),
+		"Longest streak : " + span(activity.LongestStreak),
+		"Longest gap : " + span(activity.LongestGap),
+		fmt.Sprintf("Current streak : %d days, last commit %d days ago", activity.CurrentStreak, activity.DaysSinceLast),
+		"Back",
+	}
+}
+
+// heatColor returns the color of a heatmap cell counting count commits, max being the most of any cell
+func heatColor(count, max int) termbox.Attribute {
+	if count == 0 || max == 0 {
+		return heatColors[0]
+	}
+	level := 1 + (count-1)*(len(heatColors)-1)/max
+	return heatColors[level]
+}
+
+// drawActivity draws the commits by weekday and hour, then by week over the last year
+func drawActivity(top int) {
+	if activity == nil {
+		return
+	}
+
+	max := 0
+	for _, hours := range activity.Heatmap {
+		for _, count := range hours {
+			if count > max {
+				max = count
+			}
+		}
+	}
+	for h := 0; h < 24; h += 3 {
+		drawText(4+h*3, top, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("%02d", h))
+	}
+	// monday first
+	for i := 1; i <= 7; i++ {
+		d := time.Weekday(i % 7)
+		drawText(0, top+i, termbox.ColorWhite, termbox.ColorDefault, d.String()[:3])
+		for h, count := range activity.Heatmap[d] {
+			drawText(4+h*3, top+i, termbox.ColorWhite, heatColor(count, max), "  ")
+		}
+	}
+
+	top += 9
+	max = 0
+	for _, w := range activity.Weeks {
+		if w.Commits > max {
+			max = w.Commits
+		}
+	}
+	drawText(0, top, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Commits per week over the last %d weeks, %d at most", len(activity.Weeks), max))
+	for i, w := range activity.Weeks {
+		drawText(i, top+1, termbox.ColorWhite, heatColor(w.Commits, max), " ")
+	}
+}
+
+// showPullMetrics shows the delivery metrics of the repository over each window, and of its authors over the selected one
+func showPullMetrics() {
+	items := []string{"- Authors over : " + pullMetricsWindow}
+	m, err := GetPullMetrics(repository.ID)
+	if err != nil {
+		LogError(fmt.Errorf("error getting pull request metrics : %v", err))
+		pullMetricsList.items = append(items, "Error getting pull request metrics : "+err.Error(), "Back")
+		return
+	}
+
+	header := "PRs\tMerged\tNo Review\tFirst Review\tApproval\tMerge\t\tRounds"
+	line := func(s ReviewStats) string {
+		return fmt.Sprintf("%d\t\t%d\t\t%s\t\t%s\t\t\t%s\t\t%s\t\t%.1f",
+			s.PullRequests, s.Merged, formatShare(s.MergedWithoutReviewShare), formatHours(s.TimeToFirstReview.MedianHours),
+			formatHours(s.TimeToApproval.MedianHours), formatHours(s.TimeToMerge.MedianHours), s.ReviewRounds)
+	}
+
+	items = append(items, "Window\t"+header)
+	for _, w := range m.Windows {
+		items = append(items, w.Window+"\t\t"+line(w.Stats))
+	}
+
+	w := m.window(pullMetricsWindow)
+	sizes := []string{}
+	for _, size := range pullSizes {
+		sizes = append(sizes, fmt.Sprintf("%s %d", size.Name, w.Stats.Sizes[size.Name]))
+	}
+	items = append(items, "", "Sizes over "+w.Window+" : "+strings.Join(sizes, ", "))
+
+	items = append(items, "", "Author\t\t\t"+header)
+	for _, a := range w.Authors {
+		items = append(items, a.Author+"\t\t\t"+line(a.ReviewStats))
+	}
+	items = append(items, "Back")
+	pullMetricsList.items = items
+}
+
+// showDORA shows the deployment frequency, lead time for changes, change failure rate and time to restore of
+// the repository period by period
+func showDORA() {
+	items := []string{"- Period : " + doraPeriod, "- Fetch deployments"}
+	n := 12
+	if doraPeriod == "month" {
+		n = 6
+	}
+	d, err := GetDORA(&repository, "", doraPeriod, n)
+	if err != nil {
+		LogError(fmt.Errorf("error getting dora metrics : %v", err))
+		doraList.items = append(items, "Error getting dora metrics : "+err.Error(), "Back")
+		return
+	}
+
+	source := "From " + d.Source
+	if d.Environment != "" {
+		source += ", environment " + d.Environment
+	}
+	items = append(items, "", source, "Period\t\t\tDeploys\t\tLead Time\tP90\t\tFailure Rate\tRestore")
+	line := func(name string, p DORAPeriod) string {
+		rate, restore := "-", "-"
+		if p.ChangeFailureRate != nil {
+			rate = formatShare(*p.ChangeFailureRate)
+		}
+		if p.TimeToRestore.Count > 0 {
+			restore = formatHours(p.TimeToRestore.MedianHours)
+		}
+		lead, p90 := "-", "-"
+		if p.LeadTime.Count > 0 {
+			lead, p90 = formatHours(p.LeadTime.MedianHours), formatHours(p.LeadTime.P90Hours)
+		}
+		return fmt.Sprintf("%s\t\t%d\t\t%s\t\t%s\t\t%s\t\t%s", name, p.Deployments, lead, p90, rate, restore)
+	}
+	for _, p := range d.Periods {
+		items = append(items, line(p.Start.Format("2006-01-02"), p))
+	}
+	items = append(items, line("total\t", d.Total))
+	items = append(items, "Back")
+	doraList.items = items
+}
+
+// showCI shows how the workflows of the repository went over the last days, the flaky runs or the commits
+// that broke the default branch
+func showCI() {
+	items := []string{"- Fetch workflow runs", "- Report : " + ciReport}
+	h, err := GetCIHealth(&repository, ciDays)
+	if err != nil {
+		LogError(fmt.Errorf("error getting ci health : %v", err))
+		ciList.items = append(items, "Error getting ci health : "+err.Error(), "Back")
+		return
+	}
+
+	switch ciReport {
+	case "workflows":
+		items = append(items, fmt.Sprintf("Runs\t\tSuccess\t\tRe-run\t\tFlaky\t\tP50\t\t\tP95\t\t\tWorkflow"))
+		for _, w := range h.Workflows {
+			rate := "-"
+			if w.SuccessRate != nil {
+				rate = formatShare(*w.SuccessRate)
+			}
+			items = append(items, fmt.Sprintf("%d\t\t\t%s\t\t%d\t\t\t%d\t\t\t%s\t\t%s\t\t%s",
+				w.Runs, rate, w.ReRun, w.Flaky, formatMinutes(w.Durations.P50Minutes), formatMinutes(w.Durations.P95Minutes), w.Workflow))
+		}
+	case "flaky":
+		items = append(items, fmt.Sprintf("Failed\t\t\t\t\tRun\t\t\t\tPassed By\t\tBranch\t\t\tWorkflow"))
+		for _, f := range h.Flaky {
+			passed := "re-run"
+			if !f.ReRun {
+				passed = "another run"
+			}
+			items = append(items, fmt.Sprintf("%s\t\t%d\t\t%s\t\t\t%s\t\t\t%s",
+				f.Failed.Format("2006-01-02 15:04"), f.RunID, passed, f.Branch, f.Workflow))
+		}
+	case "breakages":
+		items = append(items, fmt.Sprintf("Broken\t\t\t\t\tFor\t\t\tWorkflow\t\t\tAuthor\t\t\tCommit"))
+		for _, b := range h.Breakages {
+			brokenFor := "still"
+			if b.Fixed != nil {
+				brokenFor = formatHours(b.Fixed.Sub(b.Broken).Hours())
+			}
+			msg, _, _ := strings.Cut(b.Message, "\n")
+			if len(msg) > 50 {
+				msg = msg[:50] + "..."
+			}
+			if msg == "" {
+				msg = b.HeadSHA
+			}
+			items = append(items, fmt.Sprintf("%s\t\t%s\t\t%s\t\t\t%s\t\t\t%s",
+				b.Broken.Format("2006-01-02 15:04"), brokenFor, b.Workflow, b.Author, msg))
+		}
+	}
+	items = append(items, "Back")
+	ciList.items = items
+}
+
+// setBranchFilter shows the commits and top authors of the given branch, all branches if it's empty
+func setBranchFilter(name string) {
+	branchFilter = name
+	label := "all"
+	if name != "" {
+		label = name
+	}
+	repoMenu.items[14] = "- Branch : " + label
+}
+
+// drawMenu draws the title and the items of the menu matching its search, scrolled so the selected one is on the
+// screen, and the search on the last line
+func drawMenu(menu *Menu) {
+	x, y = 0, 0
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	drawText(0, 0, termbox.ColorWhite, termbox.ColorDefault, menu.title)
+
+	shown := menu.shown()
+	_, height := termbox.Size()
+	// rows left once the title and the search line are drawn
+	rows := max(1, height-2)
+	pos := 0
+	for i, index := range shown {
+		if index == menu.selected {
+			pos = i
+		}
+	}
+	if pos < menu.offset {
+		menu.offset = pos
+	}
+	if pos >= menu.offset+rows {
+		menu.offset = pos - rows + 1
+	}
+	menu.offset = max(0, min(menu.offset, len(shown)-rows))
+
+	end := min(len(shown), menu.offset+rows)
+	for row, index := range shown[menu.offset:end] {
+		if index == menu.selected {
+			drawText(0, row+1, termbox.ColorBlack, termbox.ColorWhite, menu.items[index])
+		} else {
+			drawText(0, row+1, termbox.ColorWhite, termbox.ColorDefault, menu.items[index])
+		}
+	}
+
+	switch {
+	case menu.searching:
+		drawText(0, height-1, termbox.ColorYellow, termbox.ColorDefault, "/"+menu.search+"_")
+	case menu.search != "":
+		drawText(0, height-1, termbox.ColorYellow, termbox.ColorDefault,
+			fmt.Sprintf("/%s : %d of %d, / to change, esc to clear", menu.search, len(shown)-1, len(menu.items)-1))
+	case len(shown) > rows:
+		drawText(0, height-1, termbox.ColorYellow, termbox.ColorDefault,
+			fmt.Sprintf("%d-%d of %d, / to search", menu.offset+1, end, len(shown)))
+	}
+
+	if menu.draw != nil {
+		menu.draw(end - menu.offset + 2)
+	}
+	termbox.Flush()
+}
+
+var x, y = 0, 0
+
+func drawText(x, y int, fg, bg termbox.Attribute, text string) {
+	for i, c := range text {
+		termbox.SetCell(x+i, y, c, fg, bg)
+	}
+}
+
+func promptForDate() (*time.Time, error) {
+	x, y = 0, 0
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, "Please enter a date to pull commits since (YYYY-MM-DD)")
+	y++
+	l := "Leave emtpt to pull from first commit: "
+	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, l)
+	x = len(l)
+	termbox.Flush()
+
+	var t time.Time
+
+	var input []rune
+	for {
+		ev := termbox.PollEvent()
+		if ev.Type == termbox.EventKey {
+			if ev.Key == termbox.KeyEnter {
+				break
+			} else if ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2 {
+				if len(input) > 0 {
+					input = input[:len(input)-1]
+				}
+			} else if ev.Key == termbox.KeySpace {
+				input = append(input, ' ')
+			} else if ev.Ch != 0 {
+				input = append(input, ev.Ch)
+			}
+			drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, strings.Repeat(" ", len(input)+10))
+			drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, string(input))
+			termbox.Flush()
+		}
+	}
+
+	date := string(input)
+	if date != "" {
+		t, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -379,6 +1553,8 @@ func promptForRepoURL() string {
 				if len(input) > 0 {
 					input = input[:len(input)-1]
 				}
+			} else if ev.Key == termbox.KeySpace {
+				input = append(input, ' ')
 			} else if ev.Ch != 0 {
 				input = append(input, ev.Ch)
 			}
@@ -399,16 +1575,102 @@ func promptForRepoURL() string {
 	return url
 }
 
-func startCRON() {
-	interval := os.Getenv("INTERVAL")
-	i := uint64(1)
-	if interval != "" {
-		val, err := strconv.Atoi(interval)
-		if err != nil {
-			LogError(fmt.Errorf("error parsing INTERVAL env variable : %v", err))
+// promptForText asks the user for a line of input
+func promptForText(prompt string) string {
+	x, y = 0, 0
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, prompt)
+	termbox.Flush()
+
+	var input []rune
+	for {
+		ev := termbox.PollEvent()
+		if ev.Type == termbox.EventKey {
+			if ev.Key == termbox.KeyEnter {
+				break
+			} else if ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2 {
+				if len(input) > 0 {
+					input = input[:len(input)-1]
+				}
+			} else if ev.Key == termbox.KeySpace {
+				input = append(input, ' ')
+			} else if ev.Ch != 0 {
+				input = append(input, ev.Ch)
+			}
+			drawText(x, 1, termbox.ColorWhite, termbox.ColorDefault, strings.Repeat(" ", len(input)+10))
+			drawText(x, 1, termbox.ColorWhite, termbox.ColorDefault, string(input))
+			termbox.Flush()
 		}
-		i = uint64(val)
 	}
-	gocron.Every(i).Hours().Do(RefreshRepos)
+
+	return strings.TrimSpace(string(input))
+}
+
+// login runs the oauth device flow and stores the resulting token locally
+func login() {
+	host := DefaultHost
+	if len(Hosts()) > 1 {
+		// ask which host to login to
+		name := promptForText("Please enter the host to login to, leave empty for github.com : ")
+		if name != "" {
+			h, err := LookupHost(name)
+			if err != nil {
+				drawText(0, 2, termbox.ColorRed, termbox.ColorBlack, err.Error())
+				termbox.Flush()
+				time.Sleep(3 * time.Second)
+				return
+			}
+			host = *h
+		}
+	}
+
+	x, y = 0, 0
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+
+	clientID := host.Getenv("GITHUB_CLIENT_ID")
+	if clientID == "" {
+		drawText(x, y, termbox.ColorRed, termbox.ColorBlack, fmt.Sprintf("No oauth client id set for %s, unable to login", host.Name))
+		termbox.Flush()
+		time.Sleep(3 * time.Second)
+		return
+	}
+
+	code, err := RequestDeviceCode(host, clientID)
+	if err != nil {
+		LogError(err)
+		drawText(x, y, termbox.ColorRed, termbox.ColorBlack, err.Error())
+		termbox.Flush()
+		time.Sleep(3 * time.Second)
+		return
+	}
+
+	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, fmt.Sprintf("Open %s and enter the code : %s", code.VerificationURI, code.UserCode))
+	y++
+	drawText(x, y, termbox.ColorWhite, termbox.ColorDefault, "Waiting for authorization...")
+	y++
+	termbox.Flush()
+
+	token, err := PollDeviceToken(host, clientID, code)
+	if err != nil {
+		LogError(err)
+		drawText(x, y, termbox.ColorRed, termbox.ColorBlack, err.Error())
+		termbox.Flush()
+		time.Sleep(3 * time.Second)
+		return
+	}
+
+	err = StoreToken(host, token)
+	if err != nil {
+		LogError(fmt.Errorf("error storing token : %v", err))
+	}
+	SetCredentials(host.Name, StaticToken(token))
+
+	drawText(x, y, termbox.ColorGreen, termbox.ColorDefault, "Logged in successfully")
+	termbox.Flush()
+	time.Sleep(2 * time.Second)
+}
+
+func startCRON() {
+	gocron.Every(refreshInterval()).Hours().Do(RefreshRepos, context.Background(), refreshWorkers())
 	gocron.Start()
 }
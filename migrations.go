@@ -0,0 +1,857 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Migration is a numbered change to the database schema. Up applies it and Down reverts it,
+// SQLiteUp and SQLiteDown replace them on sqlite when the statements differ between backends.
+type Migration struct {
+	Version int
+	Name    string
+
+	Up   []string
+	Down []string
+
+	SQLiteUp   []string
+	SQLiteDown []string
+}
+
+// MigrationState tells whether a migration has been applied to the database
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// migrations lists every schema change in order, new migrations are appended with the next version.
+// Never edit a migration that has been released, add a new one instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// the tables may already exist in databases created before migrations were introduced
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS repositories (
+				id SERIAL PRIMARY KEY,
+				name varchar(255) NOT NULL,
+				description varchar(255),
+				url varchar(255) UNIQUE,
+				language varchar(255),
+				forks_count int,
+				stars_count int,
+				open_issues_count int,
+				watchers_count int,
+				created_at timestamp,
+				pushed_at timestamp,
+				updated_at timestamp,
+				host varchar(255) NOT NULL DEFAULT 'github.com'
+			)`,
+			`ALTER TABLE repositories ADD COLUMN IF NOT EXISTS host varchar(255) NOT NULL DEFAULT 'github.com'`,
+			`CREATE TABLE IF NOT EXISTS commits (
+				sha varchar(255) PRIMARY KEY,
+				message text,
+				url varchar(255) UNIQUE,
+				author_name varchar(255),
+				author_email varchar(255),
+				repository_id INTEGER,
+				date timestamp,
+				FOREIGN KEY (repository_id) REFERENCES repositories(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS http_cache (
+				url varchar(1024) PRIMARY KEY,
+				etag varchar(255),
+				last_modified varchar(255),
+				updated_at timestamp
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE http_cache`,
+			`DROP TABLE commits`,
+			`DROP TABLE repositories`,
+		},
+		SQLiteUp: []string{
+			`CREATE TABLE IF NOT EXISTS repositories (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name varchar(255) NOT NULL,
+				description varchar(255),
+				url varchar(255) UNIQUE,
+				language varchar(255),
+				forks_count int,
+				stars_count int,
+				open_issues_count int,
+				watchers_count int,
+				created_at timestamp,
+				pushed_at timestamp,
+				updated_at timestamp,
+				host varchar(255) NOT NULL DEFAULT 'github.com'
+			)`,
+			`CREATE TABLE IF NOT EXISTS commits (
+				sha varchar(255) PRIMARY KEY,
+				message text,
+				url varchar(255) UNIQUE,
+				author_name varchar(255),
+				author_email varchar(255),
+				repository_id INTEGER,
+				date timestamp,
+				FOREIGN KEY (repository_id) REFERENCES repositories(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS http_cache (
+				url varchar(1024) PRIMARY KEY,
+				etag varchar(255),
+				last_modified varchar(255),
+				updated_at timestamp
+			)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "sync cursors",
+		Up: []string{
+			`CREATE TABLE sync_cursors (
+				repository_id INTEGER PRIMARY KEY REFERENCES repositories(id),
+				newest_sha varchar(255) NOT NULL DEFAULT '',
+				newest_date timestamp,
+				backfill_url varchar(1024) NOT NULL DEFAULT '',
+				updated_at timestamp
+			)`,
+			// repositories pulled before cursors existed continue from their newest stored commit
+			`INSERT INTO sync_cursors (repository_id, newest_sha, newest_date, backfill_url, updated_at)
+				SELECT c.repository_id, c.sha, c.date, '', c.date FROM commits c
+				WHERE c.date = (SELECT max(date) FROM commits WHERE repository_id = c.repository_id)
+				AND c.sha = (SELECT max(sha) FROM commits WHERE repository_id = c.repository_id AND date = c.date)`,
+		},
+		Down: []string{
+			`DROP TABLE sync_cursors`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "branches",
+		Up: []string{
+			`ALTER TABLE repositories ADD COLUMN default_branch varchar(255) NOT NULL DEFAULT ''`,
+			`CREATE TABLE branches (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				name varchar(255) NOT NULL,
+				head_sha varchar(255) NOT NULL DEFAULT '',
+				is_default boolean NOT NULL DEFAULT false,
+				tracked boolean NOT NULL DEFAULT false,
+				updated_at timestamp,
+				PRIMARY KEY (repository_id, name)
+			)`,
+			`CREATE TABLE commit_branches (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				branch varchar(255) NOT NULL,
+				sha varchar(255) NOT NULL REFERENCES commits(sha),
+				PRIMARY KEY (repository_id, branch, sha)
+			)`,
+			// cursors are kept per branch, the default branch has an empty name
+			`CREATE TABLE sync_cursors_new (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				branch varchar(255) NOT NULL DEFAULT '',
+				newest_sha varchar(255) NOT NULL DEFAULT '',
+				newest_date timestamp,
+				backfill_url varchar(1024) NOT NULL DEFAULT '',
+				updated_at timestamp,
+				PRIMARY KEY (repository_id, branch)
+			)`,
+			`INSERT INTO sync_cursors_new (repository_id, branch, newest_sha, newest_date, backfill_url, updated_at)
+				SELECT repository_id, '', newest_sha, newest_date, backfill_url, updated_at FROM sync_cursors`,
+			`DROP TABLE sync_cursors`,
+			`ALTER TABLE sync_cursors_new RENAME TO sync_cursors`,
+		},
+		Down: []string{
+			`CREATE TABLE sync_cursors_old (
+				repository_id INTEGER PRIMARY KEY REFERENCES repositories(id),
+				newest_sha varchar(255) NOT NULL DEFAULT '',
+				newest_date timestamp,
+				backfill_url varchar(1024) NOT NULL DEFAULT '',
+				updated_at timestamp
+			)`,
+			`INSERT INTO sync_cursors_old (repository_id, newest_sha, newest_date, backfill_url, updated_at)
+				SELECT repository_id, newest_sha, newest_date, backfill_url, updated_at FROM sync_cursors WHERE branch = ''`,
+			`DROP TABLE sync_cursors`,
+			`ALTER TABLE sync_cursors_old RENAME TO sync_cursors`,
+			`DROP TABLE commit_branches`,
+			`DROP TABLE branches`,
+			`ALTER TABLE repositories DROP COLUMN default_branch`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "pull requests",
+		Up: []string{
+			`CREATE TABLE pull_requests (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				number int NOT NULL,
+				title text,
+				author varchar(255),
+				state varchar(16) NOT NULL,
+				draft boolean NOT NULL DEFAULT false,
+				base_ref varchar(255),
+				head_ref varchar(255),
+				created_at timestamp,
+				updated_at timestamp,
+				merged_at timestamp,
+				closed_at timestamp,
+				additions int,
+				deletions int,
+				PRIMARY KEY (repository_id, number)
+			)`,
+			`CREATE TABLE pull_request_commits (
+				repository_id INTEGER NOT NULL,
+				number int NOT NULL,
+				sha varchar(255) NOT NULL,
+				PRIMARY KEY (repository_id, number, sha),
+				FOREIGN KEY (repository_id, number) REFERENCES pull_requests(repository_id, number)
+			)`,
+			// how far resources listed by update time, like pull requests, have been synced
+			`CREATE TABLE resource_cursors (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				resource varchar(64) NOT NULL,
+				synced_until timestamp,
+				updated_at timestamp,
+				PRIMARY KEY (repository_id, resource)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE resource_cursors`,
+			`DROP TABLE pull_request_commits`,
+			`DROP TABLE pull_requests`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "issues",
+		Up: []string{
+			`CREATE TABLE issues (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				number int NOT NULL,
+				title text,
+				state varchar(16) NOT NULL,
+				author varchar(255),
+				milestone varchar(255) NOT NULL DEFAULT '',
+				comments int NOT NULL DEFAULT 0,
+				created_at timestamp,
+				updated_at timestamp,
+				closed_at timestamp,
+				PRIMARY KEY (repository_id, number)
+			)`,
+			`CREATE TABLE issue_labels (
+				repository_id INTEGER NOT NULL,
+				number int NOT NULL,
+				label varchar(255) NOT NULL,
+				PRIMARY KEY (repository_id, number, label),
+				FOREIGN KEY (repository_id, number) REFERENCES issues(repository_id, number)
+			)`,
+			`CREATE TABLE issue_assignees (
+				repository_id INTEGER NOT NULL,
+				number int NOT NULL,
+				login varchar(255) NOT NULL,
+				PRIMARY KEY (repository_id, number, login),
+				FOREIGN KEY (repository_id, number) REFERENCES issues(repository_id, number)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE issue_assignees`,
+			`DROP TABLE issue_labels`,
+			`DROP TABLE issues`,
+			`DELETE FROM resource_cursors WHERE resource = 'issues'`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "releases",
+		Up: []string{
+			`CREATE TABLE tags (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				name varchar(255) NOT NULL,
+				sha varchar(255) NOT NULL,
+				PRIMARY KEY (repository_id, name)
+			)`,
+			`CREATE TABLE releases (
+				id bigint PRIMARY KEY,
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				tag varchar(255) NOT NULL,
+				name varchar(255),
+				target_sha varchar(255),
+				draft boolean NOT NULL DEFAULT false,
+				prerelease boolean NOT NULL DEFAULT false,
+				published_at timestamp,
+				author varchar(255),
+				previous_tag varchar(255) NOT NULL DEFAULT '',
+				commits_linked boolean NOT NULL DEFAULT false
+			)`,
+			`CREATE INDEX releases_repository_tag ON releases (repository_id, tag)`,
+			`CREATE TABLE release_assets (
+				release_id bigint NOT NULL REFERENCES releases(id),
+				repository_id INTEGER NOT NULL,
+				name varchar(255) NOT NULL,
+				download_count int NOT NULL DEFAULT 0,
+				PRIMARY KEY (release_id, name)
+			)`,
+			`CREATE TABLE release_commits (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				tag varchar(255) NOT NULL,
+				sha varchar(255) NOT NULL,
+				PRIMARY KEY (repository_id, tag, sha)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE release_commits`,
+			`DROP TABLE release_assets`,
+			`DROP TABLE releases`,
+			`DROP TABLE tags`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "repository snapshots",
+		Up: []string{
+			`CREATE TABLE repository_snapshots (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				taken_at timestamp NOT NULL,
+				stars_count int NOT NULL DEFAULT 0,
+				forks_count int NOT NULL DEFAULT 0,
+				open_issues_count int NOT NULL DEFAULT 0,
+				watchers_count int NOT NULL DEFAULT 0,
+				PRIMARY KEY (repository_id, taken_at)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE repository_snapshots`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "contributors",
+		Up: []string{
+			`CREATE TABLE contributors (
+				id SERIAL PRIMARY KEY,
+				name varchar(255) NOT NULL DEFAULT '',
+				email varchar(255) NOT NULL DEFAULT '',
+				login varchar(255) NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE contributor_aliases (
+				kind varchar(16) NOT NULL,
+				value varchar(512) NOT NULL,
+				contributor_id INTEGER NOT NULL REFERENCES contributors(id),
+				source varchar(16) NOT NULL,
+				PRIMARY KEY (kind, value)
+			)`,
+			`ALTER TABLE commits ADD COLUMN author_login varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN contributor_id INTEGER`,
+			`CREATE INDEX commits_contributor ON commits (contributor_id)`,
+			// commits stored before contributors existed are attributed by email
+			`INSERT INTO contributors (name, email) SELECT max(COALESCE(author_name, '')), COALESCE(LOWER(author_email), '')
+				FROM commits GROUP BY COALESCE(LOWER(author_email), '')`,
+			`INSERT INTO contributor_aliases (kind, value, contributor_id, source) SELECT 'email', email, id, 'commit' FROM contributors`,
+			`UPDATE commits SET contributor_id = (SELECT contributor_id FROM contributor_aliases
+				WHERE kind = 'email' AND value = COALESCE(LOWER(commits.author_email), ''))`,
+		},
+		Down: []string{
+			`DROP INDEX commits_contributor`,
+			`ALTER TABLE commits DROP COLUMN contributor_id`,
+			`ALTER TABLE commits DROP COLUMN author_login`,
+			`DROP TABLE contributor_aliases`,
+			`DROP TABLE contributors`,
+		},
+		SQLiteUp: []string{
+			`CREATE TABLE contributors (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name varchar(255) NOT NULL DEFAULT '',
+				email varchar(255) NOT NULL DEFAULT '',
+				login varchar(255) NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE contributor_aliases (
+				kind varchar(16) NOT NULL,
+				value varchar(512) NOT NULL,
+				contributor_id INTEGER NOT NULL REFERENCES contributors(id),
+				source varchar(16) NOT NULL,
+				PRIMARY KEY (kind, value)
+			)`,
+			`ALTER TABLE commits ADD COLUMN author_login varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN contributor_id INTEGER`,
+			`CREATE INDEX commits_contributor ON commits (contributor_id)`,
+			`INSERT INTO contributors (name, email) SELECT max(COALESCE(author_name, '')), COALESCE(LOWER(author_email), '')
+				FROM commits GROUP BY COALESCE(LOWER(author_email), '')`,
+			`INSERT INTO contributor_aliases (kind, value, contributor_id, source) SELECT 'email', email, id, 'commit' FROM contributors`,
+			`UPDATE commits SET contributor_id = (SELECT contributor_id FROM contributor_aliases
+				WHERE kind = 'email' AND value = COALESCE(LOWER(commits.author_email), ''))`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "commit details",
+		Up: []string{
+			`ALTER TABLE commits ADD COLUMN committer_name varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN committer_email varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN committer_login varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN committed_at timestamp`,
+			`ALTER TABLE commits ADD COLUMN parents varchar(1024) NOT NULL DEFAULT ''`,
+			`ALTER TABLE commits ADD COLUMN verified boolean NOT NULL DEFAULT false`,
+			`ALTER TABLE commits ADD COLUMN signature_reason varchar(64) NOT NULL DEFAULT ''`,
+			// commits stored before are taken as committed when authored until they're fetched again
+			`UPDATE commits SET committed_at = date`,
+		},
+		Down: []string{
+			`ALTER TABLE commits DROP COLUMN signature_reason`,
+			`ALTER TABLE commits DROP COLUMN verified`,
+			`ALTER TABLE commits DROP COLUMN parents`,
+			`ALTER TABLE commits DROP COLUMN committed_at`,
+			`ALTER TABLE commits DROP COLUMN committer_login`,
+			`ALTER TABLE commits DROP COLUMN committer_email`,
+			`ALTER TABLE commits DROP COLUMN committer_name`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "commit files",
+		Up: []string{
+			`CREATE TABLE commit_files (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				sha varchar(255) NOT NULL,
+				filename varchar(1024) NOT NULL,
+				status varchar(32) NOT NULL DEFAULT '',
+				additions int NOT NULL DEFAULT 0,
+				deletions int NOT NULL DEFAULT 0,
+				previous_filename varchar(1024) NOT NULL DEFAULT '',
+				PRIMARY KEY (sha, filename)
+			)`,
+			`CREATE INDEX commit_files_repository_id ON commit_files (repository_id)`,
+			`ALTER TABLE commits ADD COLUMN files_fetched boolean NOT NULL DEFAULT false`,
+		},
+		Down: []string{
+			`ALTER TABLE commits DROP COLUMN files_fetched`,
+			`DROP TABLE commit_files`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "pull request reviews",
+		Up: []string{
+			`CREATE TABLE pull_request_reviews (
+				id bigint PRIMARY KEY,
+				repository_id INTEGER NOT NULL,
+				number int NOT NULL,
+				reviewer varchar(255) NOT NULL DEFAULT '',
+				state varchar(32) NOT NULL,
+				submitted_at timestamp,
+				FOREIGN KEY (repository_id, number) REFERENCES pull_requests(repository_id, number)
+			)`,
+			`CREATE INDEX pull_request_reviews_number ON pull_request_reviews (repository_id, number)`,
+			`ALTER TABLE pull_requests ADD COLUMN reviews_fetched boolean NOT NULL DEFAULT false`,
+			// walk every pull request again on the next sync so the ones stored before get their reviews
+			`DELETE FROM resource_cursors WHERE resource = 'pulls'`,
+		},
+		Down: []string{
+			`ALTER TABLE pull_requests DROP COLUMN reviews_fetched`,
+			`DROP TABLE pull_request_reviews`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "deployments",
+		Up: []string{
+			`CREATE TABLE deployments (
+				id bigint PRIMARY KEY,
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				sha varchar(255) NOT NULL,
+				ref varchar(255) NOT NULL DEFAULT '',
+				environment varchar(255) NOT NULL DEFAULT '',
+				creator varchar(255) NOT NULL DEFAULT '',
+				created_at timestamp NOT NULL,
+				state varchar(32) NOT NULL DEFAULT '',
+				succeeded_at timestamp,
+				failed_at timestamp,
+				previous_sha varchar(255) NOT NULL DEFAULT '',
+				commits_linked boolean NOT NULL DEFAULT false
+			)`,
+			`CREATE INDEX deployments_repository ON deployments (repository_id, environment)`,
+			`CREATE TABLE deployment_statuses (
+				id bigint PRIMARY KEY,
+				deployment_id bigint NOT NULL REFERENCES deployments(id),
+				repository_id INTEGER NOT NULL,
+				state varchar(32) NOT NULL,
+				created_at timestamp NOT NULL
+			)`,
+			`CREATE INDEX deployment_statuses_deployment ON deployment_statuses (deployment_id)`,
+			`CREATE TABLE deployment_commits (
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				deployment_id bigint NOT NULL REFERENCES deployments(id),
+				sha varchar(255) NOT NULL,
+				PRIMARY KEY (deployment_id, sha)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE deployment_commits`,
+			`DROP TABLE deployment_statuses`,
+			`DROP TABLE deployments`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "workflow runs",
+		Up: []string{
+			`CREATE TABLE workflows (
+				id bigint PRIMARY KEY,
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				name varchar(255) NOT NULL DEFAULT '',
+				path varchar(255) NOT NULL DEFAULT '',
+				state varchar(32) NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE workflow_runs (
+				id bigint NOT NULL,
+				attempt int NOT NULL,
+				repository_id INTEGER NOT NULL REFERENCES repositories(id),
+				workflow_id bigint NOT NULL,
+				name varchar(255) NOT NULL DEFAULT '',
+				event varchar(64) NOT NULL DEFAULT '',
+				branch varchar(255) NOT NULL DEFAULT '',
+				head_sha varchar(255) NOT NULL,
+				status varchar(32) NOT NULL,
+				conclusion varchar(32) NOT NULL DEFAULT '',
+				created_at timestamp NOT NULL,
+				started_at timestamp,
+				completed_at timestamp,
+				PRIMARY KEY (id, attempt)
+			)`,
+			`CREATE INDEX workflow_runs_repository_created ON workflow_runs (repository_id, created_at)`,
+			`CREATE INDEX workflow_runs_head_sha ON workflow_runs (head_sha)`,
+		},
+		Down: []string{
+			`DROP TABLE workflow_runs`,
+			`DROP TABLE workflows`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "shipped commits",
+		// the commits shipped by releases keep what they show themselves, rather than being stored with
+		// the history of the branches
+		Up: []string{
+			`ALTER TABLE tags ADD COLUMN committed_at timestamp`,
+			`UPDATE tags SET committed_at = (SELECT c.committed_at FROM commits c WHERE c.repository_id = tags.repository_id AND c.sha = tags.sha)`,
+			`ALTER TABLE release_commits ADD COLUMN message text NOT NULL DEFAULT ''`,
+			`ALTER TABLE release_commits ADD COLUMN url varchar(1024) NOT NULL DEFAULT ''`,
+			`ALTER TABLE release_commits ADD COLUMN author_name varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE release_commits ADD COLUMN author_email varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE release_commits ADD COLUMN author_login varchar(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE release_commits ADD COLUMN date timestamp`,
+			`ALTER TABLE release_commits ADD COLUMN committed_at timestamp`,
+			`UPDATE release_commits SET
+				message = COALESCE((SELECT c.message FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), message),
+				url = COALESCE((SELECT c.url FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), url),
+				author_name = COALESCE((SELECT c.author_name FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), author_name),
+				author_email = COALESCE((SELECT c.author_email FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), author_email),
+				author_login = COALESCE((SELECT c.author_login FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), author_login),
+				date = COALESCE((SELECT c.date FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), date),
+				committed_at = COALESCE((SELECT c.committed_at FROM commits c WHERE c.repository_id = release_commits.repository_id AND c.sha = release_commits.sha), committed_at)`,
+		},
+		Down: []string{
+			`ALTER TABLE release_commits DROP COLUMN committed_at`,
+			`ALTER TABLE release_commits DROP COLUMN date`,
+			`ALTER TABLE release_commits DROP COLUMN author_login`,
+			`ALTER TABLE release_commits DROP COLUMN author_email`,
+			`ALTER TABLE release_commits DROP COLUMN author_name`,
+			`ALTER TABLE release_commits DROP COLUMN url`,
+			`ALTER TABLE release_commits DROP COLUMN message`,
+			`ALTER TABLE tags DROP COLUMN committed_at`,
+		},
+	},
+	{
+		Version: 15,
+		Name:    "empty email aliases",
+		// the contributors migration made an empty email an alias too, giving every commit without an email to the
+		// same contributor. Those commits go to the contributor of their login, or to one per name like the commits
+		// resolved since.
+		Up: []string{
+			`DELETE FROM contributor_aliases WHERE kind = 'email' AND value = ''`,
+			`UPDATE commits SET contributor_id = (SELECT contributor_id FROM contributor_aliases
+					WHERE kind = 'login' AND value = LOWER(commits.author_login))
+				WHERE COALESCE(author_email, '') = '' AND author_login <> ''
+				AND LOWER(author_login) IN (SELECT value FROM contributor_aliases WHERE kind = 'login')`,
+			`INSERT INTO contributors (name, email) SELECT max(author_name), '' FROM commits
+				WHERE COALESCE(author_email, '') = '' AND author_login = ''
+				AND LOWER(TRIM(author_name)) || ' <>' NOT IN (SELECT value FROM contributor_aliases WHERE kind = 'name_email')
+				GROUP BY LOWER(TRIM(author_name))`,
+			`INSERT INTO contributor_aliases (kind, value, contributor_id, source)
+				SELECT 'name_email', LOWER(TRIM(name)) || ' <>', max(id), 'commit' FROM contributors
+				WHERE email = '' AND login = '' AND id NOT IN (SELECT contributor_id FROM contributor_aliases)
+				GROUP BY LOWER(TRIM(name))
+				ON CONFLICT (kind, value) DO NOTHING`,
+			`UPDATE commits SET contributor_id = (SELECT contributor_id FROM contributor_aliases
+					WHERE kind = 'name_email' AND value = LOWER(TRIM(commits.author_name)) || ' <>')
+				WHERE COALESCE(author_email, '') = '' AND author_login = ''`,
+			`DELETE FROM contributors WHERE id NOT IN (SELECT contributor_id FROM contributor_aliases)
+				AND id NOT IN (SELECT contributor_id FROM commits WHERE contributor_id IS NOT NULL)`,
+		},
+		// the commits stay with the contributors they were given
+		Down: []string{},
+	},
+}
+
+// LatestSchemaVersion is the schema version this binary expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func (m Migration) statements(dialect string, up bool) []string {
+	switch {
+	case up && dialect == "sqlite" && m.SQLiteUp != nil:
+		return m.SQLiteUp
+	case !up && dialect == "sqlite" && m.SQLiteDown != nil:
+		return m.SQLiteDown
+	case up:
+		return m.Up
+	default:
+		return m.Down
+	}
+}
+
+// ensureMigrationsTable creates the schema_migrations table recording applied migrations
+func (s *sqlStore) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int PRIMARY KEY,
+		name varchar(255),
+		applied_at timestamp
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table : %v", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, 0 if none
+func (s *sqlStore) SchemaVersion() (int, error) {
+	err := s.ensureMigrationsTable()
+	if err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = s.db.QueryRow("SELECT max(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version : %v", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func (s *sqlStore) MigrationStatus() ([]MigrationState, error) {
+	err := s.ensureMigrationsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations : %v", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations : %v", err)
+		}
+		applied[version] = at
+	}
+
+	states := []MigrationState{}
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+
+	return states, rows.Err()
+}
+
+// MigrateUp applies every pending migration in order, each in its own transaction
+func (s *sqlStore) MigrateUp() ([]Migration, error) {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		err = s.applyMigration(m, true)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the last n applied migrations
+func (s *sqlStore) MigrateDown(n int) ([]Migration, error) {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+		m := migrations[i]
+		if m.Version > current {
+			continue
+		}
+
+		err = s.applyMigration(m, false)
+		if err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+func (s *sqlStore) applyMigration(m Migration, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(s.dialect, up) {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("error in migration %d (%s) : %v", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1,$2,$3)",
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version=$1", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording migration %d : %v", m.Version, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	direction := "applied"
+	if !up {
+		direction = "reverted"
+	}
+	LogApp(fmt.Sprintf("%s migration %d : %s", direction, m.Version, m.Name))
+
+	return nil
+}
+
+// PrepareSchema refuses to run against a schema newer than this binary knows about,
+// and applies any pending migrations unless DB_AUTO_MIGRATE is set to false
+func PrepareSchema(s Store) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d), please upgrade",
+			current, LatestSchemaVersion())
+	}
+
+	if current == LatestSchemaVersion() {
+		return nil
+	}
+
+	if os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		return fmt.Errorf("database schema version %d is behind %d, run the migrate up command",
+			current, LatestSchemaVersion())
+	}
+
+	_, err = s.MigrateUp()
+	return err
+}
+
+// runMigrate implements the migrate up|down|status command
+func runMigrate(s Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage : migrate up|down [n]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		current, err := s.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		if current > LatestSchemaVersion() {
+			return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, LatestSchemaVersion())
+		}
+
+		applied, err := s.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %d : %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			_, err := fmt.Sscanf(args[1], "%d", &n)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations to revert : %q", args[1])
+			}
+		}
+
+		reverted, err := s.MigrateDown(n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d : %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		states, err := s.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		current, err := s.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest %d\n", current, LatestSchemaVersion())
+
+		for _, st := range states {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-40s %s\n", st.Version, st.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+	}
+
+	return nil
+}
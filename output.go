@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Output is the result of a command, written as a table, csv or json.
+// Data is what gets encoded for json, Headers and Rows are used for the other formats.
+type Output struct {
+	Headers []string
+	Rows    [][]string
+	Data    interface{}
+}
+
+// Write writes the output to w in the given format, table, json or csv
+func (o Output) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		writeTabRow(tw, o.Headers)
+		for _, row := range o.Rows {
+			writeTabRow(tw, row)
+		}
+		return tw.Flush()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.Data)
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write(o.Headers)
+		if err != nil {
+			return err
+		}
+		err = cw.WriteAll(o.Rows)
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported output format %q, use table, json or csv", format)
+	}
+}
+
+func writeTabRow(w io.Writer, cells []string) {
+	for i, c := range cells {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, c)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AuthoredCommit is when a contributor made a commit, the rows the ownership report is made of
+type AuthoredCommit struct {
+	SHA           string    `json:"sha"`
+	ContributorID int       `json:"contributor_id"`
+	Date          time.Time `json:"date"`
+}
+
+// AuthorShare is the part of the commits of a repository, or of a directory, made by a contributor
+type AuthorShare struct {
+	ContributorID int       `json:"contributor_id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Login         string    `json:"login"`
+	Commits       int       `json:"commits"`
+	Share         float64   `json:"share"`
+	LastCommit    time.Time `json:"last_commit"`
+}
+
+// OwnershipWindow is how the commits made over a trailing window are spread among the contributors.
+// The bus factors are the fewest contributors who made half and 80% of them.
+type OwnershipWindow struct {
+	Window      string        `json:"window"`
+	Since       *time.Time    `json:"since"`
+	Commits     int           `json:"commits"`
+	Authors     int           `json:"authors"`
+	BusFactor50 int           `json:"bus_factor_50"`
+	BusFactor80 int           `json:"bus_factor_80"`
+	Shares      []AuthorShare `json:"shares"`
+}
+
+// DirectoryOwnership is who changed the files under a directory, from the changed files of the commits
+type DirectoryOwnership struct {
+	Directory   string        `json:"directory"`
+	Commits     int           `json:"commits"`
+	BusFactor50 int           `json:"bus_factor_50"`
+	Owners      []AuthorShare `json:"owners"`
+}
+
+// Ownership tells how dependent a repository is on a few contributors. Inactive lists the contributors
+// without commits over the last InactiveDays, biggest share first. Directories is empty until the
+// changed files of the commits are fetched.
+type Ownership struct {
+	RepositoryID int                  `json:"repository_id"`
+	Branch       string               `json:"branch"`
+	InactiveDays int                  `json:"inactive_days"`
+	Windows      []OwnershipWindow    `json:"windows"`
+	Inactive     []AuthorShare        `json:"inactive"`
+	Directories  []DirectoryOwnership `json:"directories"`
+}
+
+// trailingWindows are the trailing windows the ownership and delivery metrics are computed over, in days, 0 for all time
+var trailingWindows = []int{30, 90, 365, 0}
+
+// windowName names a trailing window of the given number of days
+func windowName(days int) string {
+	if days == 0 {
+		return "all"
+	}
+	return strconv.Itoa(days) + "d"
+}
+
+// BusFactor returns the fewest contributors who made at least the given part of the commits, their
+// commit counts sorted from the most commits
+func BusFactor(commits []int, part float64) int {
+	total := 0
+	for _, c := range commits {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	sum := 0
+	for i, c := range commits {
+		sum += c
+		if float64(sum) >= part*float64(total) {
+			return i + 1
+		}
+	}
+	return len(commits)
+}
+
+// GetAuthoredCommits returns who made each commit of the repository and when, counting only the commits reachable
+// from branch if it's given
+func (s *sqlStore) GetAuthoredCommits(repo_id int, branch string) ([]AuthoredCommit, error) {
+	q := newQuery("SELECT sha, COALESCE(contributor_id, 0), date FROM commits WHERE repository_id=$1", repo_id)
+	if branch != "" {
+		q.where("sha IN (SELECT sha FROM commit_branches WHERE repository_id=$1 AND branch=$%d)", branch)
+	}
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error getting authored commits from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	commits := []AuthoredCommit{}
+	for rows.Next() {
+		c := AuthoredCommit{}
+		err = rows.Scan(&c.SHA, &c.ContributorID, &c.Date)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning authored commit : %v", err))
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+
+	return commits, rows.Err()
+}
+
+// GetOwnership builds the ownership report of the repository from the stored commits. The directories are cut at
+// depth and list their top n owners.
+func GetOwnership(repo_id int, branch string, inactiveDays, depth, n int) (*Ownership, error) {
+	authors, err := store.GetTopAuthors(repo_id, 0, branch)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := store.GetAuthoredCommits(repo_id, branch)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := store.GetFileChanges(repo_id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o := OwnershipReport(authors, commits, changes, time.Now(), inactiveDays, depth, n)
+	o.RepositoryID = repo_id
+	o.Branch = branch
+	return o, nil
+}
+
+// OwnershipReport spreads the commits among the given authors over each trailing window ending now, and the
+// changed files among them per directory. Changed files of other commits, off the branch, are left out.
+func OwnershipReport(authors []Author, commits []AuthoredCommit, changes []FileChange, now time.Time, inactiveDays, depth, n int) *Ownership {
+	byID := map[int]Author{}
+	for _, a := range authors {
+		byID[a.ContributorID] = a
+	}
+	last := map[int]time.Time{}
+	for _, c := range commits {
+		if c.Date.After(last[c.ContributorID]) {
+			last[c.ContributorID] = c.Date
+		}
+	}
+
+	// shares lists the contributors by commits, ties by id so the order is stable
+	shares := func(counts map[int]int) []AuthorShare {
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		result := []AuthorShare{}
+		for id, c := range counts {
+			a := byID[id]
+			result = append(result, AuthorShare{
+				ContributorID: id,
+				Name:          a.AuthorName,
+				Email:         a.AuthorEmail,
+				Login:         a.Login,
+				Commits:       c,
+				Share:         float64(c) / float64(total),
+				LastCommit:    last[id],
+			})
+		}
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].Commits != result[j].Commits {
+				return result[i].Commits > result[j].Commits
+			}
+			return result[i].ContributorID < result[j].ContributorID
+		})
+		return result
+	}
+	busFactor := func(shares []AuthorShare, part float64) int {
+		counts := []int{}
+		for _, s := range shares {
+			counts = append(counts, s.Commits)
+		}
+		return BusFactor(counts, part)
+	}
+
+	o := &Ownership{InactiveDays: inactiveDays, Windows: []OwnershipWindow{}, Inactive: []AuthorShare{}, Directories: []DirectoryOwnership{}}
+	for _, days := range trailingWindows {
+		w := OwnershipWindow{Window: windowName(days)}
+		if days > 0 {
+			since := now.AddDate(0, 0, -days)
+			w.Since = &since
+		}
+
+		counts := map[int]int{}
+		for _, c := range commits {
+			if w.Since == nil || !c.Date.Before(*w.Since) {
+				counts[c.ContributorID]++
+				w.Commits++
+			}
+		}
+		w.Shares = shares(counts)
+		w.Authors = len(w.Shares)
+		w.BusFactor50 = busFactor(w.Shares, 0.5)
+		w.BusFactor80 = busFactor(w.Shares, 0.8)
+		o.Windows = append(o.Windows, w)
+	}
+
+	inactiveSince := now.AddDate(0, 0, -inactiveDays)
+	for _, s := range o.Windows[len(o.Windows)-1].Shares {
+		if s.LastCommit.Before(inactiveSince) {
+			o.Inactive = append(o.Inactive, s)
+		}
+	}
+
+	// a commit counts once per directory however many of its files it changed there
+	counted := map[string]bool{}
+	for _, c := range commits {
+		counted[c.SHA] = true
+	}
+	dirCommits := map[string]map[string]int{}
+	for _, c := range changes {
+		if !counted[c.SHA] {
+			continue
+		}
+		dir := directoryOf(c.Filename, depth)
+		if dirCommits[dir] == nil {
+			dirCommits[dir] = map[string]int{}
+		}
+		dirCommits[dir][c.SHA] = c.ContributorID
+	}
+	for dir, shas := range dirCommits {
+		counts := map[int]int{}
+		for _, id := range shas {
+			counts[id]++
+		}
+		owners := shares(counts)
+		d := DirectoryOwnership{Directory: dir, Commits: len(shas), BusFactor50: busFactor(owners, 0.5), Owners: owners}
+		if n > 0 && len(d.Owners) > n {
+			d.Owners = d.Owners[:n]
+		}
+		o.Directories = append(o.Directories, d)
+	}
+	sort.Slice(o.Directories, func(i, j int) bool {
+		a, b := o.Directories[i], o.Directories[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		return a.Directory < b.Directory
+	})
+
+	return o
+}
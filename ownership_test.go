@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusFactor(t *testing.T) {
+	tests := []struct {
+		commits []int
+		part    float64
+		want    int
+	}{
+		{nil, 0.5, 0},
+		{[]int{0, 0}, 0.5, 0},
+		{[]int{10}, 0.8, 1},
+		{[]int{5, 3, 2}, 0.5, 1},
+		{[]int{5, 3, 2}, 0.8, 2},
+		{[]int{4, 3, 3}, 0.5, 2},
+		{[]int{1, 1, 1, 1}, 0.8, 4},
+	}
+	for _, tt := range tests {
+		if got := BusFactor(tt.commits, tt.part); got != tt.want {
+			t.Errorf("BusFactor(%v, %v) = %d, want %d", tt.commits, tt.part, got, tt.want)
+		}
+	}
+}
+
+func TestOwnershipReport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	authors := []Author{
+		{ContributorID: 1, AuthorName: "Jane Doe", Commits: 3},
+		{ContributorID: 2, AuthorName: "John Smith", Commits: 2},
+	}
+	commits := []AuthoredCommit{
+		{SHA: "a", ContributorID: 1, Date: daysAgo(10)},
+		{SHA: "b", ContributorID: 1, Date: daysAgo(60)},
+		{SHA: "c", ContributorID: 1, Date: daysAgo(200)},
+		{SHA: "d", ContributorID: 2, Date: daysAgo(300)},
+		{SHA: "e", ContributorID: 2, Date: daysAgo(400)},
+	}
+	change := func(sha, filename string, id int) FileChange {
+		return FileChange{CommitFile: CommitFile{SHA: sha, Filename: filename}, ContributorID: id}
+	}
+	changes := []FileChange{
+		change("a", "src/main.go", 1),
+		change("a", "src/util.go", 1),
+		change("d", "src/main.go", 2),
+		change("e", "docs/readme.md", 2),
+		// x is on another branch
+		change("x", "tools/gen.go", 3),
+	}
+
+	o := OwnershipReport(authors, commits, changes, now, 90, 1, 0)
+
+	windows := []struct {
+		window                   string
+		commits, authors         int
+		busFactor50, busFactor80 int
+	}{
+		{"30d", 1, 1, 1, 1},
+		{"90d", 2, 1, 1, 1},
+		{"365d", 4, 2, 1, 2},
+		{"all", 5, 2, 1, 2},
+	}
+	if len(o.Windows) != len(windows) {
+		t.Fatalf("windows = %+v", o.Windows)
+	}
+	for i, w := range windows {
+		got := o.Windows[i]
+		if got.Window != w.window || got.Commits != w.commits || got.Authors != w.authors ||
+			got.BusFactor50 != w.busFactor50 || got.BusFactor80 != w.busFactor80 {
+			t.Errorf("window %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if all := o.Windows[3].Shares; all[0].ContributorID != 1 || all[0].Share != 0.6 || !all[1].LastCommit.Equal(daysAgo(300)) {
+		t.Errorf("all time shares = %+v", all)
+	}
+
+	if len(o.Inactive) != 1 || o.Inactive[0].ContributorID != 2 {
+		t.Errorf("inactive = %+v, want John Smith", o.Inactive)
+	}
+
+	dirs := map[string]int{}
+	for _, d := range o.Directories {
+		dirs[d.Directory] = d.Commits
+	}
+	if len(dirs) != 2 || dirs["src"] != 2 || dirs["docs"] != 1 {
+		t.Errorf("directories = %+v, want src with 2 commits and docs with 1", o.Directories)
+	}
+	if o.Directories[0].Directory != "src" || o.Directories[0].BusFactor50 != 1 {
+		t.Errorf("first directory = %+v", o.Directories[0])
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+// NewPostgresStore connects to the postgres database
+func NewPostgresStore(dsn string) (Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		LogError(fmt.Errorf("error connecting to db : %v", err))
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		LogError(fmt.Errorf("error pinging db : %v", err))
+		return nil, err
+	}
+
+	return &sqlStore{db: db, dialect: "postgres"}, nil
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// PullRequest is a pull request of a repository. State is open, merged or closed, closed meaning closed without merging.
+type PullRequest struct {
+	RepositoryID int        `json:"repository_id" db:"repository_id"`
+	Number       int        `json:"number" db:"number"`
+	Title        string     `json:"title" db:"title"`
+	Author       string     `json:"author" db:"author"`
+	State        string     `json:"state" db:"state"`
+	Draft        bool       `json:"draft" db:"draft"`
+	BaseRef      string     `json:"base_ref" db:"base_ref"`
+	HeadRef      string     `json:"head_ref" db:"head_ref"`
+	Created      time.Time  `json:"created_at" db:"created_at"`
+	Updated      time.Time  `json:"updated_at" db:"updated_at"`
+	Merged       *time.Time `json:"merged_at" db:"merged_at"`
+	Closed       *time.Time `json:"closed_at" db:"closed_at"`
+	Additions    int        `json:"additions" db:"additions"`
+	Deletions    int        `json:"deletions" db:"deletions"`
+	Commits      []string   `json:"commits"`
+	Reviews      []Review   `json:"reviews"`
+
+	// reviewsFetched is false for the pull requests stored before reviews were fetched
+	reviewsFetched bool
+}
+
+// Review is a review submitted on a pull request. State is APPROVED, CHANGES_REQUESTED, COMMENTED or DISMISSED.
+type Review struct {
+	ID        int64     `json:"id" db:"id"`
+	Reviewer  string    `json:"reviewer" db:"reviewer"`
+	State     string    `json:"state" db:"state"`
+	Submitted time.Time `json:"submitted_at" db:"submitted_at"`
+}
+
+// review states
+const (
+	ReviewApproved         = "APPROVED"
+	ReviewChangesRequested = "CHANGES_REQUESTED"
+	ReviewCommented        = "COMMENTED"
+	ReviewDismissed        = "DISMISSED"
+)
+
+// pull request states
+const (
+	PullOpen   = "open"
+	PullMerged = "merged"
+	PullClosed = "closed"
+)
+
+// pullsResource names the pull requests in the resource_cursors table
+const pullsResource = "pulls"
+
+// githubPull is a pull request as returned by the pulls api
+type githubPull struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Draft  bool   `json:"draft"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Head struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Created   time.Time  `json:"created_at"`
+	Updated   time.Time  `json:"updated_at"`
+	Merged    *time.Time `json:"merged_at"`
+	Closed    *time.Time `json:"closed_at"`
+	Additions int        `json:"additions"`
+	Deletions int        `json:"deletions"`
+}
+
+func (p githubPull) toPullRequest(repo_id int) PullRequest {
+	state := p.State
+	if p.Merged != nil {
+		state = PullMerged
+	}
+
+	return PullRequest{
+		RepositoryID: repo_id,
+		Number:       p.Number,
+		Title:        p.Title,
+		Author:       p.User.Login,
+		State:        state,
+		Draft:        p.Draft,
+		BaseRef:      p.Base.Ref,
+		HeadRef:      p.Head.Ref,
+		Created:      p.Created,
+		Updated:      p.Updated,
+		Merged:       p.Merged,
+		Closed:       p.Closed,
+		Additions:    p.Additions,
+		Deletions:    p.Deletions,
+	}
+}
+
+// SyncPullRequests fetches the pull requests updated since the last sync, most recently updated first.
+// The listing doesn't include the size, commits and reviews of a pull request, so those are fetched for each
+// updated one. The sync point only moves once the whole listing has been walked, an interrupted sync
+// walks it again but skips the pull requests it already stored. It returns how many were updated.
+func SyncPullRequests(repo *Repository) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncPullRequests(repo)
+}
+
+// syncPullRequests is SyncPullRequests for callers already holding the repository lock
+func syncPullRequests(repo *Repository) (int, error) {
+	since, err := store.GetResourceCursor(repo.ID, pullsResource)
+	if err != nil {
+		LogError(fmt.Errorf("error getting pull requests cursor : %v", err))
+		return 0, err
+	}
+
+	stored, err := store.ListPullRequests(repo.ID, PullRequestFilter{})
+	if err != nil {
+		return 0, err
+	}
+	known := map[int]time.Time{}
+	for _, pr := range stored {
+		if pr.reviewsFetched {
+			known[pr.Number] = pr.Updated
+		}
+	}
+
+	params := url.Values{}
+	params.Set("state", "all")
+	params.Set("sort", "updated")
+	params.Set("direction", "desc")
+	params.Set("per_page", "100")
+	URL := repo.URL + "/pulls?" + params.Encode()
+
+	updated := 0
+	newest := since
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with pull requests request : %v", err))
+			return updated, err
+		}
+
+		response := []githubPull{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing pull requests : %v", err))
+			return updated, err
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+		for _, p := range response {
+			if p.Updated.Before(since) {
+				// everything from here on was synced before
+				URL = ""
+				break
+			}
+			if p.Updated.After(newest) {
+				newest = p.Updated
+			}
+			if t, ok := known[p.Number]; ok && t.Equal(p.Updated) {
+				continue
+			}
+
+			pr, err := fetchPullRequest(repo, p.Number)
+			if err != nil {
+				return updated, err
+			}
+
+			err = store.SavePullRequest(pr)
+			if err != nil {
+				LogError(fmt.Errorf("error saving pull request %d : %v", pr.Number, err))
+				return updated, err
+			}
+			updated++
+		}
+	}
+
+	err = store.SaveResourceCursor(repo.ID, pullsResource, newest)
+	if err != nil {
+		LogError(fmt.Errorf("error saving pull requests cursor : %v", err))
+		return updated, err
+	}
+
+	return updated, nil
+}
+
+// fetchPullRequest fetches a single pull request along with the shas of its commits and its reviews
+func fetchPullRequest(repo *Repository, number int) (*PullRequest, error) {
+	URL := fmt.Sprintf("%s/pulls/%d", repo.URL, number)
+	_, body, err := github.Get(URL)
+	if err != nil {
+		LogError(fmt.Errorf("error with pull request request : %v", err))
+		return nil, err
+	}
+
+	p := githubPull{}
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		LogError(fmt.Errorf("error parsing pull request : %v", err))
+		return nil, err
+	}
+	pr := p.toPullRequest(repo.ID)
+
+	pr.Commits = []string{}
+	URL += "/commits?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with pull request commits request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			SHA string `json:"sha"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing pull request commits : %v", err))
+			return nil, err
+		}
+		for _, c := range response {
+			pr.Commits = append(pr.Commits, c.SHA)
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	pr.Reviews, err = fetchReviews(repo, number)
+	if err != nil {
+		return nil, err
+	}
+	pr.reviewsFetched = true
+
+	return &pr, nil
+}
+
+// fetchReviews fetches the reviews submitted on a pull request, oldest first. Pending reviews aren't submitted yet
+// and are left out.
+func fetchReviews(repo *Repository, number int) ([]Review, error) {
+	reviews := []Review{}
+	URL := fmt.Sprintf("%s/pulls/%d/reviews?per_page=100", repo.URL, number)
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with pull request reviews request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			ID   int64 `json:"id"`
+			User *struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			State     string     `json:"state"`
+			Submitted *time.Time `json:"submitted_at"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing pull request reviews : %v", err))
+			return nil, err
+		}
+		for _, r := range response {
+			if r.Submitted == nil {
+				continue
+			}
+			review := Review{ID: r.ID, State: r.State, Submitted: *r.Submitted}
+			// deleted accounts have no user
+			if r.User != nil {
+				review.Reviewer = r.User.Login
+			}
+			reviews = append(reviews, review)
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return reviews, nil
+}
+
+// SavePullRequest saves the pull request and replaces its commit shas and reviews, in one transaction
+func (s *sqlStore) SavePullRequest(pr *PullRequest) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := `insert into pull_requests (
+		repository_id,
+		number,
+		title,
+		author,
+		state,
+		draft,
+		base_ref,
+		head_ref,
+		created_at,
+		updated_at,
+		merged_at,
+		closed_at,
+		additions,
+		deletions,
+		reviews_fetched
+	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+	ON CONFLICT (repository_id, number) DO UPDATE SET
+		title=$3,
+		author=$4,
+		state=$5,
+		draft=$6,
+		base_ref=$7,
+		head_ref=$8,
+		updated_at=$10,
+		merged_at=$11,
+		closed_at=$12,
+		additions=$13,
+		deletions=$14,
+		reviews_fetched=$15
+	`
+
+	_, err = tx.Exec(insert,
+		pr.RepositoryID,
+		pr.Number,
+		pr.Title,
+		pr.Author,
+		pr.State,
+		pr.Draft,
+		pr.BaseRef,
+		pr.HeadRef,
+		pr.Created.UTC(),
+		pr.Updated.UTC(),
+		utcOrNil(pr.Merged),
+		utcOrNil(pr.Closed),
+		pr.Additions,
+		pr.Deletions,
+		pr.reviewsFetched)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM pull_request_commits WHERE repository_id=$1 AND number=$2", pr.RepositoryID, pr.Number)
+	if err != nil {
+		return err
+	}
+	for _, sha := range pr.Commits {
+		_, err = tx.Exec("INSERT INTO pull_request_commits (repository_id, number, sha) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING",
+			pr.RepositoryID, pr.Number, sha)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM pull_request_reviews WHERE repository_id=$1 AND number=$2", pr.RepositoryID, pr.Number)
+	if err != nil {
+		return err
+	}
+	for _, r := range pr.Reviews {
+		_, err = tx.Exec(`INSERT INTO pull_request_reviews (id, repository_id, number, reviewer, state, submitted_at)
+			VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT DO NOTHING`,
+			r.ID, pr.RepositoryID, pr.Number, r.Reviewer, r.State, r.Submitted.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PullRequestFilter narrows down the pull requests returned by ListPullRequests, every one is listed if it's empty
+type PullRequestFilter struct {
+	State  string
+	Author string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+// pullRequestColumns lists the columns scanned into a PullRequest, in order
+const pullRequestColumns = `repository_id, number, title, author, state, draft, base_ref, head_ref,
+	created_at, updated_at, merged_at, closed_at, additions, deletions, reviews_fetched`
+
+// ListPullRequests returns the pull requests of the repository matching the filter, newest first.
+// Since and Until bound the creation date.
+func (s *sqlStore) ListPullRequests(repo_id int, f PullRequestFilter) ([]PullRequest, error) {
+	q := newQuery("SELECT "+pullRequestColumns+" FROM pull_requests WHERE repository_id=$1", repo_id)
+	if f.State != "" {
+		q.where("state = $%d", f.State)
+	}
+	if f.Author != "" {
+		q.where("LOWER(author) = LOWER($%d)", f.Author)
+	}
+	if f.Since != nil {
+		q.where("created_at >= $%d", f.Since.UTC())
+	}
+	if f.Until != nil {
+		q.where("created_at <= $%d", f.Until.UTC())
+	}
+	q.sql += " ORDER BY number DESC"
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error listing pull requests : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	prs := []PullRequest{}
+	index := map[int]int{}
+	for rows.Next() {
+		pr := PullRequest{Commits: []string{}, Reviews: []Review{}}
+		err = rows.Scan(&pr.RepositoryID, &pr.Number, &pr.Title, &pr.Author, &pr.State, &pr.Draft,
+			&pr.BaseRef, &pr.HeadRef, &pr.Created, &pr.Updated, &pr.Merged, &pr.Closed,
+			&pr.Additions, &pr.Deletions, &pr.reviewsFetched)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning pull request result : %v", err))
+			return nil, err
+		}
+
+		index[pr.Number] = len(prs)
+		prs = append(prs, pr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// attach the commit shas
+	shas, err := s.db.Query("SELECT number, sha FROM pull_request_commits WHERE repository_id=$1", repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error listing pull request commits : %v", err))
+		return nil, err
+	}
+	defer shas.Close()
+
+	for shas.Next() {
+		var number int
+		var sha string
+		err = shas.Scan(&number, &sha)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning pull request commit : %v", err))
+			return nil, err
+		}
+		if i, ok := index[number]; ok {
+			prs[i].Commits = append(prs[i].Commits, sha)
+		}
+	}
+	if err = shas.Err(); err != nil {
+		return nil, err
+	}
+
+	// attach the reviews, oldest first
+	reviews, err := s.db.Query(`SELECT number, id, reviewer, state, submitted_at FROM pull_request_reviews
+		WHERE repository_id=$1 ORDER BY submitted_at, id`, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error listing pull request reviews : %v", err))
+		return nil, err
+	}
+	defer reviews.Close()
+
+	for reviews.Next() {
+		var number int
+		r := Review{}
+		err = reviews.Scan(&number, &r.ID, &r.Reviewer, &r.State, &r.Submitted)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning pull request review : %v", err))
+			return nil, err
+		}
+		if i, ok := index[number]; ok {
+			prs[i].Reviews = append(prs[i].Reviews, r)
+		}
+	}
+
+	return prs, reviews.Err()
+}
+
+// utcOrNil converts an optional time to UTC, nil stays NULL
+func utcOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
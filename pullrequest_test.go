@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePulls serves the pull requests of a repository, listed most recently updated first, pageSize a page
+type fakePulls struct {
+	mu       sync.Mutex
+	pulls    map[int]time.Time
+	pageSize int
+	// failPull makes the requests for that pull request fail, 0 for none
+	failPull int
+	// pages and details count the listing requests by page and the pull request requests by number
+	pages   map[int]int
+	details map[int]int
+}
+
+func (f *fakePulls) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/repos/org/repo/pulls"), "/")
+	if len(parts) == 1 {
+		f.list(w, r)
+		return
+	}
+
+	number, _ := strconv.Atoi(parts[1])
+	if number == f.failPull {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(parts) == 2:
+		f.details[number]++
+		json.NewEncoder(w).Encode(f.pull(number))
+	case parts[2] == "commits":
+		fmt.Fprintf(w, `[{"sha": "c%d"}]`, number)
+	case parts[2] == "reviews":
+		fmt.Fprintf(w, `[{"id": %d, "user": {"login": "bob"}, "state": "APPROVED", "submitted_at": "%s"}]`,
+			number, f.pulls[number].Format(time.RFC3339))
+	}
+}
+
+func (f *fakePulls) list(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	f.pages[page]++
+
+	numbers := []int{}
+	for n := range f.pulls {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return f.pulls[numbers[i]].After(f.pulls[numbers[j]]) })
+
+	start, end := (page-1)*f.pageSize, page*f.pageSize
+	if end < len(numbers) {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, next.Encode()))
+	} else {
+		end = len(numbers)
+	}
+
+	response := []githubPull{}
+	for _, n := range numbers[start:end] {
+		response = append(response, f.pull(n))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakePulls) pull(number int) githubPull {
+	p := githubPull{Number: number, Title: fmt.Sprintf("pull %d", number), State: PullOpen,
+		Created: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Updated: f.pulls[number]}
+	p.User.Login = "alice"
+	return p
+}
+
+func TestSyncPullRequests(t *testing.T) {
+	s := newTestStore(t)
+	hour := func(h int) time.Time { return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC) }
+	fake := &fakePulls{pulls: map[int]time.Time{}, pageSize: 2, pages: map[int]int{}, details: map[int]int{}}
+	for n := 1; n <= 5; n++ {
+		fake.pulls[n] = hour(n)
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+
+	cursor := func() time.Time {
+		t.Helper()
+		since, err := s.GetResourceCursor(repo.ID, pullsResource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return since
+	}
+
+	updated, err := SyncPullRequests(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 5 || !cursor().Equal(hour(5)) {
+		t.Fatalf("first sync updated %d up to %v, want 5 up to %v", updated, cursor(), hour(5))
+	}
+	prs, err := s.ListPullRequests(repo.ID, PullRequestFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 5 || len(prs[0].Commits) != 1 || len(prs[0].Reviews) != 1 {
+		t.Errorf("stored = %+v, want 5 pull requests with a commit and a review each", prs)
+	}
+
+	// 2 and 4 are updated, and fetching 4 fails
+	fake.pulls[2], fake.pulls[4] = hour(7), hour(6)
+	fake.failPull = 4
+	_, err = SyncPullRequests(repo)
+	if err == nil {
+		t.Fatal("sync with a failing pull request succeeded")
+	}
+	if !cursor().Equal(hour(5)) {
+		t.Errorf("cursor moved to %v after a failed sync", cursor())
+	}
+
+	// the resume only fetches 4, and stops listing at 3 which is older than the cursor
+	fake.failPull = 0
+	fake.details = map[int]int{}
+	fake.pages = map[int]int{}
+	updated, err = SyncPullRequests(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 || len(fake.details) != 1 || fake.details[4] != 1 {
+		t.Errorf("resume updated %d fetching %v, want pull request 4 only", updated, fake.details)
+	}
+	if fake.pages[3] != 0 {
+		t.Error("resume listed past the cursor")
+	}
+	if !cursor().Equal(hour(7)) {
+		t.Errorf("cursor = %v, want %v", cursor(), hour(7))
+	}
+}
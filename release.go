@@ -0,0 +1,588 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Tag is a git tag of a repository, Committed is when its commit was made, nil until it's known
+type Tag struct {
+	RepositoryID int        `json:"repository_id" db:"repository_id"`
+	Name         string     `json:"name" db:"name"`
+	SHA          string     `json:"sha" db:"sha"`
+	Committed    *time.Time `json:"committed_at" db:"committed_at"`
+}
+
+// Release is a github release of a repository. PreviousTag is the tag made before its own, whether it was
+// released or not, the commits of the release are the ones between the two.
+type Release struct {
+	ID           int64          `json:"id" db:"id"`
+	RepositoryID int            `json:"repository_id" db:"repository_id"`
+	Tag          string         `json:"tag" db:"tag"`
+	Name         string         `json:"name" db:"name"`
+	TargetSHA    string         `json:"target_sha" db:"target_sha"`
+	Draft        bool           `json:"draft" db:"draft"`
+	Prerelease   bool           `json:"prerelease" db:"prerelease"`
+	Published    *time.Time     `json:"published_at" db:"published_at"`
+	Author       string         `json:"author" db:"author"`
+	PreviousTag  string         `json:"previous_tag" db:"previous_tag"`
+	Downloads    int            `json:"downloads"`
+	Commits      int            `json:"commits"`
+	Assets       []ReleaseAsset `json:"assets"`
+
+	// SincePrevious is the time between the publication of the release published before and this one
+	SincePrevious time.Duration `json:"since_previous"`
+
+	// commitsLinked tells whether the commits since the previous tag have been recorded
+	commitsLinked bool
+}
+
+// ReleaseAsset is a file attached to a release
+type ReleaseAsset struct {
+	Name      string `json:"name" db:"name"`
+	Downloads int    `json:"downloads" db:"download_count"`
+}
+
+// SyncReleases fetches the tags and releases of the repository, then records the commits of every newly
+// published release by comparing its tag with the tag made before it. The first tag has no previous tag to
+// compare with, so no commits are recorded for its release. It returns how many releases were new.
+func SyncReleases(repo *Repository) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncReleases(repo)
+}
+
+// syncReleases is SyncReleases for callers already holding the repository lock
+func syncReleases(repo *Repository) (int, error) {
+	tags, err := fetchTags(repo)
+	if err != nil {
+		return 0, err
+	}
+	err = dateTags(repo, tags)
+	if err != nil {
+		return 0, err
+	}
+	err = store.SaveTags(repo.ID, tags)
+	if err != nil {
+		LogError(fmt.Errorf("error saving tags : %v", err))
+		return 0, err
+	}
+	tagSHAs := map[string]string{}
+	for _, t := range tags {
+		tagSHAs[t.Name] = t.SHA
+	}
+	previousTags := previousTags(tags)
+
+	stored, err := store.GetReleases(repo.ID)
+	if err != nil {
+		return 0, err
+	}
+	known := map[int64]Release{}
+	for _, r := range stored {
+		known[r.ID] = r
+	}
+
+	releases, err := fetchReleases(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, r := range releases {
+		if sha, ok := tagSHAs[r.Tag]; ok {
+			r.TargetSHA = sha
+		}
+
+		old, seen := known[r.ID]
+		if !seen {
+			added++
+		}
+
+		if !r.Draft {
+			r.PreviousTag = previousTags[r.Tag]
+		}
+		r.commitsLinked = seen && old.commitsLinked && old.PreviousTag == r.PreviousTag && old.TargetSHA == r.TargetSHA
+
+		err = store.SaveRelease(&r)
+		if err != nil {
+			LogError(fmt.Errorf("error saving release %s : %v", r.Tag, err))
+			return added, err
+		}
+
+		if r.commitsLinked || r.Draft {
+			continue
+		}
+
+		commits := []Commit{}
+		if r.PreviousTag != "" {
+			commits, err = compareCommits(repo, r.PreviousTag, r.Tag)
+			if err != nil {
+				return added, err
+			}
+		}
+		err = store.SaveReleaseCommits(repo.ID, r.Tag, commits)
+		if err != nil {
+			LogError(fmt.Errorf("error saving the commits of release %s : %v", r.Tag, err))
+			return added, err
+		}
+	}
+
+	return added, nil
+}
+
+// fetchTags fetches every tag of the repository
+func fetchTags(repo *Repository) ([]Tag, error) {
+	tags := []Tag{}
+	URL := repo.URL + "/tags?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with tags request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			Name   string `json:"name"`
+			Commit struct {
+				SHA string `json:"sha"`
+			} `json:"commit"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing tags : %v", err))
+			return nil, err
+		}
+		for _, t := range response {
+			tags = append(tags, Tag{RepositoryID: repo.ID, Name: t.Name, SHA: t.Commit.SHA})
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return tags, nil
+}
+
+// dateTags sets when the commit of each tag was made, kept from the stored tags when their commit is the same,
+// taken from the synced commits otherwise, and only fetched for the commits that aren't stored
+func dateTags(repo *Repository, tags []Tag) error {
+	stored, err := store.GetTags(repo.ID)
+	if err != nil {
+		return err
+	}
+	known := map[string]*time.Time{}
+	for _, t := range stored {
+		known[t.SHA] = t.Committed
+	}
+
+	for i, t := range tags {
+		if date, ok := known[t.SHA]; ok && date != nil {
+			tags[i].Committed = date
+			continue
+		}
+
+		c, err := store.GetCommit(repo.ID, t.SHA)
+		if err == nil {
+			tags[i].Committed = &c.Committed
+			known[t.SHA] = &c.Committed
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, body, err := github.Get(repo.URL + "/commits/" + url.PathEscape(t.SHA))
+		if err != nil {
+			LogError(fmt.Errorf("error with tag commit request : %v", err))
+			return err
+		}
+		response := githubCommit{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing tag commit : %v", err))
+			return err
+		}
+		committed := response.toCommit(repo.ID).Committed
+		tags[i].Committed = &committed
+		known[t.SHA] = &committed
+	}
+
+	return nil
+}
+
+// previousTags maps the name of each tag to the name of the tag made before it, by when their commits were made.
+// Tags of the same commit, or whose commit date is unknown, aren't the previous tag of one another.
+func previousTags(tags []Tag) map[string]string {
+	dated := []Tag{}
+	for _, t := range tags {
+		if t.Committed != nil {
+			dated = append(dated, t)
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		if !dated[i].Committed.Equal(*dated[j].Committed) {
+			return dated[i].Committed.Before(*dated[j].Committed)
+		}
+		return dated[i].Name < dated[j].Name
+	})
+
+	previous := map[string]string{}
+	for i, t := range dated {
+		for j := i - 1; j >= 0; j-- {
+			if dated[j].SHA != t.SHA && dated[j].Committed.Before(*t.Committed) {
+				previous[t.Name] = dated[j].Name
+				break
+			}
+		}
+	}
+	return previous
+}
+
+// fetchReleases fetches every release of the repository
+func fetchReleases(repo *Repository) ([]Release, error) {
+	releases := []Release{}
+	URL := repo.URL + "/releases?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with releases request : %v", err))
+			return nil, err
+		}
+
+		response := []struct {
+			ID              int64      `json:"id"`
+			TagName         string     `json:"tag_name"`
+			Name            string     `json:"name"`
+			TargetCommitish string     `json:"target_commitish"`
+			Draft           bool       `json:"draft"`
+			Prerelease      bool       `json:"prerelease"`
+			Published       *time.Time `json:"published_at"`
+			Author          struct {
+				Login string `json:"login"`
+			} `json:"author"`
+			Assets []struct {
+				Name          string `json:"name"`
+				DownloadCount int    `json:"download_count"`
+			} `json:"assets"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing releases : %v", err))
+			return nil, err
+		}
+
+		for _, r := range response {
+			release := Release{
+				ID:           r.ID,
+				RepositoryID: repo.ID,
+				Tag:          r.TagName,
+				Name:         r.Name,
+				TargetSHA:    r.TargetCommitish,
+				Draft:        r.Draft,
+				Prerelease:   r.Prerelease,
+				Published:    r.Published,
+				Author:       r.Author.Login,
+				Assets:       []ReleaseAsset{},
+			}
+			for _, a := range r.Assets {
+				release.Assets = append(release.Assets, ReleaseAsset{a.Name, a.DownloadCount})
+				release.Downloads += a.DownloadCount
+			}
+			releases = append(releases, release)
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return releases, nil
+}
+
+// compareCommits fetches the commits reachable from head but not from base
+func compareCommits(repo *Repository, base, head string) ([]Commit, error) {
+	commits := []Commit{}
+	URL := fmt.Sprintf("%s/compare/%s...%s?per_page=100", repo.URL, url.PathEscape(base), url.PathEscape(head))
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with compare request : %v", err))
+			return nil, err
+		}
+
+		response := struct {
+			Commits []githubCommit `json:"commits"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing compare : %v", err))
+			return nil, err
+		}
+
+		for _, c := range response.Commits {
+			commits = append(commits, c.toCommit(repo.ID))
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return commits, nil
+}
+
+// SaveTags replaces the stored tags of the repository
+func (s *sqlStore) SaveTags(repo_id int, tags []Tag) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM tags WHERE repository_id=$1", repo_id)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		_, err = tx.Exec("INSERT INTO tags (repository_id, name, sha, committed_at) VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING",
+			repo_id, t.Name, t.SHA, utcOrNil(t.Committed))
+		if err != nil {
+			return fmt.Errorf("error saving tag %s : %v", t.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTags returns the stored tags of the repository
+func (s *sqlStore) GetTags(repo_id int) ([]Tag, error) {
+	rows, err := s.db.Query("SELECT repository_id, name, sha, committed_at FROM tags WHERE repository_id=$1 ORDER BY name", repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting tags from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		t := Tag{}
+		err = rows.Scan(&t.RepositoryID, &t.Name, &t.SHA, &t.Committed)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning tag result : %v", err))
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// SaveRelease saves the release and replaces its assets, in one transaction.
+// Unless the release is marked as linked, the commits recorded for it are dropped.
+func (s *sqlStore) SaveRelease(r *Release) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := `insert into releases (
+		id,
+		repository_id,
+		tag,
+		name,
+		target_sha,
+		draft,
+		prerelease,
+		published_at,
+		author,
+		previous_tag,
+		commits_linked
+	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+	ON CONFLICT (id) DO UPDATE SET
+		tag=$3,
+		name=$4,
+		target_sha=$5,
+		draft=$6,
+		prerelease=$7,
+		published_at=$8,
+		author=$9,
+		previous_tag=$10,
+		commits_linked=$11
+	`
+
+	_, err = tx.Exec(insert, r.ID, r.RepositoryID, r.Tag, r.Name, r.TargetSHA, r.Draft, r.Prerelease,
+		utcOrNil(r.Published), r.Author, r.PreviousTag, r.commitsLinked)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM release_assets WHERE release_id=$1", r.ID)
+	if err != nil {
+		return err
+	}
+	for _, a := range r.Assets {
+		_, err = tx.Exec("INSERT INTO release_assets (release_id, repository_id, name, download_count) VALUES ($1,$2,$3,$4)",
+			r.ID, r.RepositoryID, a.Name, a.Downloads)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !r.commitsLinked {
+		_, err = tx.Exec("DELETE FROM release_commits WHERE repository_id=$1 AND tag=$2", r.RepositoryID, r.Tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SaveReleaseCommits stores the commits of the release, and marks its commits as linked. They're kept apart
+// from the commits of the synced branches, which they'd otherwise add to.
+func (s *sqlStore) SaveReleaseCommits(repo_id int, tag string, commits []Commit) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM release_commits WHERE repository_id=$1 AND tag=$2", repo_id, tag)
+	if err != nil {
+		return err
+	}
+	for _, c := range commits {
+		_, err = tx.Exec(`INSERT INTO release_commits (repository_id, tag, sha, `+shippedCommitColumns+`)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) ON CONFLICT DO NOTHING`,
+			repo_id, tag, c.SHA, c.Message, c.URL, c.AuthorName, c.AuthorEmail, c.AuthorLogin, c.Date.UTC(), c.Committed.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE releases SET commits_linked=$3 WHERE repository_id=$1 AND tag=$2", repo_id, tag, true)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReleases returns the releases of the repository, latest published first with drafts at the top
+func (s *sqlStore) GetReleases(repo_id int) ([]Release, error) {
+	rows, err := s.db.Query(`SELECT id, repository_id, tag, name, target_sha, draft, prerelease, published_at,
+			author, previous_tag, commits_linked,
+			(SELECT coalesce(sum(download_count), 0) FROM release_assets a WHERE a.release_id = r.id),
+			(SELECT count(*) FROM release_commits c WHERE c.repository_id = r.repository_id AND c.tag = r.tag)
+		FROM releases r WHERE repository_id=$1
+		ORDER BY published_at IS NULL DESC, published_at DESC`, repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting releases from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []Release{}
+	index := map[int64]int{}
+	for rows.Next() {
+		r := Release{Assets: []ReleaseAsset{}}
+		err = rows.Scan(&r.ID, &r.RepositoryID, &r.Tag, &r.Name, &r.TargetSHA, &r.Draft, &r.Prerelease, &r.Published,
+			&r.Author, &r.PreviousTag, &r.commitsLinked, &r.Downloads, &r.Commits)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning release result : %v", err))
+			return nil, err
+		}
+
+		index[r.ID] = len(releases)
+		releases = append(releases, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// listed latest published first, the release published before one is the next published one
+	for i, r := range releases {
+		if r.Draft || r.Published == nil {
+			continue
+		}
+		for _, before := range releases[i+1:] {
+			if !before.Draft && before.Published != nil {
+				releases[i].SincePrevious = r.Published.Sub(*before.Published)
+				break
+			}
+		}
+	}
+
+	assets, err := s.db.Query("SELECT release_id, name, download_count FROM release_assets WHERE repository_id=$1 ORDER BY name", repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting release assets from db : %v", err))
+		return nil, err
+	}
+	defer assets.Close()
+
+	for assets.Next() {
+		var id int64
+		a := ReleaseAsset{}
+		err = assets.Scan(&id, &a.Name, &a.Downloads)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning release asset : %v", err))
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			releases[i].Assets = append(releases[i].Assets, a)
+		}
+	}
+
+	return releases, assets.Err()
+}
+
+// GetReleaseCommits returns the commits of the release with the given tag, newest first
+func (s *sqlStore) GetReleaseCommits(repo_id int, tag string) ([]Commit, error) {
+	var exists int
+	err := s.db.QueryRow("SELECT count(*) FROM releases WHERE repository_id=$1 AND tag=$2", repo_id, tag).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("release %q not found : %w", tag, sql.ErrNoRows)
+	}
+
+	rows, err := s.db.Query(`SELECT repository_id, sha, `+shippedCommitColumns+` FROM release_commits
+		WHERE repository_id=$1 AND tag=$2 ORDER BY date DESC, sha DESC`, repo_id, tag)
+	if err != nil {
+		LogError(fmt.Errorf("error getting release commits from db : %v", err))
+		return nil, err
+	}
+
+	return scanShippedCommits(rows)
+}
+
+// shippedCommitColumns lists what release_commits and deployment_commits keep of the commits they shipped
+const shippedCommitColumns = "message, url, author_name, author_email, author_login, date, committed_at"
+
+// scanShippedCommits scans the repository_id, sha and shippedCommitColumns of the rows into commits, and closes them
+func scanShippedCommits(rows *sql.Rows) ([]Commit, error) {
+	defer rows.Close()
+
+	commits := []Commit{}
+	for rows.Next() {
+		c := Commit{Parents: []string{}}
+		var date, committed *time.Time
+		err := rows.Scan(&c.RepositoryID, &c.SHA, &c.Message, &c.URL, &c.AuthorName, &c.AuthorEmail, &c.AuthorLogin,
+			&date, &committed)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning commit result : %v", err))
+			return nil, err
+		}
+		if date != nil {
+			c.Date = *date
+		}
+		if committed != nil {
+			c.Committed = *committed
+		}
+
+		commits = append(commits, c)
+	}
+
+	return commits, rows.Err()
+}
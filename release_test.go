@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestPreviousTags(t *testing.T) {
+	at := func(day int) *time.Time {
+		d := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+
+	tags := []Tag{
+		{Name: "v1.1.0", SHA: "b", Committed: at(10)},
+		{Name: "v1.0.0", SHA: "a", Committed: at(1)},
+		{Name: "v1.0.1", SHA: "a2", Committed: at(5)},
+		{Name: "latest", SHA: "b", Committed: at(10)},
+		{Name: "v2.0.0", SHA: "c", Committed: at(20)},
+		{Name: "unknown", SHA: "d"},
+	}
+	want := map[string]string{
+		"v1.0.0":  "",
+		"v1.0.1":  "v1.0.0",
+		"v1.1.0":  "v1.0.1",
+		"latest":  "v1.0.1",
+		"v2.0.0":  "v1.1.0",
+		"unknown": "",
+	}
+
+	previous := previousTags(tags)
+	for tag, w := range want {
+		if previous[tag] != w {
+			t.Errorf("previous tag of %s = %q, want %q", tag, previous[tag], w)
+		}
+	}
+}
+
+func TestReleaseCommitsStayOutOfHistory(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	_, err := s.SaveCommits("main", []Commit{testCommit(repo.ID, "a", 0)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	err = s.SaveRelease(&Release{ID: 1, RepositoryID: repo.ID, Tag: "v1", Published: &published, PreviousTag: "v0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b is only on a release branch that isn't synced
+	err = s.SaveReleaseCommits(repo.ID, "v1", []Commit{testCommit(repo.ID, "b", 2), testCommit(repo.ID, "a", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := s.ListCommits(repo.ID, CommitFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shas(commits) != "a" {
+		t.Errorf("stored commits = %q, want a", shas(commits))
+	}
+
+	released, err := s.GetReleaseCommits(repo.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shas(released) != "ba" || released[0].Message != "commit b" || released[0].AuthorName != "Jane Doe" {
+		t.Errorf("release commits = %+v", released)
+	}
+
+	// the delivery metrics take the dates of the commits from the synced history
+	shipped, err := s.GetReleasedCommits(repo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shipped) != 1 || shipped[0].SHA != "a" || !shipped[0].Shipped.Equal(published) {
+		t.Errorf("released commits = %+v", shipped)
+	}
+}
+
+func TestDateTags(t *testing.T) {
+	s := newTestStore(t)
+	fetched := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sha := path.Base(r.URL.Path)
+		fetched = append(fetched, sha)
+		c := githubCommit{SHA: sha}
+		c.Commit.Author.Date = time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+		json.NewEncoder(w).Encode(c)
+	}))
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+
+	_, err := s.SaveCommits("main", []Commit{testCommit(repo.ID, "a", 1)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)
+	err = s.SaveTags(repo.ID, []Tag{{RepositoryID: repo.ID, Name: "v1", SHA: "b", Committed: &tagged}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v1 keeps its date, v2 takes the date of the synced commit, and only the commit of v3 is fetched
+	tags := []Tag{{Name: "v1", SHA: "b"}, {Name: "v2", SHA: "a"}, {Name: "v3", SHA: "c"}}
+	err = dateTags(repo, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{5, 1, 9} {
+		if tags[i].Committed == nil || tags[i].Committed.Hour() != want {
+			t.Errorf("%s committed at %v, want %d:00", tags[i].Name, tags[i].Committed, want)
+		}
+	}
+	if len(fetched) != 1 || fetched[0] != "c" {
+		t.Errorf("fetched commits = %v, want c only", fetched)
+	}
+}
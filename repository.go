@@ -3,8 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 )
 
@@ -21,17 +19,12 @@ type Repository struct {
 	Created         time.Time `json:"created_at" db:"created_at"`
 	Pushed          time.Time `json:"pushed_at" db:"pushed_at"`
 	Updated         time.Time `json:"updated_at" db:"updated_at"`
+	Host            string    `json:"host" db:"host"`
+	DefaultBranch   string    `json:"default_branch" db:"default_branch"`
 }
 
-// Save saves the given repository metadata to the repositories table
-func (r *Repository) Save() error {
-	// get db connection instance
-	db, err := SQLConnect()
-	if err != nil {
-		fmt.Println("Error connecting to the database : ", err)
-		return err
-	}
-
+// SaveRepo saves the given repository metadata to the repositories table
+func (s *sqlStore) SaveRepo(r *Repository) error {
 	// insert statement
 	insert := `insert into repositories (
 		name,
@@ -44,19 +37,23 @@ func (r *Repository) Save() error {
 		watchers_count,
 		created_at,
 		pushed_at,
-		updated_at
-	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		updated_at,
+		host,
+		default_branch
+	) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
 	ON CONFLICT (url) DO UPDATE SET 
 		language=$4,
 		forks_count=$5,
 		stars_count=$6,
 		open_issues_count=$7,
 		watchers_count=$8,
-		updated_at=$11
+		updated_at=$11,
+		host=$12,
+		default_branch=$13
 	`
 
 	// execute insert statement
-	_, err = db.Exec(insert,
+	_, err := s.db.Exec(insert,
 		r.Name,
 		r.Description,
 		r.URL,
@@ -65,96 +62,134 @@ func (r *Repository) Save() error {
 		r.StarsCount,
 		r.OpenIssuesCount,
 		r.WatchersCount,
-		r.Created,
-		r.Pushed,
-		r.Updated)
+		r.Created.UTC(),
+		r.Pushed.UTC(),
+		r.Updated.UTC(),
+		r.Host,
+		r.DefaultBranch)
 
 	return err
 }
 
 // FetchRepo fetchs the repository metadata from github, stores it, and return it
 func FetchRepo(repo_url string) (*Repository, error) {
-	req, err := NewRequest("GET", repo_url, nil)
+	_, body, validators, err := github.GetIfChanged(repo_url)
+	if err == ErrNotModified {
+		// unchanged since the last fetch, use the stored metadata
+		stored, dbErr := store.GetRepoByURL(repo_url)
+		if dbErr == nil {
+			return stored, nil
+		}
+
+		// nothing stored to fall back on, fetch it again
+		_, body, err = github.Get(repo_url)
+	}
 	if err != nil {
-		LogError(fmt.Errorf("error creating repository request : %v", err))
+		err = fmt.Errorf("error fetching repo : %v", err)
+		LogError(err)
 		return nil, err
 	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	repo := new(Repository)
+	err = json.Unmarshal(body, &repo)
 	if err != nil {
-		LogError(fmt.Errorf("error with repository request : %v", err))
+		LogError(fmt.Errorf("error parsing repository metadata : %v", err))
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			LogError(fmt.Errorf("error reading repository response  : %v", err))
-			return nil, err
-		}
+	// record which github host the repository came from
+	repo.Host = DefaultHost.Name
+	if h, err := HostForAPIURL(repo_url); err == nil {
+		repo.Host = h.Name
+	}
 
-		repo := new(Repository)
-		err = json.Unmarshal(body, &repo)
-		if err != nil {
-			LogError(fmt.Errorf("error parsing repository metadata : %v", err))
-			return nil, err
-		}
+	err = store.SaveRepo(repo)
+	if err != nil {
+		LogError(fmt.Errorf("error saving repository metadata : %v", err))
+		return repo, err
+	}
+	saveValidators(validators)
+
+	return repo, nil
+}
 
-		err = repo.Save()
+// repositoryColumns lists the columns scanned into a Repository, in order
+const repositoryColumns = `id, name, description, url, language,
+	forks_count, stars_count, open_issues_count,
+	watchers_count, created_at, pushed_at, updated_at, host, default_branch`
+
+func (s *sqlStore) GetRepos() ([]Repository, error) {
+	rows, err := s.db.Query("SELECT " + repositoryColumns + " FROM repositories ORDER BY id")
+	if err != nil {
+		LogError(fmt.Errorf("error getting repositories from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []Repository{}
+	for rows.Next() {
+		r, err := scanRepository(rows)
 		if err != nil {
-			LogError(fmt.Errorf("error saving repository metadata : %v", err))
-			return repo, err
+			LogError(fmt.Errorf("error scanning repository result  : %v", err))
+			return nil, err
 		}
 
-		return repo, nil
-	} else {
-		err = fmt.Errorf("error fetching repo : %v, %v", resp.StatusCode, resp.Status)
-		LogError(err)
-		return nil, err
+		repos = append(repos, *r)
 	}
+
+	return repos, rows.Err()
+}
+
+// RepoFilter selects repositories, zero fields don't filter
+type RepoFilter struct {
+	Language string
+	MinStars int
 }
 
-func GetRepos() ([]Repository, error) {
-	db, err := SQLConnect()
+// ListRepos returns the tracked repositories matching the filter, the language is matched whatever its case
+func (s *sqlStore) ListRepos(f RepoFilter) ([]Repository, error) {
+	q := newQuery("SELECT " + repositoryColumns + " FROM repositories WHERE 1=1")
+	if f.Language != "" {
+		q.where("LOWER(language) = LOWER($%d)", f.Language)
+	}
+	if f.MinStars > 0 {
+		q.where("stars_count >= $%d", f.MinStars)
+	}
+	q.sql += " ORDER BY id"
+
+	rows, err := s.db.Query(q.sql, q.args...)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
+		LogError(fmt.Errorf("error listing repositories : %v", err))
 		return nil, err
 	}
+	defer rows.Close()
 
 	repos := []Repository{}
-	rows, err := db.Query("SELECT * FROM repositories")
 	for rows.Next() {
-		r := Repository{}
-		err = rows.Scan(&r.ID,
-			&r.Name, &r.Description, &r.URL, &r.Language,
-			&r.ForksCount, &r.StarsCount, &r.OpenIssuesCount,
-			&r.WatchersCount, &r.Created, &r.Pushed, &r.Updated)
+		r, err := scanRepository(rows)
 		if err != nil {
 			LogError(fmt.Errorf("error scanning repository result  : %v", err))
 			return nil, err
 		}
 
-		repos = append(repos, r)
+		repos = append(repos, *r)
 	}
 
-	return repos, nil
+	return repos, rows.Err()
 }
 
-func GetRepoByID(id int) (*Repository, error) {
-	db, err := SQLConnect()
+func (s *sqlStore) GetRepoByID(id int) (*Repository, error) {
+	r, err := scanRepository(s.db.QueryRow("SELECT "+repositoryColumns+" FROM repositories WHERE id=$1", id))
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
+		LogError(fmt.Errorf("error scanning repository response : %v", err))
 		return nil, err
 	}
 
-	r := new(Repository)
-	row := db.QueryRow("SELECT * FROM repositories WHERE id=$1", id)
-	err = row.Scan(&r.ID,
-		&r.Name, &r.Description, &r.URL, &r.Language,
-		&r.ForksCount, &r.StarsCount, &r.OpenIssuesCount,
-		&r.WatchersCount, &r.Created, &r.Pushed, &r.Updated)
+	return r, nil
+}
+
+func (s *sqlStore) GetRepoByURL(repo_url string) (*Repository, error) {
+	r, err := scanRepository(s.db.QueryRow("SELECT "+repositoryColumns+" FROM repositories WHERE url=$1", repo_url))
 	if err != nil {
 		LogError(fmt.Errorf("error scanning repository response : %v", err))
 		return nil, err
@@ -163,52 +198,87 @@ func GetRepoByID(id int) (*Repository, error) {
 	return r, nil
 }
 
-func GetRepoByURL(repo_url string) (*Repository, error) {
-	db, err := SQLConnect()
+// repositoryTables lists the tables holding data of a repository, in the order they're deleted
+var repositoryTables = []string{
+	"commit_branches",
+	"commit_files",
+	"commits",
+	"sync_cursors",
+	"branches",
+	"pull_request_commits",
+	"pull_request_reviews",
+	"pull_requests",
+	"issue_assignees",
+	"issue_labels",
+	"issues",
+	"workflow_runs",
+	"workflows",
+	"deployment_commits",
+	"deployment_statuses",
+	"deployments",
+	"release_commits",
+	"release_assets",
+	"releases",
+	"tags",
+	"repository_snapshots",
+	"resource_cursors",
+}
+
+// DeleteRepo removes the repository along with everything collected about it and its cached responses
+func (s *sqlStore) DeleteRepo(id int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		fmt.Println("Error connecting to the database : ", err)
-		return nil, err
+		return err
 	}
+	defer tx.Rollback()
 
-	r := new(Repository)
-	row := db.QueryRow("SELECT * FROM repositories WHERE url=$1", repo_url)
-	err = row.Scan(&r.ID,
-		&r.Name, &r.Description, &r.URL, &r.Language,
-		&r.ForksCount, &r.StarsCount, &r.OpenIssuesCount,
-		&r.WatchersCount, &r.Created, &r.Pushed, &r.Updated)
+	var repo_url string
+	err = tx.QueryRow("SELECT url FROM repositories WHERE id=$1", id).Scan(&repo_url)
 	if err != nil {
-		LogError(fmt.Errorf("error scanning repository response : %v", err))
+		LogError(fmt.Errorf("error getting repository to delete : %v", err))
+		return err
 	}
 
-	return r, nil
-}
+	// children before the tables they reference
+	for _, table := range repositoryTables {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE repository_id=$1", id)
+		if err != nil {
+			LogError(fmt.Errorf("error deleting from %s : %v", table, err))
+			return err
+		}
+	}
+
+	// a prefix comparison rather than LIKE, _ and % are valid in repository names
+	prefix := repo_url + "/"
+	_, err = tx.Exec("DELETE FROM http_cache WHERE url=$1 OR substr(url, 1, $2) = $3", repo_url, len(prefix), prefix)
+	if err != nil {
+		LogError(fmt.Errorf("error deleting cache entries : %v", err))
+		return err
+	}
 
-// init function to create the repositories table if it doesn't exist already
-func init() {
-	// make sure repositories table exists
-	db, err := SQLConnect()
+	_, err = tx.Exec("DELETE FROM repositories WHERE id=$1", id)
 	if err != nil {
-		LogError(fmt.Errorf("error connecting to the database : %v", err))
-		return
-	}
-
-	create := `CREATE TABLE IF NOT EXISTS repositories (
-		id SERIAL PRIMARY KEY,
-		name varchar(255) NOT NULL,
-		description varchar(255),
-		url varchar(255) UNIQUE,
-		language varchar(255),
-		forks_count int,
-		stars_count int,
-		open_issues_count int,
-		watchers_count int,
-		created_at timestamp,
-		pushed_at timestamp,
-		updated_at timestamp
-	)`
-
-	_, err = db.Exec(create)
+		LogError(fmt.Errorf("error deleting repository : %v", err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRepository(row scanner) (*Repository, error) {
+	r := new(Repository)
+	err := row.Scan(&r.ID,
+		&r.Name, &r.Description, &r.URL, &r.Language,
+		&r.ForksCount, &r.StarsCount, &r.OpenIssuesCount,
+		&r.WatchersCount, &r.Created, &r.Pushed, &r.Updated, &r.Host, &r.DefaultBranch)
 	if err != nil {
-		LogError(fmt.Errorf("error creating repositories table : %v", err))
+		return nil, err
 	}
+
+	return r, nil
 }
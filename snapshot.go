@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RepoSnapshot records the counters of a repository at the time of a refresh
+type RepoSnapshot struct {
+	RepositoryID    int       `json:"repository_id" db:"repository_id"`
+	Taken           time.Time `json:"taken_at" db:"taken_at"`
+	StarsCount      int       `json:"stars_count" db:"stars_count"`
+	ForksCount      int       `json:"forks_count" db:"forks_count"`
+	OpenIssuesCount int       `json:"open_issues_count" db:"open_issues_count"`
+	WatchersCount   int       `json:"watchers_count" db:"watchers_count"`
+}
+
+// RepoTrend is how much the counters of a repository changed since the start of a window.
+// From is the snapshot the changes are counted from, the last one taken before the window started
+// or the first one taken during it when the repository wasn't tracked yet.
+type RepoTrend struct {
+	RepositoryID    int       `json:"repository_id"`
+	Window          string    `json:"window"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	StarsCount      int       `json:"stars_count"`
+	ForksCount      int       `json:"forks_count"`
+	OpenIssuesCount int       `json:"open_issues_count"`
+	WatchersCount   int       `json:"watchers_count"`
+}
+
+// trend windows
+const (
+	WindowDay   = "day"
+	WindowWeek  = "week"
+	WindowMonth = "month"
+)
+
+// windowStart returns when a day, week or month long window ending now started
+func windowStart(window string, now time.Time) (time.Time, error) {
+	switch window {
+	case WindowDay:
+		return now.AddDate(0, 0, -1), nil
+	case WindowWeek:
+		return now.AddDate(0, 0, -7), nil
+	case WindowMonth:
+		return now.AddDate(0, -1, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid window %q, use day, week or month", window)
+}
+
+// snapshotOf returns the current counters of the repository
+func snapshotOf(r *Repository, taken time.Time) RepoSnapshot {
+	return RepoSnapshot{
+		RepositoryID:    r.ID,
+		Taken:           taken,
+		StarsCount:      r.StarsCount,
+		ForksCount:      r.ForksCount,
+		OpenIssuesCount: r.OpenIssuesCount,
+		WatchersCount:   r.WatchersCount,
+	}
+}
+
+// trendMark formats a change next to a counter, nothing when it didn't change
+func trendMark(delta int) string {
+	switch {
+	case delta > 0:
+		return " (+" + strconv.Itoa(delta) + ")"
+	case delta < 0:
+		return " (" + strconv.Itoa(delta) + ")"
+	}
+	return ""
+}
+
+// SaveSnapshot records the counters of a repository
+func (s *sqlStore) SaveSnapshot(snap RepoSnapshot) error {
+	_, err := s.db.Exec(`INSERT INTO repository_snapshots
+		(repository_id, taken_at, stars_count, forks_count, open_issues_count, watchers_count)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		ON CONFLICT (repository_id, taken_at) DO NOTHING`,
+		snap.RepositoryID, snap.Taken.UTC(), snap.StarsCount, snap.ForksCount, snap.OpenIssuesCount, snap.WatchersCount)
+	if err != nil {
+		LogError(fmt.Errorf("error saving repository snapshot : %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// snapshotColumns lists the columns scanned into a RepoSnapshot, in order
+const snapshotColumns = `repository_id, taken_at, stars_count, forks_count, open_issues_count, watchers_count`
+
+func scanSnapshot(row scanner) (*RepoSnapshot, error) {
+	snap := new(RepoSnapshot)
+	err := row.Scan(&snap.RepositoryID, &snap.Taken, &snap.StarsCount, &snap.ForksCount, &snap.OpenIssuesCount, &snap.WatchersCount)
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// GetSnapshots returns the snapshots of the repository taken since the given time, oldest first
+func (s *sqlStore) GetSnapshots(repo_id int, since time.Time) ([]RepoSnapshot, error) {
+	rows, err := s.db.Query("SELECT "+snapshotColumns+` FROM repository_snapshots
+		WHERE repository_id=$1 AND taken_at >= $2 ORDER BY taken_at`, repo_id, since.UTC())
+	if err != nil {
+		LogError(fmt.Errorf("error getting repository snapshots from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	snaps := []RepoSnapshot{}
+	for rows.Next() {
+		snap, err := scanSnapshot(rows)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning repository snapshot : %v", err))
+			return nil, err
+		}
+		snaps = append(snaps, *snap)
+	}
+
+	return snaps, rows.Err()
+}
+
+// GetTrend returns how much the counters of the repository changed over the window ending now.
+// Without snapshots every change is zero.
+func (s *sqlStore) GetTrend(repo_id int, window string) (*RepoTrend, error) {
+	now := time.Now()
+	start, err := windowStart(window, now)
+	if err != nil {
+		return nil, err
+	}
+	trend := &RepoTrend{RepositoryID: repo_id, Window: window, From: start, To: now}
+
+	latest, err := scanSnapshot(s.db.QueryRow("SELECT "+snapshotColumns+` FROM repository_snapshots
+		WHERE repository_id=$1 ORDER BY taken_at DESC LIMIT 1`, repo_id))
+	if err == sql.ErrNoRows {
+		return trend, nil
+	}
+	if err != nil {
+		LogError(fmt.Errorf("error getting the latest repository snapshot : %v", err))
+		return nil, err
+	}
+
+	base, err := scanSnapshot(s.db.QueryRow("SELECT "+snapshotColumns+` FROM repository_snapshots
+		WHERE repository_id=$1 AND taken_at <= $2 ORDER BY taken_at DESC LIMIT 1`, repo_id, start.UTC()))
+	if err == sql.ErrNoRows {
+		base, err = scanSnapshot(s.db.QueryRow("SELECT "+snapshotColumns+` FROM repository_snapshots
+			WHERE repository_id=$1 ORDER BY taken_at LIMIT 1`, repo_id))
+	}
+	if err != nil {
+		LogError(fmt.Errorf("error getting the repository snapshot the window starts from : %v", err))
+		return nil, err
+	}
+
+	trend.From = base.Taken
+	trend.To = latest.Taken
+	trend.StarsCount = latest.StarsCount - base.StarsCount
+	trend.ForksCount = latest.ForksCount - base.ForksCount
+	trend.OpenIssuesCount = latest.OpenIssuesCount - base.OpenIssuesCount
+	trend.WatchersCount = latest.WatchersCount - base.WatchersCount
+	return trend, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrend(t *testing.T) {
+	s := newTestStore(t)
+	repo := newTestRepo(t, s, "repo")
+
+	trend, err := s.GetTrend(repo.ID, WindowWeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trend.StarsCount != 0 || trend.ForksCount != 0 {
+		t.Errorf("trend without snapshots = %+v", trend)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	snapshots := []RepoSnapshot{
+		{RepositoryID: repo.ID, Taken: now.AddDate(0, 0, -10), StarsCount: 10, ForksCount: 2},
+		{RepositoryID: repo.ID, Taken: now.AddDate(0, 0, -3), StarsCount: 15, ForksCount: 2},
+		{RepositoryID: repo.ID, Taken: now.Add(-time.Hour), StarsCount: 18, ForksCount: 1},
+		// a second refresh at the same time keeps the first snapshot
+		{RepositoryID: repo.ID, Taken: now.Add(-time.Hour), StarsCount: 99},
+	}
+	for _, snap := range snapshots {
+		err = s.SaveSnapshot(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.GetSnapshots(repo.ID, now.AddDate(0, 0, -5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].StarsCount != 15 || got[1].StarsCount != 18 {
+		t.Errorf("snapshots of the last 5 days = %+v", got)
+	}
+
+	tests := []struct {
+		window       string
+		from         time.Time
+		stars, forks int
+	}{
+		{WindowDay, now.AddDate(0, 0, -3), 3, -1},
+		{WindowWeek, now.AddDate(0, 0, -10), 8, -1},
+		// the repository wasn't tracked a month ago, the changes count from its first snapshot
+		{WindowMonth, now.AddDate(0, 0, -10), 8, -1},
+	}
+	for _, tt := range tests {
+		trend, err := s.GetTrend(repo.ID, tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !trend.From.Equal(tt.from) || !trend.To.Equal(now.Add(-time.Hour)) || trend.StarsCount != tt.stars ||
+			trend.ForksCount != tt.forks {
+			t.Errorf("%s trend = %+v, want %d stars and %d forks from %v", tt.window, trend, tt.stars, tt.forks, tt.from)
+		}
+	}
+
+	if _, err = s.GetTrend(repo.ID, "year"); err == nil {
+		t.Error("year window accepted")
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		window string
+		want   time.Time
+	}{
+		{WindowDay, time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC)},
+		{WindowWeek, time.Date(2024, 3, 24, 12, 0, 0, 0, time.UTC)},
+		// february has no 31st, AddDate normalizes it
+		{WindowMonth, time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := windowStart(tt.window, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s window starts at %v, want %v", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestTrendMark(t *testing.T) {
+	tests := map[int]string{0: "", 3: " (+3)", -2: " (-2)"}
+	for delta, want := range tests {
+		if got := trendMark(delta); got != want {
+			t.Errorf("trendMark(%d) = %q, want %q", delta, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+
+	"modernc.org/sqlite"
+)
+
+// sqlite has the REGEXP operator but no function behind it, X REGEXP Y calls regexp(Y, X)
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("regexp pattern must be text")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		var text string
+		switch v := args[1].(type) {
+		case nil:
+			return false, nil
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		default:
+			text = fmt.Sprint(v)
+		}
+		return re.MatchString(text), nil
+	})
+}
+
+// NewSQLiteStore opens, or creates, the sqlite database file at path
+func NewSQLiteStore(path string) (Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		LogError(fmt.Errorf("error opening sqlite db : %v", err))
+		return nil, err
+	}
+
+	// sqlite allows a single writer, serialize access instead of failing with database is locked
+	db.SetMaxOpenConns(1)
+
+	return &sqlStore{db: db, dialect: "sqlite"}, nil
+}
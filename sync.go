@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SyncCursor records how far the commits of a branch of a repository have been synced, the default branch has an empty name.
+// NewestSHA and NewestDate mark the newest commit known to be stored with everything after it,
+// BackfillURL is the next page of an initial sync that hasn't completed yet.
+type SyncCursor struct {
+	RepositoryID int       `json:"repository_id" db:"repository_id"`
+	Branch       string    `json:"branch" db:"branch"`
+	NewestSHA    string    `json:"newest_sha" db:"newest_sha"`
+	NewestDate   time.Time `json:"newest_date" db:"newest_date"`
+	BackfillURL  string    `json:"backfill_url" db:"backfill_url"`
+	Updated      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// SyncResult summarizes a sync
+type SyncResult struct {
+	Fetched  int  `json:"fetched"`
+	Added    int  `json:"added"`
+	Complete bool `json:"complete"`
+}
+
+// repoLocks holds a mutex per repository id so the same repository is never synced twice at once
+type repoLocks struct {
+	mu    sync.Mutex
+	locks map[int]*sync.Mutex
+}
+
+var syncLocks = &repoLocks{locks: map[int]*sync.Mutex{}}
+
+func (l *repoLocks) get(repo_id int) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m, ok := l.locks[repo_id]
+	if !ok {
+		m = new(sync.Mutex)
+		l.locks[repo_id] = m
+	}
+	return m
+}
+
+// lock waits until the repository is free and locks it
+func (l *repoLocks) lock(repo_id int) func() {
+	m := l.get(repo_id)
+	m.Lock()
+	return m.Unlock
+}
+
+// tryLock locks the repository unless it's already being synced, ok tells if it did
+func (l *repoLocks) tryLock(repo_id int) (unlock func(), ok bool) {
+	m := l.get(repo_id)
+	if !m.TryLock() {
+		return nil, false
+	}
+	return m.Unlock, true
+}
+
+// SyncCommits merges the commits made since the last sync into the stored history, then resumes
+// the backfill of older commits if a previous sync was interrupted. Nothing stored is deleted, and
+// the cursor is saved with each page so an interruption loses at most the page in flight.
+// The default branch is synced first, then every branch tracked for the repository.
+func SyncCommits(repo *Repository) (*SyncResult, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncCommits(repo)
+}
+
+// syncCommits is SyncCommits for callers already holding the repository lock
+func syncCommits(repo *Repository) (*SyncResult, error) {
+	result := new(SyncResult)
+
+	branches, err := syncedBranches(repo)
+	if err != nil {
+		return result, err
+	}
+
+	for _, branch := range branches {
+		err = syncBranch(repo, branch, result)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result.Complete = true
+	return result, nil
+}
+
+// syncedBranches lists the branches whose commits are synced, the default branch being the empty name
+func syncedBranches(repo *Repository) ([]string, error) {
+	branches, err := store.GetBranches(repo.ID)
+	if err != nil {
+		LogError(fmt.Errorf("error getting branches : %v", err))
+		return nil, err
+	}
+
+	names := []string{""}
+	for _, b := range branches {
+		if b.Tracked && !b.Default {
+			names = append(names, b.Name)
+		}
+	}
+	return names, nil
+}
+
+// branchName is the name commits synced from branch are recorded under, empty while the default branch is unknown
+func branchName(repo *Repository, branch string) string {
+	if branch == "" {
+		return repo.DefaultBranch
+	}
+	return branch
+}
+
+// syncBranch syncs the commits of a single branch, see SyncCommits
+func syncBranch(repo *Repository, branch string, result *SyncResult) error {
+	cursor, err := store.GetSyncCursor(repo.ID, branch)
+	if err != nil {
+		LogError(fmt.Errorf("error getting sync cursor : %v", err))
+		return err
+	}
+
+	if cursor == nil {
+		// first sync, backfill the whole history starting from the newest commit
+		cursor = &SyncCursor{RepositoryID: repo.ID, Branch: branch, BackfillURL: commitsURL(repo.URL, branch, nil)}
+	} else {
+		err = catchUp(repo, cursor, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return backfill(repo, cursor, result)
+}
+
+// catchUp fetches the commits added to the branch since the newest synced one, paging from its head until that
+// one is reached. Commits merged from other branches can come after it, so paging goes on while the pages still hold
+// commits that weren't stored. The cursor, and the validators of the head page, are only saved once every page has
+// been stored, so an interrupted catch up starts over from the head rather than leaving a gap.
+func catchUp(repo *Repository, cursor *SyncCursor, result *SyncResult) error {
+	newest := *cursor
+	branch := branchName(repo, cursor.Branch)
+	URL := commitsURL(repo.URL, cursor.Branch, nil)
+	var validators *CacheEntry
+	reached := false
+
+	for page := 0; URL != ""; page++ {
+		var commits []Commit
+		var next string
+		var err error
+		if page == 0 {
+			// the head page is unchanged when there's nothing new
+			commits, next, validators, err = fetchCommitPageIfChanged(repo, URL)
+			if err == ErrNotModified {
+				LogApp(fmt.Sprintf("no new commits for %s %s", repo.Name, branch))
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(commits) > 0 {
+				newest.NewestSHA = commits[0].SHA
+				newest.NewestDate = commits[0].Date
+			}
+		} else {
+			commits, next, err = fetchCommitPage(repo, URL)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the commits listed after the newest synced one are older, split the page there
+		split := len(commits)
+		if reached {
+			split = 0
+		}
+		for i, c := range commits {
+			if !reached && c.SHA == cursor.NewestSHA {
+				split = i + 1
+				reached = true
+				break
+			}
+		}
+
+		added, err := store.SaveCommits(branch, commits[:split], nil)
+		if err != nil {
+			LogError(fmt.Errorf("error saving commits : %v", err))
+			return err
+		}
+		older, err := store.SaveCommits(branch, commits[split:], nil)
+		if err != nil {
+			LogError(fmt.Errorf("error saving commits : %v", err))
+			return err
+		}
+		result.Fetched += len(commits)
+		result.Added += added + older
+
+		if reached && split < len(commits) && older == 0 {
+			break
+		}
+		URL = next
+	}
+
+	newest.Updated = time.Now()
+	_, err := store.SaveCommits("", nil, &newest)
+	if err != nil {
+		LogError(fmt.Errorf("error saving sync cursor : %v", err))
+		return err
+	}
+	*cursor = newest
+	saveValidators(validators)
+
+	return nil
+}
+
+// backfill follows the pages of an initial sync from where it was left, saving the cursor with each page
+func backfill(repo *Repository, cursor *SyncCursor, result *SyncResult) error {
+	for cursor.BackfillURL != "" {
+		commits, next, err := fetchCommitPage(repo, cursor.BackfillURL)
+		if err != nil {
+			return err
+		}
+
+		// the first page of the history holds the newest commit
+		if cursor.NewestSHA == "" && len(commits) > 0 {
+			cursor.NewestSHA = commits[0].SHA
+			cursor.NewestDate = commits[0].Date
+		}
+		cursor.BackfillURL = next
+		cursor.Updated = time.Now()
+
+		added, err := store.SaveCommits(branchName(repo, cursor.Branch), commits, cursor)
+		if err != nil {
+			LogError(fmt.Errorf("error saving commits : %v", err))
+			return err
+		}
+		result.Fetched += len(commits)
+		result.Added += added
+	}
+
+	return nil
+}
+
+// PullCommitsSince merges the commits made since the given date on the default and tracked branches
+// into the stored history, without touching the sync cursors or any older commits
+func PullCommitsSince(repo *Repository, since time.Time) (*SyncResult, error) {
+	defer syncLocks.lock(repo.ID)()
+
+	result := new(SyncResult)
+	branches, err := syncedBranches(repo)
+	if err != nil {
+		return result, err
+	}
+
+	for _, branch := range branches {
+		URL := commitsURL(repo.URL, branch, &since)
+		for URL != "" {
+			commits, next, err := fetchCommitPage(repo, URL)
+			if err != nil {
+				return result, err
+			}
+
+			added, err := store.SaveCommits(branchName(repo, branch), commits, nil)
+			if err != nil {
+				LogError(fmt.Errorf("error saving commits : %v", err))
+				return result, err
+			}
+			result.Fetched += len(commits)
+			result.Added += added
+
+			URL = next
+		}
+	}
+
+	result.Complete = true
+	return result, nil
+}
+
+// BranchCommits is the full history of a branch along with its sync cursor
+type BranchCommits struct {
+	Branch  string
+	Commits []Commit
+	Cursor  *SyncCursor
+}
+
+// ResyncCommits fetches the whole history of the default and tracked branches again and replaces the
+// stored commits with it in a single transaction. If anything fails along the way the stored commits are left untouched.
+func ResyncCommits(repo *Repository) (*SyncResult, error) {
+	defer syncLocks.lock(repo.ID)()
+
+	result := new(SyncResult)
+	branches, err := syncedBranches(repo)
+	if err != nil {
+		return result, err
+	}
+
+	histories := []BranchCommits{}
+	for _, branch := range branches {
+		all := []Commit{}
+		URL := commitsURL(repo.URL, branch, nil)
+		for URL != "" {
+			commits, next, err := fetchCommitPage(repo, URL)
+			if err != nil {
+				return result, err
+			}
+			all = append(all, commits...)
+			URL = next
+		}
+		result.Fetched += len(all)
+
+		cursor := &SyncCursor{RepositoryID: repo.ID, Branch: branch, Updated: time.Now()}
+		if len(all) > 0 {
+			cursor.NewestSHA = all[0].SHA
+			cursor.NewestDate = all[0].Date
+		}
+		histories = append(histories, BranchCommits{branchName(repo, branch), all, cursor})
+	}
+
+	added, err := store.ReplaceCommits(repo.ID, histories)
+	if err != nil {
+		LogError(fmt.Errorf("error replacing commits : %v", err))
+		return result, err
+	}
+
+	result.Added = added
+	result.Complete = true
+	return result, nil
+}
+
+// GetSyncCursor returns the sync cursor of a branch of the repository, nil if it was never synced
+func (s *sqlStore) GetSyncCursor(repo_id int, branch string) (*SyncCursor, error) {
+	c := new(SyncCursor)
+	row := s.db.QueryRow(`SELECT repository_id, branch, newest_sha, newest_date, backfill_url, updated_at
+		FROM sync_cursors WHERE repository_id=$1 AND branch=$2`, repo_id, branch)
+	err := row.Scan(&c.RepositoryID, &c.Branch, &c.NewestSHA, &c.NewestDate, &c.BackfillURL, &c.Updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SaveCommits stores the commits as reachable from branch, unless it's empty, along with the cursor if given,
+// in one transaction. It returns how many commits were new.
+func (s *sqlStore) SaveCommits(branch string, commits []Commit, cursor *SyncCursor) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	added, err := insertCommits(tx, branch, commits)
+	if err != nil {
+		return 0, err
+	}
+
+	if cursor != nil {
+		err = saveSyncCursor(tx, cursor)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+// ReplaceCommits deletes the stored commits and cursors of the repository and stores the given histories instead,
+// in one transaction. It returns how many distinct commits were stored.
+func (s *sqlStore) ReplaceCommits(repo_id int, histories []BranchCommits) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"DELETE FROM commit_branches WHERE repository_id=$1",
+		"DELETE FROM commits WHERE repository_id=$1",
+		"DELETE FROM sync_cursors WHERE repository_id=$1",
+	} {
+		_, err = tx.Exec(stmt, repo_id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	added := 0
+	for _, h := range histories {
+		n, err := insertCommits(tx, h.Branch, h.Commits)
+		if err != nil {
+			return 0, err
+		}
+		added += n
+
+		err = saveSyncCursor(tx, h.Cursor)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// changed files are kept for the commits still there, no need to fetch them again
+	for _, stmt := range []string{
+		"DELETE FROM commit_files WHERE repository_id=$1 AND sha NOT IN (SELECT sha FROM commits WHERE repository_id=$1)",
+		"UPDATE commits SET files_fetched = true WHERE repository_id=$1 AND (sha IN (SELECT sha FROM commit_files WHERE repository_id=$1) OR " + mergeCondition + ")",
+	} {
+		_, err = tx.Exec(stmt, repo_id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+func insertCommits(tx *sql.Tx, branch string, commits []Commit) (int, error) {
+	added := 0
+	for i := range commits {
+		res, err := insertCommit(tx, &commits[i])
+		if err != nil {
+			return added, fmt.Errorf("error saving commit %s : %v", commits[i].SHA, err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			added += int(n)
+		}
+
+		if branch != "" {
+			_, err = tx.Exec(`INSERT INTO commit_branches (repository_id, branch, sha) VALUES ($1,$2,$3)
+				ON CONFLICT DO NOTHING`, commits[i].RepositoryID, branch, commits[i].SHA)
+			if err != nil {
+				return added, fmt.Errorf("error saving branch of commit %s : %v", commits[i].SHA, err)
+			}
+		}
+	}
+	return added, nil
+}
+
+func saveSyncCursor(db execer, c *SyncCursor) error {
+	insert := `insert into sync_cursors (
+		repository_id,
+		branch,
+		newest_sha,
+		newest_date,
+		backfill_url,
+		updated_at
+	) values ($1,$2,$3,$4,$5,$6)
+	ON CONFLICT (repository_id, branch) DO UPDATE SET
+		newest_sha=$3,
+		newest_date=$4,
+		backfill_url=$5,
+		updated_at=$6
+	`
+
+	_, err := db.Exec(insert, c.RepositoryID, c.Branch, c.NewestSHA, c.NewestDate.UTC(), c.BackfillURL, c.Updated.UTC())
+	return err
+}
+
+// GetResourceCursor returns the update time up to which a resource of the repository has been synced, zero if it never was
+func (s *sqlStore) GetResourceCursor(repo_id int, resource string) (time.Time, error) {
+	var t time.Time
+	err := s.db.QueryRow("SELECT synced_until FROM resource_cursors WHERE repository_id=$1 AND resource=$2",
+		repo_id, resource).Scan(&t)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return t, err
+}
+
+// SaveResourceCursor records the update time up to which a resource of the repository has been synced
+func (s *sqlStore) SaveResourceCursor(repo_id int, resource string, until time.Time) error {
+	_, err := s.db.Exec(`insert into resource_cursors (repository_id, resource, synced_until, updated_at)
+		values ($1,$2,$3,$4)
+		ON CONFLICT (repository_id, resource) DO UPDATE SET
+			synced_until=$3,
+			updated_at=$4`,
+		repo_id, resource, until.UTC(), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCommits serves the commits listing of a repository, newest first, pageSize commits a page
+type fakeCommits struct {
+	mu      sync.Mutex
+	commits []string
+	// branches are the commits of the other branches, by name
+	branches map[string][]string
+	pageSize int
+	// failPage makes the next request for that page fail, 0 for none
+	failPage int
+	requests int
+	notMod   int
+}
+
+func (f *fakeCommits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	if page == f.failPage {
+		f.failPage = 0
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	commits := f.commits
+	if b, ok := f.branches[r.URL.Query().Get("sha")]; ok {
+		commits = b
+	}
+
+	etag := fmt.Sprintf(`"%s-%d"`, commits[0], page)
+	if r.Header.Get("If-None-Match") == etag {
+		f.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	start, end := (page-1)*f.pageSize, page*f.pageSize
+	if end < len(commits) {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, next.Encode()))
+	} else {
+		end = len(commits)
+	}
+
+	response := []githubCommit{}
+	for _, sha := range commits[start:end] {
+		c := githubCommit{SHA: sha, URL: "https://github.com/org/repo/commit/" + sha}
+		c.Commit.Message = "commit " + sha
+		c.Commit.Author.Name = "Jane Doe"
+		c.Commit.Author.Email = "jane@example.com"
+		c.Commit.Author.Date = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		response = append(response, c)
+	}
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeCommits) set(commits ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commits = commits
+}
+
+func TestSyncCommitsCatchUp(t *testing.T) {
+	s := newTestStore(t)
+	fake := &fakeCommits{pageSize: 2}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+
+	stored := func() string {
+		t.Helper()
+		commits, err := s.ListCommits(repo.ID, CommitFilter{Branch: "main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every commit has the same date, they're listed by sha
+		return shas(commits)
+	}
+
+	// initial sync backfills the whole history
+	fake.set("5", "4", "3", "2", "1")
+	_, err := SyncCommits(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored() != "54321" {
+		t.Fatalf("stored after backfill = %q", stored())
+	}
+
+	// nothing new, the second sync keeps the validators of the head page and the third gets a 304
+	for i := 0; i < 2; i++ {
+		_, err = SyncCommits(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.notMod != 1 {
+		t.Errorf("304 responses = %d, want 1", fake.notMod)
+	}
+
+	// new commits on top, along with an older one merged in that's listed after the newest synced commit,
+	// and the second page fails the first time
+	fake.set("8", "7", "6", "5", "m", "4", "3", "2", "1")
+	fake.failPage = 2
+	_, err = SyncCommits(repo)
+	if err == nil {
+		t.Fatal("sync with a failing page succeeded")
+	}
+	cursor, err := s.GetSyncCursor(repo.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.NewestSHA != "5" {
+		t.Errorf("cursor moved to %s after a failed catch up", cursor.NewestSHA)
+	}
+
+	// the next sync fetches the head page again rather than taking it as unchanged
+	result, err := SyncCommits(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored() != "m87654321" {
+		t.Errorf("stored after catch up = %q, want m87654321", stored())
+	}
+	if result.Added != 2 {
+		t.Errorf("added = %d, want 2, the first page was stored by the failed sync", result.Added)
+	}
+	cursor, err = s.GetSyncCursor(repo.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.NewestSHA != "8" {
+		t.Errorf("cursor = %s, want 8", cursor.NewestSHA)
+	}
+
+	// paging stops once the commits past the newest synced one are all stored, here on the second page since
+	// the newest synced commit ends the first one
+	fake.set("9", "8", "7", "6", "5", "m", "4", "3", "2", "1")
+	requests := fake.requests
+	result, err = SyncCommits(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 1 || fake.requests-requests != 2 {
+		t.Errorf("added %d with %d requests, want 1 with 2", result.Added, fake.requests-requests)
+	}
+}
+
+func TestResyncCommits(t *testing.T) {
+	s := newTestStore(t)
+	fake := &fakeCommits{pageSize: 2, branches: map[string][]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo := newTestRepo(t, s, "repo")
+	repo.URL = srv.URL + "/repos/org/repo"
+	err := s.SaveBranches(repo.ID, []Branch{{Name: "main", Default: true}, {Name: "feature"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.TrackBranch(repo.ID, "feature", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// x was force pushed away
+	_, err = s.SaveCommits("main", []Commit{testCommit(repo.ID, "x", 0)}, &SyncCursor{RepositoryID: repo.ID, NewestSHA: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(branch string) string {
+		t.Helper()
+		commits, err := s.ListCommits(repo.ID, CommitFilter{Branch: branch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return shas(commits)
+	}
+
+	fake.set("3", "2", "1")
+	fake.branches["feature"] = []string{"f", "2", "1"}
+	result, err := ResyncCommits(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 4 || result.Fetched != 6 {
+		t.Errorf("added %d of %d fetched, want 4 of 6", result.Added, result.Fetched)
+	}
+	for branch, want := range map[string]string{"": "f321", "main": "321", "feature": "f21"} {
+		if stored(branch) != want {
+			t.Errorf("commits of %q = %q, want %q", branch, stored(branch), want)
+		}
+	}
+	for branch, want := range map[string]string{"": "3", "feature": "f"} {
+		cursor, err := s.GetSyncCursor(repo.ID, branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor == nil || cursor.NewestSHA != want {
+			t.Errorf("cursor of %q = %+v, want newest %s", branch, cursor, want)
+		}
+	}
+
+	// a failure halfway leaves the stored history as it was
+	fake.set("4", "3", "2", "1")
+	fake.failPage = 2
+	_, err = ResyncCommits(repo)
+	if err == nil {
+		t.Fatal("resync with a failing page succeeded")
+	}
+	if stored("") != "f321" {
+		t.Errorf("commits after a failed resync = %q, want f321", stored(""))
+	}
+}
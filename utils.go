@@ -17,9 +17,19 @@ func NewRequest(method string, url string, body io.Reader) (*http.Request, error
 	// set request headers
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 	req.Header.Set("accept", "application/json")
-	// req.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=UTF-8")
-	// basicAuthString := base64.StdEncoding.EncodeToString([]byte(client.ClientId + ":" + client.ClientSecret))
-	// req.Header.Set("Authorization", "Basic "+basicAuthString)
+
+	// authenticate with the credentials configured for the host, if any
+	host := DefaultHost.Name
+	if h, err := HostForAPIURL(url); err == nil {
+		host = h.Name
+	}
+	if p := CredentialsFor(host); p != nil {
+		token, err := p.Token()
+		if err != nil {
+			return &http.Request{}, fmt.Errorf("error getting github credentials : %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	return req, nil
 }
@@ -35,24 +45,19 @@ func GetNextFromLinkHeader(lh string) string {
 	}
 
 	// filter out < & >
-	next = next[1 : len(next)-1]
+	next = strings.TrimSpace(next)
+	next = strings.TrimPrefix(next, "<")
+	next = strings.TrimSuffix(next, ">")
 
 	return next
 }
 
 // SanitizeRepoURL formulates a proper github api url if not already
 func SanitizeRepoURL(url string) (string, error) {
-	if strings.Contains(url, "api.github.com") {
-		// it already points to the api
-		return url, nil
-	}
-
-	// split the url and get the path part
-	parts := strings.Split(url, "github.com")
-	if len(parts) < 2 || parts[1] == "" {
-		return "", fmt.Errorf("invalid url provided")
+	h, owner, name, err := ParseRepoURL(url)
+	if err != nil {
+		return "", err
 	}
-	url = "https://api.github.com/repos" + parts[1]
 
-	return url, nil
+	return h.RepoURL(owner, name), nil
 }
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestGetNextFromLinkHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{`<https://api.github.com/repositories/1/commits?page=2>; rel="next", <https://api.github.com/repositories/1/commits?page=5>; rel="last"`,
+			"https://api.github.com/repositories/1/commits?page=2"},
+		{`<https://api.github.com/repositories/1/commits?page=1>; rel="prev", <https://api.github.com/repositories/1/commits?page=3>; rel="next"`,
+			"https://api.github.com/repositories/1/commits?page=3"},
+		// the last page
+		{`<https://api.github.com/repositories/1/commits?page=1>; rel="first", <https://api.github.com/repositories/1/commits?page=4>; rel="prev"`, ""},
+	}
+	for _, tt := range tests {
+		if got := GetNextFromLinkHeader(tt.header); got != tt.want {
+			t.Errorf("GetNextFromLinkHeader(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
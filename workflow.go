@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Workflow is a github actions workflow of a repository
+type Workflow struct {
+	ID           int64  `json:"id" db:"id"`
+	RepositoryID int    `json:"repository_id" db:"repository_id"`
+	Name         string `json:"name" db:"name"`
+	Path         string `json:"path" db:"path"`
+	State        string `json:"state" db:"state"`
+}
+
+// WorkflowRun is an attempt at running a workflow, a re-run is another attempt of the same run. Completed is
+// set once the attempt is completed, Conclusion tells how it went then. The author and message are those of the
+// head commit when it's stored.
+type WorkflowRun struct {
+	ID            int64      `json:"id" db:"id"`
+	Attempt       int        `json:"attempt" db:"attempt"`
+	RepositoryID  int        `json:"repository_id" db:"repository_id"`
+	WorkflowID    int64      `json:"workflow_id" db:"workflow_id"`
+	Workflow      string     `json:"workflow" db:"name"`
+	Event         string     `json:"event" db:"event"`
+	Branch        string     `json:"branch" db:"branch"`
+	HeadSHA       string     `json:"head_sha" db:"head_sha"`
+	Status        string     `json:"status" db:"status"`
+	Conclusion    string     `json:"conclusion" db:"conclusion"`
+	Created       time.Time  `json:"created_at" db:"created_at"`
+	Started       *time.Time `json:"started_at" db:"started_at"`
+	Completed     *time.Time `json:"completed_at" db:"completed_at"`
+	CommitAuthor  string     `json:"commit_author,omitempty"`
+	CommitMessage string     `json:"commit_message,omitempty"`
+}
+
+// workflow run statuses and conclusions
+const (
+	RunCompleted      = "completed"
+	RunSuccess        = "success"
+	RunFailure        = "failure"
+	RunTimedOut       = "timed_out"
+	RunStartupFailure = "startup_failure"
+	RunCancelled      = "cancelled"
+	RunSkipped        = "skipped"
+)
+
+// failed tells whether the attempt completed with a failure
+func (r WorkflowRun) failed() bool {
+	return r.Conclusion == RunFailure || r.Conclusion == RunTimedOut || r.Conclusion == RunStartupFailure
+}
+
+// Duration returns how long the attempt ran, zero until it's completed
+func (r WorkflowRun) Duration() time.Duration {
+	if r.Started == nil || r.Completed == nil {
+		return 0
+	}
+	return r.Completed.Sub(*r.Started)
+}
+
+// runsResource names the workflow runs in the resource_cursors table
+const runsResource = "runs"
+
+// workflowRunsLookback is how far before the last sync the runs are listed again, to catch the re-runs of
+// runs that had completed
+const workflowRunsLookback = 7 * 24 * time.Hour
+
+// workflowRunsDays reads how many days of runs the first sync of a repository fetches from WORKFLOW_RUNS_DAYS,
+// 90 by default, 0 for every run
+func workflowRunsDays() int {
+	days := os.Getenv("WORKFLOW_RUNS_DAYS")
+	i := 90
+	if days != "" {
+		val, err := strconv.Atoi(days)
+		if err != nil || val < 0 {
+			LogError(fmt.Errorf("error parsing WORKFLOW_RUNS_DAYS env variable : %v", days))
+			return i
+		}
+		i = val
+	}
+	return i
+}
+
+// SyncWorkflowRuns fetches the workflows of the repository and the runs created since the last sync, along
+// with the earlier attempts of the re-run ones. The runs of the last week are listed again on every sync so
+// the ones still running and the re-runs are caught, the ones already stored as completed are skipped. It
+// returns how many attempts were new or updated.
+func SyncWorkflowRuns(repo *Repository) (int, error) {
+	defer syncLocks.lock(repo.ID)()
+	return syncWorkflowRuns(repo)
+}
+
+// syncWorkflowRuns is SyncWorkflowRuns for callers already holding the repository lock
+func syncWorkflowRuns(repo *Repository) (int, error) {
+	workflows, err := fetchWorkflows(repo)
+	if err != nil {
+		return 0, err
+	}
+	err = store.SaveWorkflows(repo.ID, workflows)
+	if err != nil {
+		LogError(fmt.Errorf("error saving workflows : %v", err))
+		return 0, err
+	}
+
+	since, err := store.GetResourceCursor(repo.ID, runsResource)
+	if err != nil {
+		LogError(fmt.Errorf("error getting workflow runs cursor : %v", err))
+		return 0, err
+	}
+	started := time.Now()
+	if since.IsZero() && workflowRunsDays() > 0 {
+		since = started.AddDate(0, 0, -workflowRunsDays())
+	}
+
+	stored, err := store.GetWorkflowRuns(repo.ID, WorkflowRunFilter{Since: &since})
+	if err != nil {
+		return 0, err
+	}
+	// completed tells which attempts of which runs are stored as completed
+	completed := map[int64]map[int]bool{}
+	for _, r := range stored {
+		if completed[r.ID] == nil {
+			completed[r.ID] = map[int]bool{}
+		}
+		completed[r.ID][r.Attempt] = r.Status == RunCompleted
+	}
+
+	params := url.Values{}
+	params.Set("per_page", "100")
+	if !since.IsZero() {
+		params.Set("created", ">="+since.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	URL := repo.URL + "/actions/runs?" + params.Encode()
+
+	updated := 0
+	cursor := started.Add(-workflowRunsLookback)
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with workflow runs request : %v", err))
+			return updated, err
+		}
+
+		response := struct {
+			Runs []githubRun `json:"workflow_runs"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing workflow runs : %v", err))
+			return updated, err
+		}
+
+		for _, r := range response.Runs {
+			run := r.toRun(repo.ID)
+			if run.Status != RunCompleted && run.Created.Before(cursor) {
+				// list it again next time until it completes
+				cursor = run.Created
+			}
+			if completed[run.ID][run.Attempt] {
+				continue
+			}
+
+			runs := []WorkflowRun{run}
+			for attempt := 1; attempt < run.Attempt; attempt++ {
+				if _, ok := completed[run.ID][attempt]; ok {
+					continue
+				}
+				previous, err := fetchRunAttempt(repo, run.ID, attempt)
+				if err != nil {
+					return updated, err
+				}
+				runs = append(runs, *previous)
+			}
+
+			for i := range runs {
+				err = store.SaveWorkflowRun(&runs[i])
+				if err != nil {
+					LogError(fmt.Errorf("error saving workflow run %d : %v", runs[i].ID, err))
+					return updated, err
+				}
+				updated++
+			}
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	err = store.SaveResourceCursor(repo.ID, runsResource, cursor)
+	if err != nil {
+		LogError(fmt.Errorf("error saving workflow runs cursor : %v", err))
+		return updated, err
+	}
+
+	return updated, nil
+}
+
+// githubRun is a workflow run as the actions api returns it
+type githubRun struct {
+	ID         int64      `json:"id"`
+	Attempt    int        `json:"run_attempt"`
+	WorkflowID int64      `json:"workflow_id"`
+	Name       string     `json:"name"`
+	Event      string     `json:"event"`
+	Branch     string     `json:"head_branch"`
+	HeadSHA    string     `json:"head_sha"`
+	Status     string     `json:"status"`
+	Conclusion *string    `json:"conclusion"`
+	Created    time.Time  `json:"created_at"`
+	Updated    time.Time  `json:"updated_at"`
+	Started    *time.Time `json:"run_started_at"`
+}
+
+// toRun converts the api run, a completed run was last updated when it completed
+func (r githubRun) toRun(repo_id int) WorkflowRun {
+	run := WorkflowRun{
+		ID:           r.ID,
+		Attempt:      r.Attempt,
+		RepositoryID: repo_id,
+		WorkflowID:   r.WorkflowID,
+		Workflow:     r.Name,
+		Event:        r.Event,
+		Branch:       r.Branch,
+		HeadSHA:      r.HeadSHA,
+		Status:       r.Status,
+		Created:      r.Created,
+		Started:      r.Started,
+	}
+	if run.Attempt == 0 {
+		run.Attempt = 1
+	}
+	if r.Conclusion != nil {
+		run.Conclusion = *r.Conclusion
+	}
+	if r.Status == RunCompleted {
+		updated := r.Updated
+		run.Completed = &updated
+	}
+	return run
+}
+
+// fetchWorkflows fetches the workflows of the repository
+func fetchWorkflows(repo *Repository) ([]Workflow, error) {
+	workflows := []Workflow{}
+	URL := repo.URL + "/actions/workflows?per_page=100"
+	for URL != "" {
+		resp, body, err := github.Get(URL)
+		if err != nil {
+			LogError(fmt.Errorf("error with workflows request : %v", err))
+			return nil, err
+		}
+
+		response := struct {
+			Workflows []Workflow `json:"workflows"`
+		}{}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			LogError(fmt.Errorf("error parsing workflows : %v", err))
+			return nil, err
+		}
+
+		for _, w := range response.Workflows {
+			w.RepositoryID = repo.ID
+			workflows = append(workflows, w)
+		}
+
+		URL = GetNextFromLinkHeader(resp.Header.Get("link"))
+	}
+
+	return workflows, nil
+}
+
+// fetchRunAttempt fetches an earlier attempt of a re-run workflow run
+func fetchRunAttempt(repo *Repository, id int64, attempt int) (*WorkflowRun, error) {
+	URL := fmt.Sprintf("%s/actions/runs/%d/attempts/%d", repo.URL, id, attempt)
+	_, body, err := github.Get(URL)
+	if err != nil {
+		LogError(fmt.Errorf("error with workflow run attempt request : %v", err))
+		return nil, err
+	}
+
+	response := githubRun{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		LogError(fmt.Errorf("error parsing workflow run attempt : %v", err))
+		return nil, err
+	}
+
+	run := response.toRun(repo.ID)
+	run.Attempt = attempt
+	return &run, nil
+}
+
+// SaveWorkflows upserts the workflows of the repository
+func (s *sqlStore) SaveWorkflows(repo_id int, workflows []Workflow) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, w := range workflows {
+		_, err = tx.Exec(`INSERT INTO workflows (id, repository_id, name, path, state) VALUES ($1,$2,$3,$4,$5)
+			ON CONFLICT (id) DO UPDATE SET
+				name=$3,
+				path=$4,
+				state=$5`,
+			w.ID, repo_id, w.Name, w.Path, w.State)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetWorkflows returns the workflows of the repository by name
+func (s *sqlStore) GetWorkflows(repo_id int) ([]Workflow, error) {
+	rows, err := s.db.Query("SELECT id, repository_id, name, path, state FROM workflows WHERE repository_id=$1 ORDER BY name, id", repo_id)
+	if err != nil {
+		LogError(fmt.Errorf("error getting workflows from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	workflows := []Workflow{}
+	for rows.Next() {
+		w := Workflow{}
+		err = rows.Scan(&w.ID, &w.RepositoryID, &w.Name, &w.Path, &w.State)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning workflow : %v", err))
+			return nil, err
+		}
+		workflows = append(workflows, w)
+	}
+
+	return workflows, rows.Err()
+}
+
+// SaveWorkflowRun upserts an attempt of a workflow run
+func (s *sqlStore) SaveWorkflowRun(r *WorkflowRun) error {
+	_, err := s.db.Exec(`INSERT INTO workflow_runs (id, attempt, repository_id, workflow_id, name, event, branch,
+			head_sha, status, conclusion, created_at, started_at, completed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		ON CONFLICT (id, attempt) DO UPDATE SET
+			status=$9,
+			conclusion=$10,
+			started_at=$12,
+			completed_at=$13`,
+		r.ID, r.Attempt, r.RepositoryID, r.WorkflowID, r.Workflow, r.Event, r.Branch,
+		r.HeadSHA, r.Status, r.Conclusion, r.Created.UTC(), utcOrNil(r.Started), utcOrNil(r.Completed))
+	return err
+}
+
+// WorkflowRunFilter selects workflow runs, zero fields don't filter. Since and Until bound when they were created.
+type WorkflowRunFilter struct {
+	Workflow string
+	Branch   string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+}
+
+// GetWorkflowRuns returns every attempt of the workflow runs of the repository matching the filter, latest created
+// first, along with the author and message of their head commit when it's stored
+func (s *sqlStore) GetWorkflowRuns(repo_id int, f WorkflowRunFilter) ([]WorkflowRun, error) {
+	q := newQuery(`SELECT r.id, r.attempt, r.repository_id, r.workflow_id, r.name, r.event, r.branch, r.head_sha,
+			r.status, r.conclusion, r.created_at, r.started_at, r.completed_at,
+			COALESCE(c.author_name, ''), COALESCE(c.message, '')
+		FROM workflow_runs r LEFT JOIN commits c ON c.sha = r.head_sha
+		WHERE r.repository_id=$1`, repo_id)
+	if f.Workflow != "" {
+		q.where("r.name = $%d", f.Workflow)
+	}
+	if f.Branch != "" {
+		q.where("r.branch = $%d", f.Branch)
+	}
+	if f.Since != nil && !f.Since.IsZero() {
+		q.where("r.created_at >= $%d", f.Since.UTC())
+	}
+	if f.Until != nil {
+		q.where("r.created_at < $%d", f.Until.UTC())
+	}
+	q.sql += " ORDER BY r.created_at DESC, r.id DESC, r.attempt DESC"
+	if f.Limit > 0 {
+		q.sql += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.Query(q.sql, q.args...)
+	if err != nil {
+		LogError(fmt.Errorf("error getting workflow runs from db : %v", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []WorkflowRun{}
+	for rows.Next() {
+		r := WorkflowRun{}
+		err = rows.Scan(&r.ID, &r.Attempt, &r.RepositoryID, &r.WorkflowID, &r.Workflow, &r.Event, &r.Branch, &r.HeadSHA,
+			&r.Status, &r.Conclusion, &r.Created, &r.Started, &r.Completed, &r.CommitAuthor, &r.CommitMessage)
+		if err != nil {
+			LogError(fmt.Errorf("error scanning workflow run : %v", err))
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}